@@ -0,0 +1,103 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/kha7iq/pingme/service/notifier"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+)
+
+// sent records the messages of the fake service by destination name
+var sent = struct {
+	sync.Mutex
+	messages map[string][]notifier.Message
+}{messages: make(map[string][]notifier.Message)}
+
+// fakeNotifier records messages under its name, "fake-fail" fails
+type fakeNotifier struct {
+	name string
+}
+
+func (f fakeNotifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	if f.name == "fake-fail" {
+		return notifier.Result{}, errors.New("invalid token")
+	}
+	sent.Lock()
+	defer sent.Unlock()
+	sent.messages[f.name] = append(sent.messages[f.name], msg)
+	return notifier.Result{Service: "fake"}, nil
+}
+
+func init() {
+	for _, name := range []string{"fake", "fake-fail"} {
+		name := name
+		notifier.Register(notifier.Service{
+			Name: name,
+			New: func(src notifier.Source) (notifier.Notifier, error) {
+				// targets record their messages under their "name" setting
+				if n := src("name", ""); n != "" {
+					return fakeNotifier{name: n}, nil
+				}
+				return fakeNotifier{name: name}, nil
+			},
+		})
+	}
+}
+
+// received returns and forgets the messages sent to name
+func received(name string) []notifier.Message {
+	sent.Lock()
+	defer sent.Unlock()
+	msgs := sent.messages[name]
+	delete(sent.messages, name)
+	return msgs
+}
+
+// writeConfig writes a config file with content and returns its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pingme.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// run runs the pingme commands with args and returns their output
+func run(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	app := &cli.App{
+		Name:           "pingme",
+		Writer:         &out,
+		ErrWriter:      io.Discard,
+		ExitErrHandler: func(*cli.Context, error) {},
+		Flags:          []cli.Flag{&cli.StringFlag{Name: "config"}},
+		Commands:       []*cli.Command{Send(), Route(), HashSecret()},
+	}
+	err := app.Run(append([]string{"pingme"}, args...))
+	return out.String(), err
+}
+
+// pipeStdin replaces os.Stdin with a pipe containing content
+func pipeStdin(t *testing.T, content string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	_, err = w.WriteString(content)
+	assert.Nil(t, err)
+	w.Close()
+
+	orig := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = orig
+		r.Close()
+	})
+}
@@ -0,0 +1,101 @@
+package command
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kha7iq/pingme/internal/secret"
+
+	"github.com/urfave/cli/v2"
+)
+
+// hashSecretOpts holds data parsed via flags for the hash-secret command.
+type hashSecretOpts struct {
+	Algorithm string
+	Generate  bool
+}
+
+// HashSecret returns the command hashing API keys and passwords for the
+// webhook server.
+func HashSecret() *cli.Command {
+	var opts hashSecretOpts
+	return &cli.Command{
+		Name:  "hash-secret",
+		Usage: "Hash an API key or password for the webhook server",
+		UsageText: "echo -n 'password' | pingme hash-secret\n" +
+			"pingme hash-secret --generate --algorithm sha256",
+		Description: `Hash-secret reads a secret from stdin and prints its hash, which can be used
+in place of the plaintext secret for api_keys in the config file and for
+PINGME_API_KEYS and PINGME_BASIC_PASS. Use sha256 for long random API keys and
+bcrypt or argon2id for passwords. With --generate a random API key is created
+and printed together with its hash.`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Destination: &opts.Algorithm,
+				Name:        "algorithm",
+				Aliases:     []string{"a"},
+				Value:       secret.Bcrypt,
+				Usage:       "Hash algorithm: sha256, bcrypt or argon2id.",
+			},
+			&cli.BoolFlag{
+				Destination: &opts.Generate,
+				Name:        "generate",
+				Aliases:     []string{"g"},
+				Usage:       "Generate a random API key instead of reading one from stdin.",
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			var (
+				plain string
+				err   error
+			)
+			if opts.Generate {
+				plain, err = generateKey()
+			} else {
+				plain, err = readSecret(os.Stdin)
+			}
+			if err != nil {
+				return err
+			}
+
+			hash, err := secret.Hash(opts.Algorithm, plain)
+			if err != nil {
+				return err
+			}
+			if opts.Generate {
+				fmt.Fprintf(ctx.App.Writer, "Key:  %s\nHash: %s\n", plain, hash)
+				return nil
+			}
+			fmt.Fprintln(ctx.App.Writer, hash)
+			return nil
+		},
+	}
+}
+
+// readSecret reads the first line of r without the line break.
+func readSecret(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read secret: %w", err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", errors.New("no secret given on stdin")
+	}
+	return line, nil
+}
+
+// generateKey returns a random API key with 256 bits of entropy.
+func generateKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
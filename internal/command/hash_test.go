@@ -0,0 +1,40 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kha7iq/pingme/internal/secret"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashSecret(t *testing.T) {
+	for _, algorithm := range []string{secret.SHA256, secret.Bcrypt, secret.Argon2id} {
+		pipeStdin(t, "s3cret\n")
+		out, err := run(t, "hash-secret", "--algorithm", algorithm)
+		assert.Nil(t, err)
+		hash := strings.TrimSpace(out)
+		assert.True(t, secret.Verify(hash, "s3cret"), algorithm)
+		assert.False(t, secret.Verify(hash, "s3cret\n"), "the line break is not part of the secret")
+	}
+
+	pipeStdin(t, "")
+	_, err := run(t, "hash-secret")
+	assert.ErrorContains(t, err, "no secret given on stdin")
+
+	pipeStdin(t, "s3cret")
+	_, err = run(t, "hash-secret", "--algorithm", "md5")
+	assert.NotNil(t, err)
+}
+
+func TestHashSecret_Generate(t *testing.T) {
+	out, err := run(t, "hash-secret", "--generate", "-a", secret.SHA256)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if assert.Len(t, lines, 2) {
+		key := strings.TrimPrefix(lines[0], "Key:  ")
+		hash := strings.TrimPrefix(lines[1], "Hash: ")
+		assert.Len(t, key, 43)
+		assert.True(t, secret.Verify(hash, key))
+	}
+}
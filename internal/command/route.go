@@ -0,0 +1,223 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/config"
+	"github.com/kha7iq/pingme/internal/dispatcher"
+	"github.com/kha7iq/pingme/internal/handlers"
+	"github.com/kha7iq/pingme/internal/routing"
+	"github.com/kha7iq/pingme/internal/server"
+	"github.com/kha7iq/pingme/internal/types"
+
+	"github.com/urfave/cli/v2"
+)
+
+// routeTestOpts holds data parsed via flags for the route test command.
+type routeTestOpts struct {
+	Payload string
+	Source  string
+	Time    string
+	Headers cli.StringSlice
+}
+
+// Route returns the command inspecting the routes of the config file.
+func Route() *cli.Command {
+	return &cli.Command{
+		Name:        "route",
+		Usage:       "Inspect the routing rules of the config file",
+		Subcommands: []*cli.Command{routeTest()},
+	}
+}
+
+func routeTest() *cli.Command {
+	var opts routeTestOpts
+	return &cli.Command{
+		Name:  "test",
+		Usage: "Show the routes and targets a sample payload would be sent to",
+		UsageText: "pingme --config pingme.yaml route test --payload request.json\n" +
+			"pingme --config pingme.yaml route test --source /webhook/alertmanager --payload alert.json",
+		Description: `Test converts the payload like the webhook server would when it is posted to
+--source, matches it against the routes of the config file and prints every
+matching route and the resulting destination. Nothing is sent.
+Integration secrets are not verified.`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Destination: &opts.Payload,
+				Name:        "payload",
+				Aliases:     []string{"f"},
+				Required:    true,
+				Usage:       "File with the JSON payload, '-' reads from stdin.",
+			},
+			&cli.StringFlag{
+				Destination: &opts.Source,
+				Name:        "source",
+				Value:       "/webhook",
+				Usage:       "Path the payload is posted to, including query parameters, e.g. '/webhook/grafana?split=true'.",
+			},
+			&cli.StringFlag{
+				Destination: &opts.Time,
+				Name:        "time",
+				Usage:       "Evaluate time based routes at this time (RFC 3339) instead of now.",
+			},
+			&cli.StringSliceFlag{
+				Destination: &opts.Headers,
+				Name:        "header",
+				Aliases:     []string{"H"},
+				Usage:       "Request header as 'Name: value', e.g. 'X-GitHub-Event: push', can be repeated.",
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			cfg, err := config.Load(ctx.String("config"))
+			if err != nil {
+				return err
+			}
+
+			at := time.Now()
+			if opts.Time != "" {
+				if at, err = time.Parse(time.RFC3339, opts.Time); err != nil {
+					return fmt.Errorf("invalid --time: %w", err)
+				}
+			}
+
+			payload, err := readPayload(opts.Payload)
+			if err != nil {
+				return err
+			}
+
+			r, err := http.NewRequest(http.MethodPost, opts.Source, bytes.NewReader(payload))
+			if err != nil {
+				return fmt.Errorf("invalid --source: %w", err)
+			}
+			for _, h := range opts.Headers.Value() {
+				k, v, ok := strings.Cut(h, ":")
+				if !ok {
+					return fmt.Errorf("invalid header %q, expected 'Name: value'", h)
+				}
+				r.Header.Set(strings.TrimSpace(k), strings.TrimSpace(v))
+			}
+
+			reqs, endpoint, err := convertPayload(cfg, r, payload)
+			if err != nil {
+				return err
+			}
+			if len(reqs) == 0 {
+				fmt.Fprintln(ctx.App.Writer, "The payload results in no message.")
+				return nil
+			}
+
+			for i := range reqs {
+				req := &reqs[i]
+				req.Source = r.URL.Path
+				results := cfg.Router().Match(routing.Message{
+					Source:   req.Source,
+					Priority: req.Priority,
+					Extra:    req.Extra,
+					Time:     at,
+				})
+
+				var reason string
+				if endpoint == nil {
+					reason = webhookDestination(req, results)
+				} else {
+					reason = handlers.ChooseDestination(r.URL.Query(), endpoint.Targets, endpoint.Hook, routing.Targets(results), req)
+				}
+				printRoute(ctx.App.Writer, i+1, req, results, reason)
+			}
+			return nil
+		},
+	}
+}
+
+// readPayload reads the payload from path, or from stdin if path is "-".
+func readPayload(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payload: %w", err)
+	}
+	return data, nil
+}
+
+// convertPayload converts the payload with the integration or hook serving
+// the request path. Payloads for /webhook are parsed as webhook requests
+// and no endpoint is returned.
+func convertPayload(cfg *config.Config, r *http.Request, payload []byte) ([]types.WebhookRequest, *server.Endpoint, error) {
+	if r.URL.Path == "/webhook" {
+		var req types.WebhookRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return []types.WebhookRequest{req}, nil, nil
+	}
+
+	endpoints, err := server.Endpoints(cfg, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	endpoint, ok := server.FindEndpoint(endpoints, r.URL.Path)
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown source %s", r.URL.Path)
+	}
+	reqs, err := endpoint.Source(r, payload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s payload: %w", endpoint.Name, err)
+	}
+	return reqs, &endpoint, nil
+}
+
+// webhookDestination routes a /webhook request like the webhook server.
+func webhookDestination(req *types.WebhookRequest, results []routing.Result) string {
+	if dispatcher.HasDestination(req) {
+		return "request"
+	}
+	if targets := routing.Targets(results); len(targets) > 0 {
+		dispatcher.SetDestination(req, targets)
+		return handlers.ByRoutes
+	}
+	return ""
+}
+
+// printRoute writes the matching routes and the destination of message n.
+func printRoute(w io.Writer, n int, req *types.WebhookRequest, results []routing.Result, reason string) {
+	fmt.Fprintf(w, "Message %d: %s\n", n, req.Title)
+
+	if len(results) == 0 {
+		fmt.Fprintln(w, "No route matched.")
+	} else {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ROUTE\tTARGETS")
+		for _, res := range results {
+			fmt.Fprintf(tw, "%s\t%s\n", res.Route, strings.Join(res.Targets, ","))
+		}
+		tw.Flush()
+	}
+
+	if reason == "" {
+		fmt.Fprintln(w, "Destination: none, the message would be rejected")
+	} else {
+		fmt.Fprintf(w, "Destination: %s (%s)\n", destinationOf(req), reason)
+	}
+	fmt.Fprintln(w)
+}
+
+// destinationOf formats the destination of req.
+func destinationOf(req *types.WebhookRequest) string {
+	if len(req.Services) > 0 {
+		return strings.Join(req.Services, ",")
+	}
+	if req.Target != "" {
+		return req.Target
+	}
+	return req.Service
+}
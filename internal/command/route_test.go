@@ -0,0 +1,100 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteTest(t *testing.T) {
+	path := writeConfig(t, `
+targets:
+  ops:
+    service: fake
+  oncall:
+    service: fake
+routes:
+  - match:
+      priority: ">=2"
+    targets: [oncall]
+  - match:
+      time: "22:00-07:00"
+      timezone: UTC
+    targets: [oncall]
+  - targets: [ops]
+`)
+	dir := t.TempDir()
+	payload := func(content string) string {
+		p := filepath.Join(dir, "payload.json")
+		assert.Nil(t, os.WriteFile(p, []byte(content), 0o600))
+		return p
+	}
+
+	tests := []struct {
+		name    string
+		payload string
+		args    []string
+		want    []string
+	}{
+		{
+			name:    "priority",
+			payload: `{"title": "Disk full", "priority": 2}`,
+			args:    []string{"--time", "2024-05-06T12:00:00Z"},
+			want:    []string{"Message 1: Disk full", `routes\[0\]\s+oncall`, `Destination: oncall \(routes\)`},
+		},
+		{
+			name:    "time",
+			payload: `{"title": "Disk full"}`,
+			args:    []string{"--time", "2024-05-06T23:00:00Z"},
+			want:    []string{`routes\[1\]\s+oncall`, `Destination: oncall \(routes\)`},
+		},
+		{
+			name:    "fallback",
+			payload: `{"title": "Disk full"}`,
+			args:    []string{"--time", "2024-05-06T12:00:00Z"},
+			want:    []string{`routes\[2\]\s+ops`, `Destination: ops \(routes\)`},
+		},
+		{
+			name:    "request",
+			payload: `{"title": "Disk full", "service": "slack"}`,
+			want:    []string{`Destination: slack \(request\)`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"--config", path, "route", "test", "--payload", payload(tt.payload)}, tt.args...)
+			out, err := run(t, args...)
+			assert.Nil(t, err)
+			for _, want := range tt.want {
+				assert.Regexp(t, want, out)
+			}
+		})
+	}
+	assert.Empty(t, received("fake"), "nothing is sent")
+}
+
+func TestRouteTest_Errors(t *testing.T) {
+	path := writeConfig(t, "routes:\n  - targets: [fake]\n")
+	payload := filepath.Join(t.TempDir(), "payload.json")
+	assert.Nil(t, os.WriteFile(payload, []byte(`{"title": "Disk full"}`), 0o600))
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--payload", payload, "--time", "tomorrow"}, "invalid --time"},
+		{[]string{"--payload", filepath.Join(t.TempDir(), "missing.json")}, "failed to read payload"},
+		{[]string{"--payload", payload, "--source", "/webhook/unknown"}, "unknown source /webhook/unknown"},
+		{[]string{"--payload", payload, "-H", "X-GitHub-Event"}, "invalid header"},
+	} {
+		_, err := run(t, append([]string{"--config", path, "route", "test"}, tt.args...)...)
+		assert.ErrorContains(t, err, tt.want)
+	}
+
+	pipeStdin(t, `{"title": "Disk full"}`)
+	out, err := run(t, "--config", path, "route", "test", "--payload", "-")
+	assert.Nil(t, err)
+	assert.Contains(t, out, "Destination: fake (routes)")
+}
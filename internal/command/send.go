@@ -0,0 +1,216 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/config"
+	"github.com/kha7iq/pingme/internal/dispatcher"
+	"github.com/kha7iq/pingme/internal/render"
+	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
+
+	"github.com/urfave/cli/v2"
+)
+
+// sendOpts holds data parsed via flags for the send command.
+type sendOpts struct {
+	To           string
+	Message      string
+	MessageFile  string
+	Title        string
+	Priority     int
+	FailOn       string
+	Template     string
+	TemplateFile string
+	Vars         cli.StringSlice
+}
+
+// Send returns the command sending a message to one or more targets from
+// the config file or services configured through environment variables.
+func Send() *cli.Command {
+	var opts sendOpts
+	return &cli.Command{
+		Name:  "send",
+		Usage: "Send message to multiple services or targets at once",
+		UsageText: "pingme send --to 'slack,telegram,email' --msg 'some message'\n" +
+			"pingme --config pingme.yaml send --to 'ops-slack' --msg 'some message'",
+		Description: `Send delivers a message concurrently to every destination given with --to.
+A destination is either a named target from the config file or a service name,
+services read their credentials from the usual environment variables.
+The result of each delivery is printed as a table, --fail-on controls whether
+the command fails when any or only when all deliveries failed.`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Destination: &opts.To,
+				Name:        "to",
+				Aliases:     []string{"target", "T"},
+				Required:    true,
+				Usage:       "Targets or services to send to, if multiple separate with ','.",
+				EnvVars:     []string{"PINGME_TO"},
+			},
+			&cli.StringFlag{
+				Destination: &opts.Message,
+				Name:        "msg",
+				Aliases:     []string{"m"},
+				Usage:       "Message content.",
+				EnvVars:     []string{"PINGME_MESSAGE"},
+			},
+			helpers.MessageFileFlag(&opts.MessageFile, "PINGME"),
+			&cli.StringFlag{
+				Destination: &opts.Title,
+				Name:        "title",
+				Value:       helpers.TimeValue,
+				Usage:       "Title of the message.",
+				EnvVars:     []string{"PINGME_TITLE"},
+			},
+			&cli.IntFlag{
+				Destination: &opts.Priority,
+				Name:        "priority",
+				Aliases:     []string{"p"},
+				Usage:       "Priority of the message, for services that support it.",
+				EnvVars:     []string{"PINGME_PRIORITY"},
+			},
+			&cli.StringFlag{
+				Destination: &opts.Template,
+				Name:        "template",
+				Usage:       "Go template rendering the message, a {{define \"title\"}} block renders the title.",
+				EnvVars:     []string{"PINGME_TEMPLATE"},
+			},
+			&cli.StringFlag{
+				Destination: &opts.TemplateFile,
+				Name:        "template-file",
+				Usage:       "Read the template from file.",
+				EnvVars:     []string{"PINGME_TEMPLATE_FILE"},
+			},
+			&cli.StringSliceFlag{
+				Destination: &opts.Vars,
+				Name:        "var",
+				Usage:       "Template variable as key=value, available as {{.Vars.key}}, can be repeated.",
+			},
+			&cli.StringFlag{
+				Destination: &opts.FailOn,
+				Name:        "fail-on",
+				Value:       string(dispatcher.FailAny),
+				Usage:       "Exit with an error if 'any' or only if 'all' deliveries failed.",
+				EnvVars:     []string{"PINGME_FAIL_ON"},
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			policy, err := dispatcher.ParseFailurePolicy(opts.FailOn)
+			if err != nil {
+				return err
+			}
+
+			destinations := splitList(opts.To)
+			if len(destinations) == 0 {
+				return helpers.ErrChannel
+			}
+
+			message, err := helpers.ReadMessage(opts.Message, opts.MessageFile)
+			if err != nil {
+				return err
+			}
+
+			cfg, err := config.Load(ctx.String("config"))
+			if err != nil {
+				return err
+			}
+			cfg.Retry = helpers.RetryPolicyFromFlags(ctx, cfg.Retry)
+
+			msg, err := renderMessage(&opts, notifier.Message{
+				Title:    opts.Title,
+				Body:     message,
+				Priority: opts.Priority,
+			})
+			if err != nil {
+				return err
+			}
+
+			results := dispatcher.New(cfg).Send(ctx.Context, destinations, msg)
+			printResults(ctx.App.Writer, results)
+
+			if policy.Failed(results) {
+				return cli.Exit(fmt.Sprintf("failed to send message (fail-on=%s)", policy), 1)
+			}
+			return nil
+		},
+	}
+}
+
+// renderMessage renders msg through the template given with --template or
+// --template-file, msg is returned unchanged when neither is set.
+func renderMessage(opts *sendOpts, msg notifier.Message) (notifier.Message, error) {
+	text := opts.Template
+	if opts.TemplateFile != "" {
+		data, err := os.ReadFile(opts.TemplateFile)
+		if err != nil {
+			return msg, fmt.Errorf("failed to read template file: %w", err)
+		}
+		text = string(data)
+	}
+	if text == "" {
+		return msg, nil
+	}
+
+	vars := make(map[string]string)
+	for _, kv := range opts.Vars.Value() {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return msg, fmt.Errorf("invalid template variable %q, expected key=value", kv)
+		}
+		vars[k] = v
+	}
+
+	tpl, err := render.Parse("send", text)
+	if err != nil {
+		return msg, err
+	}
+	return tpl.Apply(msg, vars, render.Environ())
+}
+
+// printResults writes a table with the outcome of each delivery to w.
+func printResults(w io.Writer, results []dispatcher.Delivery) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DESTINATION\tSTATUS\tATTEMPTS\tDURATION\tERROR")
+	for _, res := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", res.Destination, status(res), res.Attempts,
+			res.Duration.Round(time.Millisecond), res.Error)
+	}
+	tw.Flush()
+}
+
+// status describes the outcome of a delivery, "ok" only if the message was
+// sent.
+func status(res dispatcher.Delivery) string {
+	switch {
+	case !res.Success:
+		return "failed"
+	case res.Duplicate:
+		return "duplicate"
+	case res.Batched:
+		return "batched"
+	case res.OutOfHours == string(config.Drop):
+		return "dropped"
+	case res.OutOfHours == string(config.Defer):
+		return "deferred"
+	case res.OutOfHours == string(config.Reroute):
+		return "rerouted"
+	}
+	return "ok"
+}
+
+// splitList splits a comma-separated list dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
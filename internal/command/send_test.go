@@ -0,0 +1,129 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSend(t *testing.T) {
+	path := writeConfig(t, `
+targets:
+  ops:
+    service: fake
+    settings:
+      name: ops
+`)
+	out, err := run(t, "--config", path, "send", "--to", "fake, ops", "--title", "Deploy", "--msg", "done", "-p", "1")
+	assert.Nil(t, err)
+	assert.Regexp(t, `(?m)^fake\s+ok\s+1\s`, out)
+	assert.Regexp(t, `(?m)^ops\s+ok\s+1\s`, out)
+
+	for _, name := range []string{"fake", "ops"} {
+		msgs := received(name)
+		if assert.Len(t, msgs, 1, name) {
+			assert.Equal(t, "Deploy", msgs[0].Title)
+			assert.Equal(t, "done", msgs[0].Body)
+			assert.Equal(t, 1, msgs[0].Priority)
+		}
+	}
+}
+
+func TestSend_MessageSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "msg.txt")
+	assert.Nil(t, os.WriteFile(path, []byte("from file\n"), 0o600))
+	_, err := run(t, "send", "--to", "fake", "--msg-file", path)
+	assert.Nil(t, err)
+
+	pipeStdin(t, "from stdin\n")
+	_, err = run(t, "send", "--to", "fake", "--msg", "-")
+	assert.Nil(t, err)
+
+	msgs := received("fake")
+	if assert.Len(t, msgs, 2) {
+		assert.Equal(t, "from file", msgs[0].Body)
+		assert.Equal(t, "from stdin", msgs[1].Body)
+	}
+
+	_, err = run(t, "send", "--to", "fake", "--msg-file", filepath.Join(t.TempDir(), "missing.txt"))
+	assert.ErrorContains(t, err, "failed to open message file")
+}
+
+func TestSend_Template(t *testing.T) {
+	_, err := run(t, "send", "--to", "fake", "--title", "Deploy", "--msg", "done",
+		"--template", `{{define "title"}}[{{.Vars.env}}] {{.Title}}{{end}}{{.Message}} by {{.Vars.user}}`,
+		"--var", "env=prod", "--var", "user=alice")
+	assert.Nil(t, err)
+	msgs := received("fake")
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "[prod] Deploy", msgs[0].Title)
+		assert.Equal(t, "done by alice", msgs[0].Body)
+	}
+
+	_, err = run(t, "send", "--to", "fake", "--msg", "done", "--template", "{{.Message}}", "--var", "env")
+	assert.ErrorContains(t, err, "expected key=value")
+	_, err = run(t, "send", "--to", "fake", "--msg", "done", "--template", "{{.Message")
+	assert.NotNil(t, err)
+	assert.Empty(t, received("fake"))
+}
+
+func TestSend_FailOn(t *testing.T) {
+	out, err := run(t, "send", "--to", "fake,fake-fail", "--msg", "hello")
+	assert.ErrorContains(t, err, "failed to send message (fail-on=any)")
+	assert.Regexp(t, `(?m)^fake-fail\s+failed\s+1\s.*invalid token$`, out)
+
+	_, err = run(t, "send", "--to", "fake,fake-fail", "--msg", "hello", "--fail-on", "all")
+	assert.Nil(t, err)
+	_, err = run(t, "send", "--to", "fake-fail", "--msg", "hello", "--fail-on", "all")
+	assert.NotNil(t, err)
+	_, err = run(t, "send", "--to", "fake", "--msg", "hello", "--fail-on", "some")
+	assert.NotNil(t, err)
+	assert.Len(t, received("fake"), 2)
+}
+
+func TestSend_OutOfHours(t *testing.T) {
+	// the schedule is closed today and tomorrow
+	now := time.Now().UTC()
+	holidays := now.Format("2006-01-02") + "\n" + now.AddDate(0, 0, 1).Format("2006-01-02") + "\n"
+	path := writeConfig(t, `
+targets:
+  quiet:
+    service: fake
+    settings:
+      name: quiet
+    schedule:
+      timezone: UTC
+      holidays: holidays.txt
+      out_of_hours: drop
+  urgent:
+    service: fake
+    settings:
+      name: urgent
+    schedule:
+      timezone: UTC
+      holidays: holidays.txt
+      out_of_hours: drop
+      bypass: ">=2"
+  later:
+    service: fake
+    settings:
+      name: later
+    schedule:
+      timezone: UTC
+      holidays: holidays.txt
+`)
+	assert.Nil(t, os.WriteFile(filepath.Join(filepath.Dir(path), "holidays.txt"), []byte(holidays), 0o600))
+
+	out, err := run(t, "--config", path, "send", "--to", "quiet,urgent,later", "--msg", "hello", "-p", "2")
+	assert.Nil(t, err)
+	assert.Regexp(t, `(?m)^quiet\s+dropped\s`, out)
+	assert.Regexp(t, `(?m)^urgent\s+ok\s`, out)
+	// the CLI can't wait for the schedule to open
+	assert.Regexp(t, `(?m)^later\s+dropped\s`, out)
+	assert.Empty(t, received("quiet"))
+	assert.Len(t, received("urgent"), 1)
+	assert.Empty(t, received("later"))
+}
@@ -0,0 +1,379 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/render"
+	"github.com/kha7iq/pingme/internal/routing"
+	"github.com/kha7iq/pingme/internal/sources"
+	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config represents the pingme configuration file
+type Config struct {
+	// Retry is the retry policy used for every target and service, the
+	// defaults are helpers.DefaultRetryPolicy
+	Retry helpers.RetryPolicy `yaml:"retry"`
+	// Dedup suppresses messages with the same title and message sent to
+	// the same target or service again within this window, 0 disables it
+	Dedup   time.Duration     `yaml:"dedup"`
+	Targets map[string]Target `yaml:"targets"`
+	// Integrations configures the webhook endpoints of other tools keyed
+	// by name, e.g. "grafana"
+	Integrations map[string]Integration `yaml:"integrations"`
+	// Hooks maps the JSON payloads of other tools posted to /hooks/<name>
+	// to messages
+	Hooks map[string]sources.Mapping `yaml:"hooks"`
+	// Routes choose the targets of webhook messages sent without a
+	// destination
+	Routes []routing.Route `yaml:"routes"`
+	// APIKeys are named keys for the apikey webhook authentication, in
+	// addition to those in PINGME_API_KEYS
+	APIKeys []APIKey `yaml:"api_keys"`
+	// JWT configures the jwt webhook authentication
+	JWT *JWT `yaml:"jwt"`
+	// MTLS allows client certificates for the mtls webhook authentication
+	MTLS *MTLS `yaml:"mtls"`
+	// RateLimits limit the deliveries by service name, they replace the
+	// DefaultRateLimits of the service
+	RateLimits map[string]RateLimit `yaml:"rate_limits"`
+	// Escalations are policies sending a message to one step after the
+	// other until it is acknowledged
+	Escalations *Escalations `yaml:"escalations"`
+
+	router    *routing.Tree
+	jwt       *jwtAuth
+	mtls      []mtlsClient
+	schedules map[string]*targetSchedule
+}
+
+// Target is a named, preconfigured destination for notifications
+type Target struct {
+	// Service is the name of the registered service, e.g. "slack"
+	Service string `yaml:"service"`
+	// Settings holds the service configuration keyed by config key,
+	// e.g. "token" or "channels"
+	Settings map[string]string `yaml:"settings"`
+	// Template optionally renders title and message before they are sent
+	// to the target, see the render package
+	Template string `yaml:"template"`
+	// Retry overrides fields of the global retry policy for this target
+	Retry *helpers.RetryOverride `yaml:"retry"`
+	// Dedup overrides the global dedup window for this target
+	Dedup *time.Duration `yaml:"dedup"`
+	// Digest sends the messages of a window as one summary
+	Digest *Digest `yaml:"digest"`
+	// Schedule restricts when the target is notified
+	Schedule *Schedule `yaml:"schedule"`
+}
+
+// Integration configures a webhook endpoint accepting the payload of
+// another tool
+type Integration struct {
+	// Targets are the default destinations, targets or services, used
+	// when the request does not select one with a query parameter
+	Targets []string `yaml:"targets"`
+	// Secret verifies the sender of integrations supporting it, e.g. the
+	// GitHub webhook secret or the GitLab secret token
+	Secret string `yaml:"secret"`
+	// Events selects the events sent by integrations supporting it, e.g.
+	// "push" or "pull_request.opened"
+	Events []string `yaml:"events"`
+}
+
+// Load reads and parses the configuration file at path. An empty path
+// returns an empty configuration, so environment variables keep working
+// without a config file.
+func Load(path string) (*Config, error) {
+	cfg := &Config{Retry: helpers.DefaultRetryPolicy, Targets: map[string]Target{}}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if cfg.Targets == nil {
+		cfg.Targets = map[string]Target{}
+	}
+	for i := range cfg.APIKeys {
+		cfg.APIKeys[i].Key = expandEnv(cfg.APIKeys[i].Key)
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	if cfg.router, err = routing.New(cfg.Routes); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	if cfg.JWT != nil {
+		if cfg.jwt, err = cfg.newJWTAuth(cfg.JWT, filepath.Dir(path)); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: jwt: %w", path, err)
+		}
+	}
+	if cfg.schedules, err = cfg.newSchedules(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// validate checks that every target refers to a registered service,
+// integrations, hooks, routes, API keys, mTLS clients and escalations
+// send to known destinations, hooks compile and rate limits parse
+func (c *Config) validate() error {
+	for name, in := range c.Integrations {
+		for _, dest := range in.Targets {
+			if c.ServiceName(dest) == "" {
+				return fmt.Errorf("integration %q: unknown target or service %q", name, dest)
+			}
+		}
+	}
+	for name, hook := range c.Hooks {
+		if !validHookName(name) {
+			return fmt.Errorf("hook %q: name may only contain letters, digits, '-' and '_'", name)
+		}
+		for _, dest := range hook.Targets {
+			if c.ServiceName(dest) == "" {
+				return fmt.Errorf("hook %q: unknown target or service %q", name, dest)
+			}
+		}
+		if _, err := sources.Map(hook); err != nil {
+			return fmt.Errorf("hook %q: %w", name, err)
+		}
+	}
+	for name, t := range c.Targets {
+		if t.Service == "" {
+			return fmt.Errorf("target %q: service is required", name)
+		}
+		if _, ok := notifier.Lookup(t.Service); !ok {
+			return fmt.Errorf("target %q: unsupported service %q", name, t.Service)
+		}
+		if t.Template != "" {
+			if _, err := render.Parse(name, t.Template); err != nil {
+				return fmt.Errorf("target %q: %w", name, err)
+			}
+		}
+		if t.Dedup != nil && *t.Dedup < 0 {
+			return fmt.Errorf("target %q: dedup must not be negative", name)
+		}
+		if t.Digest != nil {
+			if err := t.Digest.validate(); err != nil {
+				return fmt.Errorf("target %q: %w", name, err)
+			}
+		}
+	}
+	if c.Dedup < 0 {
+		return fmt.Errorf("dedup must not be negative")
+	}
+	if err := c.validateKeys(); err != nil {
+		return err
+	}
+	if err := c.validateMTLS(); err != nil {
+		return err
+	}
+	if err := c.validateRateLimits(); err != nil {
+		return err
+	}
+	if err := c.validateEscalations(); err != nil {
+		return err
+	}
+	return c.validateRoutes("routes", c.Routes)
+}
+
+// validateRoutes checks that routes send to known destinations
+func (c *Config) validateRoutes(prefix string, routes []routing.Route) error {
+	for i, r := range routes {
+		name := fmt.Sprintf("%s[%d]", prefix, i)
+		for _, dest := range r.Targets {
+			if c.ServiceName(dest) == "" {
+				return fmt.Errorf("%s: unknown target or service %q", name, dest)
+			}
+		}
+		if err := c.validateRoutes(name+".routes", r.Routes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Target returns the named target
+func (c *Config) Target(name string) (Target, bool) {
+	t, ok := c.Targets[name]
+	return t, ok
+}
+
+// Router returns the routes of the config file, a config without routes
+// matches no message
+func (c *Config) Router() *routing.Tree {
+	if c == nil {
+		return nil
+	}
+	return c.router
+}
+
+// Integration returns the settings of the named integration, an
+// integration missing from the config file has no settings. The secret is
+// read from PINGME_<NAME>_SECRET or the config file, with ${VAR}
+// references expanded
+func (c *Config) Integration(name string) Integration {
+	var in Integration
+	if c != nil {
+		in = c.Integrations[name]
+	}
+	if v := os.Getenv("PINGME_" + envName(name) + "_SECRET"); v != "" {
+		in.Secret = v
+	} else {
+		in.Secret = expandEnv(in.Secret)
+	}
+	return in
+}
+
+// RetryPolicy returns the retry policy for name, a target or service
+func (c *Config) RetryPolicy(name string) helpers.RetryPolicy {
+	t, ok := c.Target(name)
+	if !ok {
+		return c.Retry
+	}
+	return c.Retry.Merge(t.Retry)
+}
+
+// DedupWindow returns how long messages sent to name, a target or service,
+// suppress identical messages, 0 if they don't
+func (c *Config) DedupWindow(name string) time.Duration {
+	if t, ok := c.Target(name); ok && t.Dedup != nil {
+		return *t.Dedup
+	}
+	return c.Dedup
+}
+
+// Notifier builds the Notifier for the named target
+func (c *Config) Notifier(name string) (notifier.Notifier, error) {
+	t, ok := c.Target(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown target: %s", name)
+	}
+
+	n, err := notifier.New(t.Service, t.Source(name))
+	if err != nil || t.Template == "" {
+		return n, err
+	}
+
+	tpl, err := render.Parse(name, t.Template)
+	if err != nil {
+		return nil, err
+	}
+	return render.Wrap(n, tpl, render.Environ()), nil
+}
+
+// Resolve builds the Notifier for name, which is either a target from the
+// config file or the name of a service configured through environment
+// variables. Targets take precedence over services of the same name.
+func (c *Config) Resolve(name string) (notifier.Notifier, error) {
+	if _, ok := c.Target(name); ok {
+		return c.Notifier(name)
+	}
+
+	svc, ok := notifier.Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown target or service: %s", name)
+	}
+
+	n, err := svc.New(notifier.Env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure %s: %w", svc.Name, err)
+	}
+	return n, nil
+}
+
+// ServiceName returns the registered service name behind name, a target
+// or service. Unknown names return an empty string
+func (c *Config) ServiceName(name string) string {
+	if t, ok := c.Target(name); ok {
+		name = t.Service
+	}
+	if svc, ok := notifier.Lookup(name); ok {
+		return svc.Name
+	}
+	return ""
+}
+
+// Source returns a notifier.Source resolving the settings of the target.
+// Values are looked up in order from:
+//
+//	PINGME_TARGET_<NAME>_<KEY> environment variable
+//	the target settings, with ${VAR} references expanded
+//	the service environment variable, e.g. SLACK_TOKEN, only for targets
+//	without settings
+//
+// A target with settings never picks up the process wide credentials of
+// its service, two targets of a service would silently share them.
+func (t Target) Source(name string) notifier.Source {
+	prefix := "PINGME_TARGET_" + envName(name) + "_"
+	return func(key, env string) string {
+		if key != "" {
+			if v := os.Getenv(prefix + envName(key)); v != "" {
+				return v
+			}
+			if v := t.Settings[key]; v != "" {
+				return expandEnv(v)
+			}
+		}
+		if t.Settings != nil {
+			return ""
+		}
+		return os.Getenv(env)
+	}
+}
+
+// validHookName reports whether name can be used in the /hooks/<name> path
+func validHookName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// envRef matches a ${VAR} reference
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces the ${VAR} references in s with the environment
+// variables. Unlike os.ExpandEnv it leaves other dollar signs alone, they
+// are common in passwords, tokens and password hashes
+func expandEnv(s string) string {
+	return envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		return os.Getenv(ref[2 : len(ref)-1])
+	})
+}
+
+// envName converts a target name or key to its environment variable form,
+// e.g. "ops-slack" becomes "OPS_SLACK"
+func envName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
@@ -0,0 +1,610 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/kha7iq/pingme/internal/ratelimit"
+	"github.com/kha7iq/pingme/internal/routing"
+	"github.com/kha7iq/pingme/internal/secret"
+	"github.com/kha7iq/pingme/service/helpers"
+	_ "github.com/kha7iq/pingme/service/slack"
+	_ "github.com/kha7iq/pingme/service/telegram"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pingme.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `
+targets:
+  ops-slack:
+    service: slack
+    settings:
+      token: xoxb-ops
+      channels: C123
+`)
+	cfg, err := Load(path)
+	assert.Nil(t, err)
+
+	target, ok := cfg.Target("ops-slack")
+	assert.True(t, ok)
+	assert.Equal(t, "slack", target.Service)
+	assert.Equal(t, "C123", target.Settings["channels"])
+
+	_, err = cfg.Notifier("ops-slack")
+	assert.Nil(t, err)
+
+	_, err = cfg.Notifier("missing")
+	assert.NotNil(t, err)
+}
+
+func TestLoad_EmptyPath(t *testing.T) {
+	cfg, err := Load("")
+	assert.Nil(t, err)
+	assert.Empty(t, cfg.Targets)
+}
+
+func TestLoad_UnknownService(t *testing.T) {
+	path := writeConfig(t, `
+targets:
+  broken:
+    service: carrier-pigeon
+`)
+	_, err := Load(path)
+	assert.NotNil(t, err)
+}
+
+func TestTargetSource(t *testing.T) {
+	t.Setenv("SLACK_TOKEN", "from-service-env")
+	t.Setenv("OPS_CHANNEL", "C999")
+	t.Setenv("PINGME_TARGET_OPS_SLACK_TITLE", "from-target-env")
+
+	target := Target{
+		Service: "slack",
+		Settings: map[string]string{
+			"channels": "${OPS_CHANNEL}",
+			"title":    "from-file",
+			"password": "pa$word$1",
+			"url":      "https://${OPS_CHANNEL}.example.com/$path",
+		},
+	}
+	src := target.Source("ops-slack")
+	assert.Equal(t, "pa$word$1", src("password", "SLACK_PASSWORD"), "only ${VAR} references are expanded")
+	assert.Equal(t, "https://C999.example.com/$path", src("url", "SLACK_URL"))
+
+	assert.Equal(t, "from-target-env", src("title", "SLACK_TITLE"))
+	assert.Equal(t, "C999", src("channels", "SLACK_CHANNELS"))
+	assert.Equal(t, "", src("token", "SLACK_TOKEN"), "targets with settings do not use the service environment")
+
+	src = Target{Service: "slack"}.Source("ops-slack")
+	assert.Equal(t, "from-service-env", src("token", "SLACK_TOKEN"))
+	assert.Equal(t, "from-target-env", src("title", "SLACK_TITLE"))
+}
+
+func TestLoad_InvalidTemplate(t *testing.T) {
+	path := writeConfig(t, `
+targets:
+  ops-slack:
+    service: slack
+    template: "{{.Message"
+`)
+	_, err := Load(path)
+	assert.NotNil(t, err)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	path := writeConfig(t, `
+retry:
+  attempts: 5
+targets:
+  ops-slack:
+    service: slack
+    retry:
+      attempts: 2
+      delay: 250ms
+  pager:
+    service: slack
+    retry:
+      attempts: 1
+      jitter: 0
+`)
+	cfg, err := Load(path)
+	assert.Nil(t, err)
+
+	p := cfg.RetryPolicy("slack")
+	assert.Equal(t, 5, p.Attempts)
+	assert.Equal(t, helpers.DefaultRetryPolicy.Delay, p.Delay)
+
+	p = cfg.RetryPolicy("ops-slack")
+	assert.Equal(t, 2, p.Attempts)
+	assert.Equal(t, 250*time.Millisecond, p.Delay)
+	assert.Equal(t, helpers.DefaultRetryPolicy.MaxDelay, p.MaxDelay)
+
+	// targets can set fields to zero
+	p = cfg.RetryPolicy("pager")
+	assert.Equal(t, 1, p.Attempts)
+	assert.Equal(t, 0.0, p.Jitter)
+	assert.Equal(t, helpers.DefaultRetryPolicy.Multiplier, p.Multiplier)
+}
+
+func TestIntegration(t *testing.T) {
+	path := writeConfig(t, `
+targets:
+  ops-slack:
+    service: slack
+integrations:
+  grafana:
+    targets: [ops-slack, slack]
+`)
+	cfg, err := Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ops-slack", "slack"}, cfg.Integration("grafana").Targets)
+	assert.Empty(t, cfg.Integration("github").Targets)
+
+	t.Setenv("GH_SECRET", "from-file")
+	path = writeConfig(t, `
+integrations:
+  github:
+    secret: ${GH_SECRET}
+    events: [push]
+`)
+	cfg, err = Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "from-file", cfg.Integration("github").Secret)
+	assert.Equal(t, []string{"push"}, cfg.Integration("github").Events)
+	t.Setenv("PINGME_GITHUB_SECRET", "from-env")
+	assert.Equal(t, "from-env", cfg.Integration("github").Secret)
+
+	path = writeConfig(t, `
+integrations:
+  grafana:
+    targets: [missing]
+`)
+	_, err = Load(path)
+	assert.NotNil(t, err)
+}
+
+func TestLoad_Hooks(t *testing.T) {
+	path := writeConfig(t, `
+hooks:
+  uptime-kuma:
+    targets: [slack]
+    title: monitor.name
+    message: "{{.msg}}"
+`)
+	cfg, err := Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "monitor.name", cfg.Hooks["uptime-kuma"].Title)
+
+	for _, content := range []string{
+		"hooks:\n  bad/name:\n    title: x\n",
+		"hooks:\n  cron:\n    targets: [missing]\n",
+		"hooks:\n  cron:\n    message: \"{{.msg\"\n",
+	} {
+		_, err = Load(writeConfig(t, content))
+		assert.NotNil(t, err, content)
+	}
+}
+
+func TestLoad_Routes(t *testing.T) {
+	path := writeConfig(t, `
+targets:
+  ops:
+    service: slack
+routes:
+  - match:
+      priority: ">=1"
+    targets: [ops]
+    routes:
+      - match:
+          source: /hooks/*
+        targets: [slack]
+`)
+	cfg, err := Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ops"}, cfg.Router().Targets(routing.Message{Priority: 1}))
+	assert.Empty(t, cfg.Router().Targets(routing.Message{}))
+	assert.Empty(t, (*Config)(nil).Router().Targets(routing.Message{}))
+
+	for _, content := range []string{
+		"routes:\n  - targets: [missing]\n",
+		"routes:\n  - targets: [slack]\n    routes:\n      - targets: [missing]\n",
+		"routes:\n  - match:\n      time: noon\n    targets: [slack]\n",
+	} {
+		_, err = Load(writeConfig(t, content))
+		assert.NotNil(t, err, content)
+	}
+}
+
+func TestLoad_HashedAPIKeys(t *testing.T) {
+	bcryptKey, err := secret.Hash(secret.Bcrypt, "s3cret")
+	assert.Nil(t, err)
+	argonKey, err := secret.Hash(secret.Argon2id, "s3cret")
+	assert.Nil(t, err)
+	path := writeConfig(t, `
+api_keys:
+  - name: bcrypt
+    key: '`+bcryptKey+`'
+  - name: argon2id
+    key: '`+argonKey+`'
+`)
+	cfg, err := Load(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the hashes are not mistaken for environment variables
+	for i, want := range []string{bcryptKey, argonKey} {
+		key := cfg.APIKeys[i]
+		assert.Equal(t, want, key.Key, key.Name)
+		assert.True(t, secret.Verify(key.Key, "s3cret"), key.Name)
+		assert.False(t, secret.Verify(key.Key, "=19=65536,t=3,p=4"), key.Name)
+	}
+}
+
+func TestLoad_APIKeys(t *testing.T) {
+	t.Setenv("CI_KEY", "s3cret")
+	path := writeConfig(t, `
+targets:
+  ops-slack:
+    service: slack
+api_keys:
+  - name: ci
+    key: ${CI_KEY}
+    targets: [ops-slack]
+    endpoints: [/webhook, /hooks/*]
+    expires: 2030-01-01T00:00:00Z
+`)
+	cfg, err := Load(path)
+	assert.Nil(t, err)
+	key := cfg.APIKeys[0]
+	assert.Equal(t, "s3cret", key.Key)
+
+	assert.True(t, key.AllowsDestination("ops-slack"))
+	assert.False(t, key.AllowsDestination("slack"))
+	assert.True(t, key.AllowsEndpoint("/hooks/cron"))
+	assert.False(t, key.AllowsEndpoint("/webhook/grafana"))
+	assert.False(t, key.Expired(time.Date(2029, 12, 31, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, key.Expired(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	unrestricted := APIKey{Name: "admin", Key: "k"}
+	assert.True(t, unrestricted.AllowsDestination("twilio"))
+	assert.True(t, unrestricted.AllowsEndpoint("/messages/1"))
+	assert.False(t, unrestricted.Expired(time.Now()))
+
+	for _, content := range []string{
+		"api_keys:\n  - key: k\n",
+		"api_keys:\n  - name: ci\n",
+		"api_keys:\n  - name: ci\n    key: a\n  - name: ci\n    key: b\n",
+		"api_keys:\n  - name: ci\n    key: k\n    targets: [missing]\n",
+		"api_keys:\n  - name: ci\n    key: k\n    endpoints: [\"[\"]\n",
+		"api_keys:\n  - name: ci\n    key: sha256:abc\n",
+	} {
+		_, err = Load(writeConfig(t, content))
+		assert.NotNil(t, err, content)
+	}
+}
+
+func TestVerifyJWT(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	assert.Nil(t, err)
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	cfg, err := Load(writeConfig(t, `
+targets:
+  ops-slack:
+    service: slack
+jwt:
+  key_file: `+keyFile+`
+  audience: pingme
+  name_claim: client_id
+  grants:
+    - claims:
+        sub: "ci:*"
+      targets: [ops-slack]
+      endpoints: [/webhook]
+    - claims:
+        sub: "ci:*"
+        groups: oncall
+      targets: [slack]
+    - claims:
+        groups: admin
+`))
+	assert.Nil(t, err)
+
+	token := func(claims jwt.MapClaims) string {
+		claims["aud"] = "pingme"
+		claims["exp"] = time.Now().Add(time.Minute).Unix()
+		s, signErr := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims).SignedString(key)
+		assert.Nil(t, signErr)
+		return s
+	}
+
+	name, scope, err := cfg.VerifyJWT(token(jwt.MapClaims{"sub": "ci:deploy", "client_id": "deployer"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "deployer", name)
+	assert.Equal(t, Scope{Targets: []string{"ops-slack"}, Endpoints: []string{"/webhook"}}, scope)
+
+	// matching grants add up, a grant without endpoints allows all
+	_, scope, err = cfg.VerifyJWT(token(jwt.MapClaims{"sub": "ci:deploy", "groups": []string{"dev", "oncall"}}))
+	assert.Nil(t, err)
+	assert.Equal(t, Scope{Targets: []string{"ops-slack", "slack"}}, scope)
+
+	_, scope, err = cfg.VerifyJWT(token(jwt.MapClaims{"sub": "alice", "groups": []string{"admin"}}))
+	assert.Nil(t, err)
+	assert.Equal(t, Scope{}, scope)
+
+	name, _, err = cfg.VerifyJWT(token(jwt.MapClaims{"sub": "bob", "client_id": "bob"}))
+	assert.ErrorIs(t, err, ErrNoGrant)
+	assert.Equal(t, "bob", name)
+
+	// relative key files are next to the config file
+	path := writeConfig(t, "jwt:\n  key_file: key.pem\n")
+	assert.Nil(t, os.Rename(keyFile, filepath.Join(filepath.Dir(path), "key.pem")))
+	relative, err := Load(path)
+	assert.Nil(t, err)
+	_, _, err = relative.VerifyJWT(token(jwt.MapClaims{"sub": "ci:deploy"}))
+	assert.Nil(t, err)
+	keyFile = filepath.Join(filepath.Dir(path), "key.pem")
+
+	_, _, err = cfg.VerifyJWT("not.a.token")
+	assert.NotNil(t, err)
+	_, _, err = (&Config{}).VerifyJWT(token(jwt.MapClaims{}))
+	assert.NotNil(t, err)
+
+	for _, content := range []string{
+		"jwt:\n  audience: pingme\n",
+		"jwt:\n  key_file: /nonexistent\n",
+		"jwt:\n  key_file: " + keyFile + "\n  grants:\n    - targets: [slack]\n",
+		"jwt:\n  key_file: " + keyFile + "\n  grants:\n    - claims: {sub: x}\n      targets: [missing]\n",
+	} {
+		_, err = Load(writeConfig(t, content))
+		assert.NotNil(t, err, content)
+	}
+}
+
+func TestVerifyClientCert(t *testing.T) {
+	cfg, err := Load(writeConfig(t, `
+targets:
+  ops-slack:
+    service: slack
+mtls:
+  clients:
+    - subject: "ci-*"
+      targets: [ops-slack]
+    - subject: "*,O=Example"
+      san: "spiffe://example.org/deploy/*"
+      endpoints: [/webhook]
+`))
+	assert.Nil(t, err)
+
+	spiffe, err := url.Parse("spiffe://example.org/deploy/api")
+	assert.Nil(t, err)
+	cert := func(cn, org string, uris ...*url.URL) *x509.Certificate {
+		c := &x509.Certificate{Subject: pkix.Name{CommonName: cn}, URIs: uris}
+		if org != "" {
+			c.Subject.Organization = []string{org}
+		}
+		return c
+	}
+
+	name, scope, err := cfg.VerifyClientCert(cert("ci-runner", ""))
+	assert.Nil(t, err)
+	assert.Equal(t, "ci-runner", name)
+	assert.Equal(t, Scope{Targets: []string{"ops-slack"}}, scope)
+
+	// the subject matches the full distinguished name too, both patterns
+	// of a client must match
+	_, scope, err = cfg.VerifyClientCert(cert("api", "Example", spiffe))
+	assert.Nil(t, err)
+	assert.Equal(t, Scope{Endpoints: []string{"/webhook"}}, scope)
+	_, _, err = cfg.VerifyClientCert(cert("api", "Example"))
+	assert.ErrorIs(t, err, ErrNoClient)
+
+	name, _, err = cfg.VerifyClientCert(cert("", "Other"))
+	assert.ErrorIs(t, err, ErrNoClient)
+	assert.Equal(t, "O=Other", name)
+
+	// without clients every verified certificate is allowed
+	_, scope, err = (&Config{}).VerifyClientCert(cert("anyone", ""))
+	assert.Nil(t, err)
+	assert.Equal(t, Scope{}, scope)
+
+	for _, content := range []string{
+		"mtls:\n  clients:\n    - targets: [slack]\n",
+		"mtls:\n  clients:\n    - subject: ci\n      targets: [missing]\n",
+	} {
+		_, err = Load(writeConfig(t, content))
+		assert.NotNil(t, err, content)
+	}
+}
+
+func TestServiceLimits(t *testing.T) {
+	t.Setenv("SLACK_CHANNELS", "env-channel")
+	cfg, err := Load(writeConfig(t, `
+targets:
+  ops-slack:
+    service: slack
+    settings:
+      channels: ops
+rate_limits:
+  slack:
+    rate: 1/s
+    burst: 3
+    per: channels
+  telegram:
+    rate: 0
+`))
+	assert.Nil(t, err)
+
+	limits := cfg.ServiceLimits()
+	assert.Equal(t, ServiceLimit{Limit: ratelimit.Limit{Events: 1, Per: time.Second, Burst: 3}, Per: "channels"}, limits["slack"])
+	assert.Equal(t, ServiceLimit{Limit: ratelimit.Limit{Events: 5, Per: 5 * time.Second}, Per: "channels"}, limits["discord"])
+	_, ok := limits["telegram"]
+	assert.False(t, ok, "rate 0 removes the default")
+
+	assert.Equal(t, "ops", cfg.Setting("ops-slack", "channels"))
+	assert.Equal(t, "env-channel", cfg.Setting("slack", "channels"))
+	assert.Equal(t, "", cfg.Setting("slack", "missing"))
+	assert.Equal(t, "", cfg.Setting("missing", "channels"))
+
+	for _, content := range []string{
+		"rate_limits:\n  missing:\n    rate: 1/s\n",
+		"rate_limits:\n  slack:\n    rate: fast\n",
+		"rate_limits:\n  slack:\n    rate: 1/s\n    burst: -1\n",
+	} {
+		_, err = Load(writeConfig(t, content))
+		assert.NotNil(t, err, content)
+	}
+}
+
+func TestDedupWindow(t *testing.T) {
+	cfg, err := Load(writeConfig(t, `
+dedup: 10m
+targets:
+  ops-slack:
+    service: slack
+  noisy-slack:
+    service: slack
+    dedup: 0s
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, 10*time.Minute, cfg.DedupWindow("ops-slack"))
+	assert.Equal(t, 10*time.Minute, cfg.DedupWindow("slack"))
+	assert.Equal(t, time.Duration(0), cfg.DedupWindow("noisy-slack"))
+
+	_, err = Load(writeConfig(t, "dedup: -1m\n"))
+	assert.NotNil(t, err)
+}
+
+func TestDigest(t *testing.T) {
+	cfg, err := Load(writeConfig(t, `
+targets:
+  incidents:
+    service: slack
+    digest:
+      window: 5m
+      max: 50
+      group_by: [alertname]
+  ops-slack:
+    service: slack
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, &Digest{Window: 5 * time.Minute, Max: 50, GroupBy: []string{"alertname"}}, cfg.Digest("incidents"))
+	assert.Nil(t, cfg.Digest("ops-slack"))
+	assert.Nil(t, cfg.Digest("slack"))
+
+	_, err = Load(writeConfig(t, "targets:\n  t:\n    service: slack\n    digest:\n      max: 5\n"))
+	assert.ErrorContains(t, err, "window is required")
+}
+
+func TestOffHours(t *testing.T) {
+	path := writeConfig(t, `
+targets:
+  oncall:
+    service: telegram
+    schedule:
+      timezone: Europe/Berlin
+      hours:
+        - days: [mon-fri]
+          time: "08:00-20:00"
+      holidays: holidays.txt
+      out_of_hours: downgrade
+      priority: -1
+      bypass: ">=2"
+  ops:
+    service: slack
+    schedule:
+      hours:
+        - days: [mon-fri]
+      out_of_hours: reroute
+      reroute: oncall
+`)
+	assert.Nil(t, os.WriteFile(filepath.Join(filepath.Dir(path), "holidays.txt"), []byte("2024-05-09\n"), 0o600))
+	cfg, err := Load(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	monday := time.Date(2024, 5, 6, 12, 0, 0, 0, berlin)
+	assert.Nil(t, cfg.OffHours("oncall", 0, monday))
+	assert.Nil(t, cfg.OffHours("slack", 0, monday.Add(12*time.Hour)), "services have no schedule")
+
+	off := cfg.OffHours("oncall", 1, monday.Add(10*time.Hour))
+	assert.Equal(t, &OffHours{Action: Downgrade, Priority: -1, Until: time.Date(2024, 5, 7, 8, 0, 0, 0, berlin)}, off)
+	assert.Nil(t, cfg.OffHours("oncall", 2, monday.Add(10*time.Hour)), "high priorities bypass the schedule")
+
+	off = cfg.OffHours("oncall", 0, time.Date(2024, 5, 9, 12, 0, 0, 0, berlin))
+	assert.Equal(t, time.Date(2024, 5, 10, 8, 0, 0, 0, berlin), off.Until, "holidays are closed")
+
+	off = cfg.OffHours("ops", 0, time.Date(2024, 5, 11, 12, 0, 0, 0, time.Local))
+	assert.Equal(t, Reroute, off.Action)
+	assert.Equal(t, "oncall", off.Reroute)
+
+	for content, want := range map[string]string{
+		"schedule:\n      out_of_hours: later\n":                        "invalid out_of_hours",
+		"schedule:\n      out_of_hours: reroute\n":                      "reroute is required",
+		"schedule:\n      out_of_hours: reroute\n      reroute: t\n":    `"t" reroutes messages itself`,
+		"schedule:\n      out_of_hours: reroute\n      reroute: nope\n": `unknown target or service "nope"`,
+		"schedule:\n      bypass: high\n":                               "invalid priority condition",
+		"schedule:\n      holidays: missing.txt\n":                      "failed to read holidays",
+	} {
+		_, err = Load(writeConfig(t, "targets:\n  t:\n    service: slack\n    "+content))
+		assert.ErrorContains(t, err, want)
+	}
+}
+
+func TestEscalations(t *testing.T) {
+	t.Setenv("ACK_SECRET", "s3cret")
+	cfg, err := Load(writeConfig(t, `
+targets:
+  oncall-telegram:
+    service: telegram
+escalations:
+  secret: ${ACK_SECRET}
+  policies:
+    oncall:
+      steps:
+        - targets: [oncall-telegram]
+          after: 10m
+        - targets: [slack, telegram]
+`))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "s3cret", cfg.Escalations.Secret)
+	policy, ok := cfg.Escalation("oncall")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"oncall-telegram", "slack", "telegram"}, policy.Targets())
+	_, ok = cfg.Escalation("missing")
+	assert.False(t, ok)
+
+	for content, want := range map[string]string{
+		"p: {}":                           "steps are required",
+		"p: {steps: [{after: 1m}]}":       "steps[0]: targets are required",
+		"p: {steps: [{targets: [nope]}]}": `unknown target or service "nope"`,
+		"p: {steps: [{targets: [slack]}, {targets: [slack]}]}": "steps[0]: after must be positive",
+	} {
+		_, err = Load(writeConfig(t, "escalations:\n  policies:\n    "+content+"\n"))
+		assert.ErrorContains(t, err, want)
+	}
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Digest buffers the messages to a target and sends them as a single
+// summary once the window has passed or enough messages were collected
+type Digest struct {
+	// Window is how long messages are collected, starting with the first
+	Window time.Duration `yaml:"window"`
+	// Max sends the digest early once it holds this many messages, 0 for
+	// no limit
+	Max int `yaml:"max"`
+	// GroupBy are keys of extra, e.g. "alertname", messages with different
+	// values get separate digests
+	GroupBy []string `yaml:"group_by"`
+}
+
+func (d *Digest) validate() error {
+	if d.Window <= 0 {
+		return fmt.Errorf("digest: window is required")
+	}
+	if d.Max < 0 {
+		return fmt.Errorf("digest: max must not be negative")
+	}
+	return nil
+}
+
+// Digest returns the digest settings of the named target, nil if its
+// messages are sent one by one
+func (c *Config) Digest(name string) *Digest {
+	if t, ok := c.Target(name); ok {
+		return t.Digest
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Escalations configures the escalation policies of the webhook server
+type Escalations struct {
+	// URL is the public address of the server, e.g.
+	// "https://pingme.example.com". Messages get an acknowledgment link
+	// below it if set
+	URL string `yaml:"url"`
+	// Secret signs the acknowledgment links, a random secret is used if
+	// empty. ${VAR} references are expanded
+	Secret string `yaml:"secret"`
+	// Policies are the escalation policies by name
+	Policies map[string]Escalation `yaml:"policies"`
+}
+
+// Escalation sends a message step by step until it is acknowledged
+type Escalation struct {
+	Steps []EscalationStep `yaml:"steps"`
+}
+
+// EscalationStep notifies targets and waits for an acknowledgment
+type EscalationStep struct {
+	// Targets are targets or services notified by the step
+	Targets []string `yaml:"targets"`
+	// After is how long to wait for an acknowledgment before the next
+	// step, required for all steps but the last
+	After time.Duration `yaml:"after"`
+}
+
+// validateEscalations checks that the steps of every policy send to known
+// destinations and wait before the next step
+func (c *Config) validateEscalations() error {
+	if c.Escalations == nil {
+		return nil
+	}
+	c.Escalations.Secret = expandEnv(c.Escalations.Secret)
+	for name, policy := range c.Escalations.Policies {
+		if len(policy.Steps) == 0 {
+			return fmt.Errorf("escalation %q: steps are required", name)
+		}
+		for i, step := range policy.Steps {
+			if len(step.Targets) == 0 {
+				return fmt.Errorf("escalation %q: steps[%d]: targets are required", name, i)
+			}
+			for _, dest := range step.Targets {
+				if c.ServiceName(dest) == "" {
+					return fmt.Errorf("escalation %q: steps[%d]: unknown target or service %q", name, i, dest)
+				}
+			}
+			if step.After < 0 || (step.After == 0 && i < len(policy.Steps)-1) {
+				return fmt.Errorf("escalation %q: steps[%d]: after must be positive", name, i)
+			}
+		}
+	}
+	return nil
+}
+
+// Escalation returns the named escalation policy
+func (c *Config) Escalation(name string) (Escalation, bool) {
+	if c.Escalations == nil {
+		return Escalation{}, false
+	}
+	policy, ok := c.Escalations.Policies[name]
+	return policy, ok
+}
+
+// Targets returns the targets of all steps of the policy
+func (e Escalation) Targets() []string {
+	var targets []string
+	for _, step := range e.Steps {
+		targets = append(targets, step.Targets...)
+	}
+	return targets
+}
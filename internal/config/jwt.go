@@ -0,0 +1,121 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/jwtauth"
+)
+
+// ErrNoGrant is returned for valid tokens matching none of the grants
+var ErrNoGrant = errors.New("no grant matches the token")
+
+// JWT configures the jwt webhook authentication
+type JWT struct {
+	// KeyFile is a JWKS document or a PEM encoded public key or
+	// certificate verifying the tokens, relative to the config file
+	KeyFile string `yaml:"key_file"`
+	// Issuer must equal the iss claim if set
+	Issuer string `yaml:"issuer"`
+	// Audience must be one of the aud claim if set
+	Audience string `yaml:"audience"`
+	// Leeway allows for clock differences when checking exp and nbf
+	Leeway time.Duration `yaml:"leeway"`
+	// NameClaim identifies callers in logs, defaults to "sub"
+	NameClaim string `yaml:"name_claim"`
+	// Grants map claims to scopes. Tokens get the scopes of all matching
+	// grants, without grants every valid token may do everything
+	Grants []JWTGrant `yaml:"grants"`
+}
+
+// JWTGrant gives tokens with matching claims a scope
+type JWTGrant struct {
+	// Claims must all match, "*" in values matches any text. Array
+	// claims like groups match if any element matches
+	Claims map[string]string `yaml:"claims"`
+	Scope  `yaml:",inline"`
+}
+
+// jwtAuth is a compiled JWT
+type jwtAuth struct {
+	verifier  *jwtauth.Verifier
+	nameClaim string
+	grants    []jwtGrant
+}
+
+type jwtGrant struct {
+	claims map[string]*regexp.Regexp
+	scope  Scope
+}
+
+// newJWTAuth loads the keys and compiles the grants of j, the key file is
+// relative to dir
+func (c *Config) newJWTAuth(j *JWT, dir string) (*jwtAuth, error) {
+	keyFile := j.KeyFile
+	if keyFile != "" && !filepath.IsAbs(keyFile) {
+		keyFile = filepath.Join(dir, keyFile)
+	}
+	verifier, err := jwtauth.New(jwtauth.Options{
+		KeyFile:  keyFile,
+		Issuer:   j.Issuer,
+		Audience: j.Audience,
+		Leeway:   j.Leeway,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &jwtAuth{verifier: verifier, nameClaim: j.NameClaim}
+	if auth.nameClaim == "" {
+		auth.nameClaim = "sub"
+	}
+	for i, g := range j.Grants {
+		if len(g.Claims) == 0 {
+			return nil, fmt.Errorf("grants[%d]: claims are required", i)
+		}
+		if err := g.Scope.validate(c); err != nil {
+			return nil, fmt.Errorf("grants[%d]: %w", i, err)
+		}
+		grant := jwtGrant{claims: make(map[string]*regexp.Regexp, len(g.Claims)), scope: g.Scope}
+		for name, pattern := range g.Claims {
+			grant.claims[name] = jwtauth.Pattern(pattern)
+		}
+		auth.grants = append(auth.grants, grant)
+	}
+	return auth, nil
+}
+
+// VerifyJWT verifies token with the jwt settings of the config file and
+// returns the caller name and the scope granted to the token. Valid tokens
+// without matching grant return ErrNoGrant and the name
+func (c *Config) VerifyJWT(token string) (string, Scope, error) {
+	if c == nil || c.jwt == nil {
+		return "", Scope{}, errors.New("jwt is not configured in the config file")
+	}
+	claims, err := c.jwt.verifier.Verify(token)
+	if err != nil {
+		return "", Scope{}, err
+	}
+
+	name := "unknown"
+	if v := jwtauth.Claim(claims, c.jwt.nameClaim); len(v) > 0 {
+		name = v[0]
+	}
+	if len(c.jwt.grants) == 0 {
+		return name, Scope{}, nil
+	}
+
+	var scopes []Scope
+	for _, g := range c.jwt.grants {
+		if jwtauth.MatchClaims(claims, g.claims) {
+			scopes = append(scopes, g.scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return name, Scope{}, ErrNoGrant
+	}
+	return name, union(scopes), nil
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/secret"
+)
+
+// Scope limits the destinations and endpoints a caller of the webhook
+// server may use
+type Scope struct {
+	// Targets are the targets or services the caller may send to, all if
+	// empty
+	Targets []string `yaml:"targets"`
+	// Endpoints are globs of the paths the caller may call, e.g. "/webhook"
+	// or "/hooks/*", all if empty
+	Endpoints []string `yaml:"endpoints"`
+}
+
+// AllowsEndpoint reports whether the scope allows calling the request
+// path p
+func (s Scope) AllowsEndpoint(p string) bool {
+	if len(s.Endpoints) == 0 {
+		return true
+	}
+	for _, pattern := range s.Endpoints {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsDestination reports whether the scope allows sending to dest, a
+// target or service
+func (s Scope) AllowsDestination(dest string) bool {
+	if len(s.Targets) == 0 {
+		return true
+	}
+	for _, t := range s.Targets {
+		if t == dest {
+			return true
+		}
+	}
+	return false
+}
+
+// union returns a scope allowing what any of scopes allows, an empty list
+// in any of them allows everything
+func union(scopes []Scope) Scope {
+	var (
+		scope                    Scope
+		allTargets, allEndpoints bool
+	)
+	for _, s := range scopes {
+		allTargets = allTargets || len(s.Targets) == 0
+		allEndpoints = allEndpoints || len(s.Endpoints) == 0
+		scope.Targets = append(scope.Targets, s.Targets...)
+		scope.Endpoints = append(scope.Endpoints, s.Endpoints...)
+	}
+	if allTargets {
+		scope.Targets = nil
+	}
+	if allEndpoints {
+		scope.Endpoints = nil
+	}
+	return scope
+}
+
+// validate checks that the scope refers to known destinations and valid
+// endpoint globs
+func (s Scope) validate(c *Config) error {
+	for _, dest := range s.Targets {
+		if c.ServiceName(dest) == "" {
+			return fmt.Errorf("unknown target or service %q", dest)
+		}
+	}
+	for _, pattern := range s.Endpoints {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid endpoint %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// APIKey is a named key for the apikey webhook authentication, restricted
+// to some destinations and endpoints
+type APIKey struct {
+	// Name identifies the key in logs, the key itself is never logged
+	Name string `yaml:"name"`
+	// Key is sent as bearer token, ${VAR} references are expanded. It may
+	// be hashed, e.g. "sha256:<hex>", see the secret package
+	Key   string `yaml:"key"`
+	Scope `yaml:",inline"`
+	// Expires is the time the key stops working, never if zero
+	Expires time.Time `yaml:"expires"`
+}
+
+// Expired reports whether the key has expired at now
+func (k *APIKey) Expired(now time.Time) bool {
+	return !k.Expires.IsZero() && !now.Before(k.Expires)
+}
+
+// validateKeys checks that keys are named uniquely, have a key and only
+// refer to known destinations
+func (c *Config) validateKeys() error {
+	names := make(map[string]bool, len(c.APIKeys))
+	for i, k := range c.APIKeys {
+		if k.Name == "" {
+			return fmt.Errorf("api_keys[%d]: name is required", i)
+		}
+		if names[k.Name] {
+			return fmt.Errorf("api key %q: duplicate name", k.Name)
+		}
+		names[k.Name] = true
+		if k.Key == "" {
+			return fmt.Errorf("api key %q: key is required", k.Name)
+		}
+		if err := secret.Validate(k.Key); err != nil {
+			return fmt.Errorf("api key %q: %w", k.Name, err)
+		}
+		if err := k.Scope.validate(c); err != nil {
+			return fmt.Errorf("api key %q: %w", k.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/kha7iq/pingme/internal/jwtauth"
+)
+
+// ErrNoClient is returned for verified client certificates matching none
+// of the allowed clients
+var ErrNoClient = errors.New("no client matches the certificate")
+
+// MTLS configures the mtls webhook authentication with client
+// certificates verified by the --client-ca of the server
+type MTLS struct {
+	// Clients allow certificates by subject or SAN. Certificates get the
+	// scopes of all matching clients, without clients every verified
+	// certificate may do everything
+	Clients []MTLSClient `yaml:"clients"`
+}
+
+// MTLSClient gives certificates with matching subject and SAN a scope
+type MTLSClient struct {
+	// Subject matches the common name or the full subject, e.g.
+	// "CN=ci,O=Example", "*" matches any text
+	Subject string `yaml:"subject"`
+	// SAN matches any DNS name, email address, URI or IP address of the
+	// certificate, e.g. "spiffe://example.org/ci/*"
+	SAN   string `yaml:"san"`
+	Scope `yaml:",inline"`
+}
+
+// mtlsClient is a compiled MTLSClient
+type mtlsClient struct {
+	subject, san *regexp.Regexp
+	scope        Scope
+}
+
+// validateMTLS checks that every client matches on something and only
+// refers to known destinations, and compiles the clients
+func (c *Config) validateMTLS() error {
+	if c.MTLS == nil {
+		return nil
+	}
+	for i, client := range c.MTLS.Clients {
+		if client.Subject == "" && client.SAN == "" {
+			return fmt.Errorf("mtls: clients[%d]: subject or san is required", i)
+		}
+		if err := client.Scope.validate(c); err != nil {
+			return fmt.Errorf("mtls: clients[%d]: %w", i, err)
+		}
+		compiled := mtlsClient{scope: client.Scope}
+		if client.Subject != "" {
+			compiled.subject = jwtauth.Pattern(client.Subject)
+		}
+		if client.SAN != "" {
+			compiled.san = jwtauth.Pattern(client.SAN)
+		}
+		c.mtls = append(c.mtls, compiled)
+	}
+	return nil
+}
+
+// VerifyClientCert returns the caller name and the scope granted to a
+// client certificate already verified by the TLS handshake. Certificates
+// without matching client return ErrNoClient and the name
+func (c *Config) VerifyClientCert(cert *x509.Certificate) (string, Scope, error) {
+	name := cert.Subject.CommonName
+	if name == "" {
+		name = cert.Subject.String()
+	}
+	if c == nil || c.MTLS == nil || len(c.mtls) == 0 {
+		return name, Scope{}, nil
+	}
+
+	var scopes []Scope
+	for _, client := range c.mtls {
+		if client.matches(cert) {
+			scopes = append(scopes, client.scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return name, Scope{}, ErrNoClient
+	}
+	return name, union(scopes), nil
+}
+
+// matches reports whether cert matches both the subject and the SAN
+// pattern, if set
+func (m mtlsClient) matches(cert *x509.Certificate) bool {
+	if m.subject != nil && !m.subject.MatchString(cert.Subject.CommonName) &&
+		!m.subject.MatchString(cert.Subject.String()) {
+		return false
+	}
+	if m.san == nil {
+		return true
+	}
+	sans := append([]string{}, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, san := range sans {
+		if m.san.MatchString(san) {
+			return true
+		}
+	}
+	return false
+}
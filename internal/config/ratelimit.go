@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/kha7iq/pingme/internal/ratelimit"
+	"github.com/kha7iq/pingme/service/notifier"
+)
+
+// RateLimit limits the deliveries to a service
+type RateLimit struct {
+	// Rate is a limit like "30/s" or "600/m", "0" removes the limit
+	Rate string `yaml:"rate"`
+	// Burst is the number of deliveries allowed at once, the number of
+	// events of Rate by default
+	Burst int `yaml:"burst"`
+	// Per is a setting of the service, e.g. "channels", the limit applies
+	// to each of its values separately instead of the whole service
+	Per string `yaml:"per"`
+}
+
+// DefaultRateLimits follow the quotas of the providers, rate_limits in the
+// config file replace them
+var DefaultRateLimits = map[string]RateLimit{
+	// 30 messages per second per bot
+	"telegram": {Rate: "30/s", Per: "token"},
+	// 5 messages per 5 seconds per channel
+	"discord": {Rate: "5/5s", Per: "channels"},
+	// 1 message per second per long code number
+	"twillio": {Rate: "1/s", Per: "sender"},
+}
+
+// ServiceLimit is a parsed RateLimit
+type ServiceLimit struct {
+	ratelimit.Limit
+	Per string
+}
+
+// ServiceLimits returns the delivery rate limits by service name, the
+// defaults merged with the rate_limits of the config file. Services
+// without limit are missing
+func (c *Config) ServiceLimits() map[string]ServiceLimit {
+	limits := make(map[string]ServiceLimit)
+	for name, l := range DefaultRateLimits {
+		limit, _ := l.parse()
+		limits[name] = limit
+	}
+	if c != nil {
+		for name, l := range c.RateLimits {
+			// validated by Load
+			svc, _ := notifier.Lookup(name)
+			limit, _ := l.parse()
+			limits[svc.Name] = limit
+		}
+	}
+	for name, l := range limits {
+		if l.Unlimited() {
+			delete(limits, name)
+		}
+	}
+	return limits
+}
+
+func (l RateLimit) parse() (ServiceLimit, error) {
+	limit, err := ratelimit.Parse(l.Rate)
+	if err != nil {
+		return ServiceLimit{}, err
+	}
+	if l.Burst < 0 {
+		return ServiceLimit{}, fmt.Errorf("invalid burst %d", l.Burst)
+	}
+	limit.Burst = l.Burst
+	return ServiceLimit{Limit: limit, Per: l.Per}, nil
+}
+
+// validateRateLimits checks that rate limits name registered services and
+// parse
+func (c *Config) validateRateLimits() error {
+	for name, l := range c.RateLimits {
+		if _, ok := notifier.Lookup(name); !ok {
+			return fmt.Errorf("rate limit %q: unsupported service", name)
+		}
+		if _, err := l.parse(); err != nil {
+			return fmt.Errorf("rate limit %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Setting returns the value of the setting key of dest, a target or
+// service, as its notifier is configured with it. Unknown destinations
+// and settings return an empty string
+func (c *Config) Setting(dest, key string) string {
+	name, src := dest, notifier.Env
+	if t, ok := c.Target(dest); ok {
+		name, src = t.Service, t.Source(dest)
+	}
+	svc, ok := notifier.Lookup(name)
+	if !ok {
+		return ""
+	}
+
+	// notifiers read their settings when they are built, record the one
+	// asked for
+	var value string
+	_, _ = svc.New(func(k, env string) string {
+		v := src(k, env)
+		if k == key {
+			value = v
+		}
+		return v
+	})
+	return value
+}
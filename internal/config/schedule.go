@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/routing"
+	"github.com/kha7iq/pingme/internal/schedule"
+)
+
+// OutOfHours is what happens to messages sent to a target outside its
+// schedule
+type OutOfHours string
+
+const (
+	// Drop discards the message
+	Drop OutOfHours = "drop"
+	// Defer sends the message once the schedule opens
+	Defer OutOfHours = "defer"
+	// Downgrade sends the message with the priority of the schedule
+	Downgrade OutOfHours = "downgrade"
+	// Reroute sends the message to another target or service
+	Reroute OutOfHours = "reroute"
+)
+
+// Schedule restricts when a target is notified
+type Schedule struct {
+	schedule.Schedule `yaml:",inline"`
+	// OutOfHours is the action for messages outside the schedule,
+	// defaults to Defer
+	OutOfHours OutOfHours `yaml:"out_of_hours"`
+	// Priority is the priority of downgraded messages
+	Priority int `yaml:"priority"`
+	// Reroute is the target or service messages are rerouted to
+	Reroute string `yaml:"reroute"`
+	// Bypass is a priority condition, e.g. ">=2", of messages sent
+	// regardless of the schedule
+	Bypass string `yaml:"bypass"`
+}
+
+// OffHours is the action for a message outside the schedule of its target
+type OffHours struct {
+	Action OutOfHours
+	// Priority is set for Downgrade
+	Priority int
+	// Reroute is set for Reroute
+	Reroute string
+	// Until is when the schedule opens again, zero if it doesn't open
+	// within a year
+	Until time.Time
+}
+
+// targetSchedule is a compiled Schedule
+type targetSchedule struct {
+	calendar *schedule.Calendar
+	config   Schedule
+	bypass   func(int) bool
+}
+
+// newSchedules compiles the schedules of the targets, holiday files are
+// relative to dir
+func (c *Config) newSchedules(dir string) (map[string]*targetSchedule, error) {
+	schedules := make(map[string]*targetSchedule)
+	for name, t := range c.Targets {
+		if t.Schedule == nil {
+			continue
+		}
+		s := &targetSchedule{config: *t.Schedule}
+		if s.config.OutOfHours == "" {
+			s.config.OutOfHours = Defer
+		}
+
+		switch s.config.OutOfHours {
+		case Drop, Defer, Downgrade:
+		case Reroute:
+			alt := s.config.Reroute
+			if alt == "" {
+				return nil, fmt.Errorf("target %q: schedule: reroute is required", name)
+			}
+			if c.ServiceName(alt) == "" {
+				return nil, fmt.Errorf("target %q: schedule: unknown target or service %q", name, alt)
+			}
+			// rerouted messages are not rerouted again
+			other, ok := c.Target(alt)
+			if alt == name || (ok && other.Schedule != nil && other.Schedule.OutOfHours == Reroute) {
+				return nil, fmt.Errorf("target %q: schedule: %q reroutes messages itself", name, alt)
+			}
+		default:
+			return nil, fmt.Errorf("target %q: schedule: invalid out_of_hours %q, expected %q, %q, %q or %q",
+				name, s.config.OutOfHours, Drop, Defer, Downgrade, Reroute)
+		}
+
+		if s.config.Bypass != "" {
+			bypass, err := routing.ParsePriority(s.config.Bypass)
+			if err != nil {
+				return nil, fmt.Errorf("target %q: schedule: bypass: %w", name, err)
+			}
+			s.bypass = bypass
+		}
+
+		calendar, err := schedule.New(s.config.Schedule, dir)
+		if err != nil {
+			return nil, fmt.Errorf("target %q: schedule: %w", name, err)
+		}
+		s.calendar = calendar
+		schedules[name] = s
+	}
+	return schedules, nil
+}
+
+// OffHours returns the action for a message with priority sent to name, a
+// target or service, at now. It returns nil if the message is sent as
+// usual because the target has no schedule, the schedule is open or the
+// priority bypasses it
+func (c *Config) OffHours(name string, priority int, now time.Time) *OffHours {
+	s, ok := c.schedules[name]
+	if !ok || s.calendar.Open(now) || (s.bypass != nil && s.bypass(priority)) {
+		return nil
+	}
+	off := &OffHours{Action: s.config.OutOfHours, Priority: s.config.Priority, Reroute: s.config.Reroute}
+	off.Until, _ = s.calendar.Next(now)
+	return off
+}
@@ -0,0 +1,60 @@
+package dispatcher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/kha7iq/pingme/service/notifier"
+)
+
+// recentMessages remembers the messages sent to each destination within
+// the dedup window of the destination
+type recentMessages struct {
+	mu        sync.Mutex
+	expires   map[string]time.Time
+	lastPrune time.Time
+}
+
+func newRecentMessages() *recentMessages {
+	return &recentMessages{expires: make(map[string]time.Time)}
+}
+
+// add records key for window and reports whether it is new, a key still
+// within its window is a duplicate
+func (r *recentMessages) add(key string, now time.Time, window time.Duration) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.lastPrune) >= time.Minute {
+		r.lastPrune = now
+		for k, exp := range r.expires {
+			if !now.Before(exp) {
+				delete(r.expires, k)
+			}
+		}
+	}
+	if exp, ok := r.expires[key]; ok && now.Before(exp) {
+		return false
+	}
+	r.expires[key] = now.Add(window)
+	return true
+}
+
+// remove forgets key, e.g. after the delivery failed so a repeat is sent
+func (r *recentMessages) remove(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.expires, key)
+}
+
+// dedupKey identifies msg sent to dest by its title and message
+func dedupKey(dest string, msg notifier.Message) string {
+	h := sha256.New()
+	for _, s := range []string{dest, msg.Title, msg.Body} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
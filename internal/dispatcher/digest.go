@@ -0,0 +1,192 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/routing"
+	"github.com/kha7iq/pingme/service/notifier"
+)
+
+// maxDigestLines is the number of distinct titles listed in a digest
+const maxDigestLines = 20
+
+// digests buffers the messages to targets with digest settings
+type digests struct {
+	d   *Dispatcher
+	ctx context.Context
+	now func() time.Time
+	// wg counts the digests being sent
+	wg *sync.WaitGroup
+
+	mu      sync.Mutex
+	batches map[string]*batch
+}
+
+// batch is the digest of a target and group being collected
+type batch struct {
+	dest     string
+	group    []string
+	messages []notifier.Message
+	first    time.Time
+	last     time.Time
+	timer    *time.Timer
+}
+
+// flushAll sends the digests being collected
+func (g *digests) flushAll() {
+	g.mu.Lock()
+	batches := make(map[string]*batch, len(g.batches))
+	for key, b := range g.batches {
+		batches[key] = b
+	}
+	g.mu.Unlock()
+	for key, b := range batches {
+		g.flush(key, b)
+	}
+}
+
+// add buffers msg if dest has digest settings and reports whether it did
+func (g *digests) add(dest string, msg notifier.Message) bool {
+	settings := g.d.config.Digest(dest)
+	if settings == nil {
+		return false
+	}
+
+	group := make([]string, len(settings.GroupBy))
+	for i, key := range settings.GroupBy {
+		v, _ := routing.Lookup(msg.Extra, key)
+		group[i] = key + "=" + v
+	}
+	key := dest + "\x00" + strings.Join(group, "\x00")
+	now := g.now()
+
+	g.mu.Lock()
+	b, ok := g.batches[key]
+	if !ok {
+		b = &batch{dest: dest, group: group, first: now}
+		// the timer may fire while b is flushed for being full, then it
+		// must not flush the next batch of key
+		b.timer = time.AfterFunc(settings.Window, func() { g.flush(key, b) })
+		g.batches[key] = b
+	}
+	b.messages = append(b.messages, msg)
+	b.last = now
+	full := settings.Max > 0 && len(b.messages) >= settings.Max
+	g.mu.Unlock()
+
+	if full {
+		g.flush(key, b)
+	}
+	return true
+}
+
+// flush sends the digest b of key in the background, unless it was
+// flushed already
+func (g *digests) flush(key string, b *batch) {
+	g.mu.Lock()
+	ok := g.batches[key] == b
+	if ok {
+		delete(g.batches, key)
+		b.timer.Stop()
+		g.wg.Add(1)
+	}
+	g.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	go func() {
+		defer g.wg.Done()
+		n, err := g.d.config.Resolve(b.dest)
+		if err == nil {
+			_, err = g.d.deliverNow(g.ctx, b.dest, n, b.message())
+		}
+		if err != nil {
+			log.Printf("Failed to send digest of %d message(s) to %s: %v", len(b.messages), b.dest, err)
+			return
+		}
+		log.Printf("Digest of %d message(s) sent to %s", len(b.messages), b.dest)
+	}()
+}
+
+// message summarizes the batch, a single message is sent as it is
+func (b *batch) message() notifier.Message {
+	if len(b.messages) == 1 {
+		return b.messages[0]
+	}
+
+	counts := make(map[string]int)
+	var lines []string
+	priority := b.messages[0].Priority
+	var prioritySet bool
+	for _, m := range b.messages {
+		prioritySet = prioritySet || m.PrioritySet
+		line := summaryLine(m)
+		if counts[line] == 0 {
+			lines = append(lines, line)
+		}
+		counts[line]++
+		if m.Priority > priority {
+			priority = m.Priority
+		}
+	}
+	// the most frequent first, ties in the order they were first seen
+	sort.SliceStable(lines, func(i, j int) bool { return counts[lines[i]] > counts[lines[j]] })
+
+	var body strings.Builder
+	layout := "15:04:05 MST"
+	if b.first.YearDay() != b.last.YearDay() || b.first.Year() != b.last.Year() {
+		layout = "2006-01-02 15:04:05 MST"
+	}
+	fmt.Fprintf(&body, "%d messages from %s to %s\n", len(b.messages), b.first.Format(layout), b.last.Format(layout))
+	for i, line := range lines {
+		if i == maxDigestLines {
+			fmt.Fprintf(&body, "\n… and %d more", len(lines)-i)
+			break
+		}
+		fmt.Fprintf(&body, "\n%d× %s", counts[line], line)
+	}
+
+	title := fmt.Sprintf("Digest: %d messages", len(b.messages))
+	var groups []string
+	for _, g := range b.group {
+		if !strings.HasSuffix(g, "=") {
+			groups = append(groups, g)
+		}
+	}
+	if len(groups) > 0 {
+		title += " (" + strings.Join(groups, ", ") + ")"
+	}
+
+	return notifier.Message{
+		Title:       title,
+		Body:        body.String(),
+		Priority:    priority,
+		PrioritySet: prioritySet,
+		Extra: map[string]interface{}{
+			"digest_count":      len(b.messages),
+			"digest_titles":     lines,
+			"digest_first_seen": b.first,
+			"digest_last_seen":  b.last,
+		},
+	}
+}
+
+// summaryLine describes a message in a digest by its title or the first
+// line of its body
+func summaryLine(m notifier.Message) string {
+	line := m.Title
+	if line == "" {
+		line, _, _ = strings.Cut(strings.TrimSpace(m.Body), "\n")
+	}
+	if r := []rune(line); len(r) > 100 {
+		line = string(r[:99]) + "…"
+	}
+	return line
+}
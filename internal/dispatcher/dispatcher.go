@@ -2,299 +2,514 @@ package dispatcher
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
-	"strconv"
-
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/config"
+	"github.com/kha7iq/pingme/internal/metrics"
+	"github.com/kha7iq/pingme/internal/ratelimit"
+	"github.com/kha7iq/pingme/internal/render"
+	"github.com/kha7iq/pingme/internal/routing"
 	"github.com/kha7iq/pingme/internal/types"
-
-	"github.com/kha7iq/pingme/service/discord"
-	"github.com/kha7iq/pingme/service/email"
-	"github.com/kha7iq/pingme/service/gotify"
-	"github.com/kha7iq/pingme/service/line"
-	"github.com/kha7iq/pingme/service/mastodon"
-	"github.com/kha7iq/pingme/service/matrix"
-	"github.com/kha7iq/pingme/service/mattermost"
-	"github.com/kha7iq/pingme/service/pushbullet"
-	"github.com/kha7iq/pingme/service/pushover"
-	"github.com/kha7iq/pingme/service/rocketchat"
-	"github.com/kha7iq/pingme/service/slack"
-	"github.com/kha7iq/pingme/service/telegram"
-	"github.com/kha7iq/pingme/service/twillio"
-	"github.com/kha7iq/pingme/service/wechat"
-	"github.com/kha7iq/pingme/service/zulip"
+	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
 )
 
 // Dispatcher routes webhook requests to appropriate services
-type Dispatcher struct{}
+type Dispatcher struct {
+	config *config.Config
+	// limits are the delivery rate limits by service name
+	limits map[string]serviceLimiter
+	recent *recentMessages
+	// digests and deferred are set by Start
+	digests  *digests
+	deferred *deferred
+	// background counts the deliveries started by digests and deferred
+	// messages
+	background sync.WaitGroup
+}
 
-// New creates a new dispatcher
-func New() *Dispatcher {
-	return &Dispatcher{}
+// serviceLimiter limits the deliveries to a service, per value of a
+// setting if per is set
+type serviceLimiter struct {
+	limiter *ratelimit.Limiter
+	per     string
 }
 
-// Dispatch sends the message to the specified service
-func (d *Dispatcher) Dispatch(ctx context.Context, req *types.WebhookRequest) error {
-	switch req.Service {
-	case "pushover":
-		return d.sendPushover(req)
-	case "telegram":
-		return d.sendTelegram(req)
-	case "slack":
-		return d.sendSlack(req)
-	case "discord":
-		return d.sendDiscord(req)
-	case "email":
-		return d.sendEmail(req)
-	case "mattermost":
-		return d.sendMattermost(req)
-	case "rocketchat":
-		return d.sendRocketChat(req)
-	case "pushbullet":
-		return d.sendPushbullet(req)
-	case "twillio":
-		return d.sendTwillio(req)
-	case "zulip":
-		return d.sendZulip(req)
-	case "mastodon":
-		return d.sendMastodon(req)
-	case "line":
-		return d.sendLine(req)
-	case "wechat":
-		return d.sendWeChat(req)
-	case "gotify":
-		return d.sendGotify(req)
-	case "matrix":
-		return d.sendMatrix(req)
-	default:
-		return fmt.Errorf("unsupported service: %s", req.Service)
+// New creates a new dispatcher. Named targets are resolved from cfg,
+// services requested directly read their credentials from environment
+// variables. Deliveries are limited to the rate limits of cfg
+func New(cfg *config.Config) *Dispatcher {
+	if cfg == nil {
+		cfg = &config.Config{Retry: helpers.DefaultRetryPolicy}
+	}
+	limits := make(map[string]serviceLimiter)
+	for name, l := range cfg.ServiceLimits() {
+		limits[name] = serviceLimiter{limiter: ratelimit.New(l.Limit), per: l.Per}
+	}
+	return &Dispatcher{
+		config: cfg,
+		limits: limits,
+		recent: newRecentMessages(),
 	}
 }
 
-// sendPushover sends message via Pushover
-func (d *Dispatcher) sendPushover(req *types.WebhookRequest) error {
-	token := os.Getenv("PUSHOVER_TOKEN")
-	user := os.Getenv("PUSHOVER_USER")
+// Start enables the features delivering in the background with ctx:
+// messages to targets with digest settings are buffered and messages
+// deferred by a schedule wait until it opens. Without it, e.g. in the
+// CLI, messages are sent right away and deferred ones are dropped
+func (d *Dispatcher) Start(ctx context.Context) {
+	d.digests = &digests{d: d, ctx: ctx, now: time.Now, wg: &d.background, batches: make(map[string]*batch)}
+	d.deferred = &deferred{d: d, ctx: ctx, wg: &d.background, timers: make(map[*time.Timer]waiting)}
+}
 
-	if token == "" || user == "" {
-		return fmt.Errorf("PUSHOVER_TOKEN and PUSHOVER_USER environment variables required")
+// Flush sends the digests being collected and waits until the
+// deliveries in the background are done or ctx is done. Deferred
+// messages still waiting for their schedule are dropped, unless they
+// were taken with TakeDeferred
+func (d *Dispatcher) Flush(ctx context.Context) error {
+	if d.digests == nil {
+		return nil
 	}
-
-	priority := 0
-	if req.Priority != 0 {
-		priority = req.Priority
+	d.digests.flushAll()
+	d.deferred.stop()
+
+	done := make(chan struct{})
+	go func() {
+		d.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-
-	return pushover.SendMessage(token, user, req.Title, req.Message, priority)
 }
 
-// sendTelegram sends message via Telegram
-func (d *Dispatcher) sendTelegram(req *types.WebhookRequest) error {
-	token := os.Getenv("TELEGRAM_TOKEN")
-	channels := os.Getenv("TELEGRAM_CHANNELS")
-
-	if token == "" || channels == "" {
-		return fmt.Errorf("TELEGRAM_TOKEN and TELEGRAM_CHANNELS environment variables required")
-	}
+// RateLimitError is returned for deliveries over the rate limit of their
+// service in FailFast contexts
+type RateLimitError struct {
+	Service string
+	// RetryAfter is the time until the limit allows another delivery
+	RetryAfter time.Duration
+}
 
-	return telegram.SendMessage(token, channels, req.Title, req.Message)
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit of %s exceeded, retry in %s", e.Service, e.RetryAfter.Round(time.Millisecond))
 }
 
-// sendSlack sends message via Slack
-func (d *Dispatcher) sendSlack(req *types.WebhookRequest) error {
-	token := os.Getenv("SLACK_TOKEN")
-	channels := os.Getenv("SLACK_CHANNELS")
+// Retryable implements the interface checked by helpers.Retryable, a
+// retry could only wait for the limit
+func (e *RateLimitError) Retryable() bool {
+	return false
+}
 
-	if token == "" || channels == "" {
-		return fmt.Errorf("SLACK_TOKEN and SLACK_CHANNELS environment variables required")
-	}
+// failFastContext is the context key set by FailFast
+type failFastContext struct{}
 
-	return slack.SendMessage(token, channels, req.Title, req.Message)
+// FailFast returns a context in which deliveries over a rate limit fail
+// with a *RateLimitError instead of waiting for the limit
+func FailFast(ctx context.Context) context.Context {
+	return context.WithValue(ctx, failFastContext{}, true)
 }
 
-// sendDiscord sends message via Discord
-func (d *Dispatcher) sendDiscord(req *types.WebhookRequest) error {
-	token := os.Getenv("DISCORD_TOKEN")
-	channels := os.Getenv("DISCORD_CHANNELS")
-
-	if token == "" || channels == "" {
-		return fmt.Errorf("DISCORD_TOKEN and DISCORD_CHANNELS environment variables required")
+// Dispatch sends the message to the requested target or service,
+// retrying transient failures. Requests without a destination are sent to
+// the targets of the matching routes, if there are several the returned
+// Delivery sums up all of them
+func (d *Dispatcher) Dispatch(ctx context.Context, req *types.WebhookRequest) (Delivery, error) {
+	if !d.Route(req) {
+		return Delivery{}, ErrNoRoute
+	}
+	if len(req.Services) > 0 {
+		deliveries, err := d.Deliver(ctx, req)
+		return summarize(deliveries, err), err
 	}
 
-	return discord.SendMessage(token, channels, req.Title, req.Message)
-}
+	dest := req.Target
+	if dest == "" {
+		dest = req.Service
+	}
 
-// sendEmail sends message via Email
-func (d *Dispatcher) sendEmail(req *types.WebhookRequest) error {
-	sender := os.Getenv("EMAIL_SENDER")
-	password := os.Getenv("EMAIL_PASSWORD")
-	host := os.Getenv("EMAIL_HOST")
-	port := os.Getenv("EMAIL_PORT")
-	receiver := os.Getenv("EMAIL_RECEIVER")
+	msg, err := Message(req)
+	if err != nil {
+		return Delivery{Destination: dest}, err
+	}
 
-	if sender == "" || password == "" || host == "" || port == "" || receiver == "" {
-		return fmt.Errorf("EMAIL_SENDER, EMAIL_PASSWORD, EMAIL_HOST, EMAIL_PORT, and EMAIL_RECEIVER environment variables required")
+	n, err := d.notifier(req)
+	if err != nil {
+		return Delivery{Destination: dest}, err
 	}
 
-	return email.SendMessage(sender, password, host, port, "", receiver, req.Title, req.Message)
+	return d.deliver(ctx, dest, n, msg)
 }
 
-// sendMattermost sends message via Mattermost
-func (d *Dispatcher) sendMattermost(req *types.WebhookRequest) error {
-	token := os.Getenv("MATTERMOST_TOKEN")
-	serverURL := os.Getenv("MATTERMOST_SERVER_URL")
-	channels := os.Getenv("MATTERMOST_CHANNELS")
-	scheme := os.Getenv("MATTERMOST_SCHEME")
-	if scheme == "" {
-		scheme = "https"
+// Deliver sends req to its target, service or services and returns the
+// outcome of every delivery. Requests with several services fail according
+// to their failure policy
+func (d *Dispatcher) Deliver(ctx context.Context, req *types.WebhookRequest) ([]Delivery, error) {
+	if !d.Route(req) {
+		return nil, ErrNoRoute
+	}
+	if len(req.Services) == 0 {
+		delivery, err := d.Dispatch(ctx, req)
+		return []Delivery{delivery}, err
 	}
 
-	if token == "" || serverURL == "" || channels == "" {
-		return fmt.Errorf("MATTERMOST_TOKEN, MATTERMOST_SERVER_URL, and MATTERMOST_CHANNELS environment variables required")
+	policy, err := ParseFailurePolicy(req.FailOn)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := Message(req)
+	if err != nil {
+		return nil, err
 	}
 
-	return mattermost.SendMessage(token, serverURL, scheme, "/api/v4/posts", channels, req.Title, req.Message)
+	results := d.Send(ctx, req.Services, msg)
+	if policy.Failed(results) {
+		return results, fmt.Errorf("failed to send message (fail_on=%s)", policy)
+	}
+	return results, nil
 }
 
-// sendRocketChat sends message via RocketChat
-func (d *Dispatcher) sendRocketChat(req *types.WebhookRequest) error {
-	serverURL := os.Getenv("ROCKETCHAT_SERVER_URL")
-	userID := os.Getenv("ROCKETCHAT_USERID")
-	token := os.Getenv("ROCKETCHAT_TOKEN")
-	channels := os.Getenv("ROCKETCHAT_CHANNELS")
-	scheme := os.Getenv("ROCKETCHAT_URL_SCHEME")
-	if scheme == "" {
-		scheme = "https"
-	}
+// ErrNoRoute is returned for requests without a destination that match no
+// route
+var ErrNoRoute = errors.New("no service, target or services given and no route matched")
 
-	if serverURL == "" || userID == "" || token == "" || channels == "" {
-		return fmt.Errorf("ROCKETCHAT_SERVER_URL, ROCKETCHAT_USERID, ROCKETCHAT_TOKEN, and ROCKETCHAT_CHANNELS environment variables required")
+// Route sets the destination of req to the targets of the matching routes
+// if it has none. It reports whether req has a destination
+func (d *Dispatcher) Route(req *types.WebhookRequest) bool {
+	if HasDestination(req) {
+		return true
 	}
+	SetDestination(req, d.Routes(req))
+	return HasDestination(req)
+}
 
-	return rocketchat.SendMessage(serverURL, scheme, userID, token, channels, req.Title, req.Message)
+// Routes returns the targets of the routes matching req
+func (d *Dispatcher) Routes(req *types.WebhookRequest) []string {
+	return d.config.Router().Targets(routing.Message{
+		Source:   req.Source,
+		Priority: req.Priority,
+		Extra:    req.Extra,
+	})
 }
 
-// sendPushbullet sends message via Pushbullet
-func (d *Dispatcher) sendPushbullet(req *types.WebhookRequest) error {
-	token := os.Getenv("PUSHBULLET_TOKEN")
-	device := os.Getenv("PUSHBULLET_DEVICE")
+// HasDestination reports whether req names a target, service or services
+func HasDestination(req *types.WebhookRequest) bool {
+	return req.Target != "" || req.Service != "" || len(req.Services) > 0
+}
 
-	if token == "" || device == "" {
-		return fmt.Errorf("PUSHBULLET_TOKEN and PUSHBULLET_DEVICE environment variables required")
+// SetDestination replaces the destination of req with destinations, which
+// may name targets or services and become the services of req. Nothing
+// changes if destinations is empty
+func SetDestination(req *types.WebhookRequest, destinations []string) {
+	if len(destinations) > 0 {
+		req.Target, req.Service, req.Services = "", "", append([]string(nil), destinations...)
 	}
+}
 
-	return pushbullet.SendMessage(token, device, req.Title, req.Message)
+// summarize combines deliveries to several destinations into one
+func summarize(deliveries []Delivery, err error) Delivery {
+	if len(deliveries) == 1 {
+		return deliveries[0]
+	}
+	var (
+		summary      Delivery
+		destinations []string
+	)
+	for _, d := range deliveries {
+		destinations = append(destinations, d.Destination)
+		summary.Attempts += d.Attempts
+		if d.Duration > summary.Duration {
+			summary.Duration = d.Duration
+		}
+		if d.RetryAfter > summary.RetryAfter {
+			summary.RetryAfter = d.RetryAfter
+		}
+	}
+	summary.Destination = strings.Join(destinations, ",")
+	summary.Success = err == nil
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	return summary
 }
 
-// sendTwillio sends SMS via Twilio
-func (d *Dispatcher) sendTwillio(req *types.WebhookRequest) error {
-	accountSID := os.Getenv("TWILLIO_ACCOUNT_SID")
-	token := os.Getenv("TWILLIO_TOKEN")
-	sender := os.Getenv("TWILLIO_SENDER")
-	receiver := os.Getenv("TWILLIO_RECEIVER")
+// notifier builds the Notifier for the target or service of req
+func (d *Dispatcher) notifier(req *types.WebhookRequest) (notifier.Notifier, error) {
+	if req.Target != "" {
+		return d.config.Notifier(req.Target)
+	}
 
-	if accountSID == "" || token == "" || sender == "" || receiver == "" {
-		return fmt.Errorf("TWILLIO_ACCOUNT_SID, TWILLIO_TOKEN, TWILLIO_SENDER, and TWILLIO_RECEIVER environment variables required")
+	svc, ok := notifier.Lookup(req.Service)
+	if !ok {
+		return nil, fmt.Errorf("unsupported service: %s", req.Service)
 	}
 
-	return twillio.SendMessage(accountSID, token, sender, receiver, req.Title, req.Message)
+	n, err := svc.New(notifier.Env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure %s: %w", svc.Name, err)
+	}
+	return n, nil
 }
 
-// sendZulip sends message via Zulip
-func (d *Dispatcher) sendZulip(req *types.WebhookRequest) error {
-	domain := os.Getenv("ZULIP_DOMAIN")
-	botEmail := os.Getenv("ZULIP_BOT_EMAIL_ADDRESS")
-	apiKey := os.Getenv("ZULIP_BOT_API_KEY")
-	msgType := os.Getenv("ZULIP_MSG_TYPE")
-	stream := os.Getenv("ZULIP_STREAM_NAME")
-
-	if domain == "" || botEmail == "" || apiKey == "" || stream == "" {
-		return fmt.Errorf("ZULIP_DOMAIN, ZULIP_BOT_EMAIL_ADDRESS, ZULIP_BOT_API_KEY, and ZULIP_STREAM_NAME environment variables required")
+// Message converts a webhook request to a notifier.Message, rendering the
+// request template if one is set. Request templates come from the caller,
+// so unlike templates in the config file they have no access to the
+// environment
+func Message(req *types.WebhookRequest) (notifier.Message, error) {
+	msg := notifier.Message{
+		Title:    req.Title,
+		Body:     req.Message,
+		Priority: req.Priority,
+		Extra:    req.Extra,
+	}
+	if req.Template == "" {
+		return msg, nil
 	}
 
-	if msgType == "" {
-		msgType = "stream"
+	tpl, err := render.Parse("request", req.Template)
+	if err != nil {
+		return msg, err
 	}
+	return tpl.Apply(msg, nil, nil)
+}
 
-	return zulip.SendMessage(domain, botEmail, apiKey, msgType, stream, req.Title, req.Message)
+// Delivery is the outcome of sending a message to a single destination
+type Delivery struct {
+	Destination string        `json:"destination"`
+	Success     bool          `json:"success"`
+	Error       string        `json:"error,omitempty"`
+	Attempts    int           `json:"attempts"`
+	Duration    time.Duration `json:"-"`
+	// RetryAfter is set if the delivery failed on a rate limit
+	RetryAfter time.Duration `json:"-"`
+	// Duplicate is set if the message was not sent because the same
+	// message was sent within the dedup window of the destination
+	Duplicate bool `json:"duplicate,omitempty"`
+	// Batched is set if the message was added to a digest of the
+	// destination, which is sent later. Success only means it was
+	// accepted, the digest lives in memory until then
+	Batched bool `json:"batched,omitempty"`
+	// OutOfHours is the action taken for a message outside the schedule
+	// of the destination: drop, defer or reroute
+	OutOfHours string `json:"out_of_hours,omitempty"`
+	// Receipts identify deliveries the recipient can acknowledge, e.g.
+	// Pushover emergency notifications
+	Receipts []string `json:"receipts,omitempty"`
 }
 
-// sendMastodon sends message via Mastodon
-func (d *Dispatcher) sendMastodon(req *types.WebhookRequest) error {
-	token := os.Getenv("MASTODON_TOKEN")
-	serverURL := os.Getenv("MASTODON_SERVER")
+// Send delivers msg concurrently to every destination, each being a
+// target from the config file or a service name. The returned deliveries
+// are in the same order as destinations.
+func (d *Dispatcher) Send(ctx context.Context, destinations []string, msg notifier.Message) []Delivery {
+	return d.sendAll(ctx, destinations, msg, d.deliver)
+}
+
+// SendNow delivers msg like Send, but ignores the schedule, dedup window
+// and digest settings of the destinations, e.g. for escalations which
+// must reach the recipient right away. Rate limits still apply
+func (d *Dispatcher) SendNow(ctx context.Context, destinations []string, msg notifier.Message) []Delivery {
+	return d.sendAll(ctx, destinations, msg, d.deliverNow)
+}
 
-	if token == "" || serverURL == "" {
-		return fmt.Errorf("MASTODON_TOKEN and MASTODON_SERVER environment variables required")
+// deliverFunc sends msg to dest with n
+type deliverFunc func(ctx context.Context, dest string, n notifier.Notifier, msg notifier.Message) (Delivery, error)
+
+// sendAll delivers msg concurrently to every destination with deliver
+func (d *Dispatcher) sendAll(ctx context.Context, destinations []string, msg notifier.Message, deliver deliverFunc) []Delivery {
+	deliveries := make([]Delivery, len(destinations))
+
+	var wg sync.WaitGroup
+	for i, dest := range destinations {
+		wg.Add(1)
+		go func(i int, dest string) {
+			defer wg.Done()
+			n, err := d.config.Resolve(dest)
+			if err != nil {
+				deliveries[i] = Delivery{Destination: dest, Error: err.Error()}
+				return
+			}
+			deliveries[i], _ = deliver(ctx, dest, n, msg)
+		}(i, dest)
 	}
+	wg.Wait()
 
-	return mastodon.SendMessage(token, serverURL, req.Title, req.Message)
+	return deliveries
 }
 
-// sendLine sends message via Line
-func (d *Dispatcher) sendLine(req *types.WebhookRequest) error {
-	secret := os.Getenv("LINE_SECRET")
-	token := os.Getenv("LINE_TOKEN")
-	receivers := os.Getenv("LINE_RECEIVER_IDS")
+// deliver sends msg with n, retrying transient failures with the retry
+// policy of dest. Every attempt counts against the rate limit of dest.
+// Messages outside the schedule of dest are handled by its out of hours
+// action. Messages repeated within the dedup window of dest are not sent
+// again, messages to targets with digest settings are buffered once Start
+// was called
+func (d *Dispatcher) deliver(ctx context.Context, dest string, n notifier.Notifier, msg notifier.Message) (Delivery, error) {
+	if handled, err := d.offHours(ctx, dest, &msg, n); handled != nil {
+		return *handled, err
+	}
+
+	var key string
+	if window := d.config.DedupWindow(dest); window > 0 {
+		key = dedupKey(dest, msg)
+		if !d.recent.add(key, time.Now(), window) {
+			log.Printf("Suppressed duplicate message to %s", dest)
+			return Delivery{Destination: dest, Success: true, Duplicate: true}, nil
+		}
+	}
 
-	if secret == "" || token == "" || receivers == "" {
-		return fmt.Errorf("LINE_SECRET, LINE_TOKEN, and LINE_RECEIVER_IDS environment variables required")
+	if d.digests != nil && d.digests.add(dest, msg) {
+		return Delivery{Destination: dest, Success: true, Batched: true}, nil
 	}
 
-	return line.SendMessage(secret, token, receivers, req.Title, req.Message)
+	delivery, err := d.deliverNow(ctx, dest, n, msg)
+	if err != nil && key != "" {
+		d.recent.remove(key)
+	}
+	return delivery, err
 }
 
-// sendWeChat sends message via WeChat
-func (d *Dispatcher) sendWeChat(req *types.WebhookRequest) error {
-	appID := os.Getenv("WECHAT_APPID")
-	appSecret := os.Getenv("WECHAT_APPSECRET")
-	token := os.Getenv("WECHAT_TOKEN")
-	aesKey := os.Getenv("WECHAT_ENCODING_AES_KEY")
-	receivers := os.Getenv("WECHAT_RECEIVERS")
+// deliverNow sends msg with n, retrying transient failures with the retry
+// policy of dest
+func (d *Dispatcher) deliverNow(ctx context.Context, dest string, n notifier.Notifier, msg notifier.Message) (Delivery, error) {
+	start := time.Now()
+	delivery := Delivery{Destination: dest}
 
-	if appID == "" || appSecret == "" || token == "" || aesKey == "" || receivers == "" {
-		return fmt.Errorf("WECHAT_APPID, WECHAT_APPSECRET, WECHAT_TOKEN, WECHAT_ENCODING_AES_KEY, and WECHAT_RECEIVERS environment variables required")
+	limit := d.limit(dest)
+	attempts, err := d.config.RetryPolicy(dest).Do(ctx, func() error {
+		if limitErr := limit.take(ctx); limitErr != nil {
+			return limitErr
+		}
+		result, sendErr := n.Send(ctx, msg)
+		delivery.Receipts = result.Receipts
+		return sendErr
+	})
+
+	delivery.Attempts = attempts
+	delivery.Duration = time.Since(start)
+	observe(d.config.ServiceName(dest), delivery.Duration, attempts, err)
+	if err != nil {
+		delivery.Error = err.Error()
+		var limitErr *RateLimitError
+		if errors.As(err, &limitErr) {
+			delivery.RetryAfter = limitErr.RetryAfter
+		}
+		return delivery, err
 	}
+	delivery.Success = true
+	return delivery, nil
+}
 
-	return wechat.SendMessage(appID, appSecret, token, aesKey, receivers, req.Title, req.Message)
+// deliveryLimit is the rate limit of the deliveries to a destination
+type deliveryLimit struct {
+	service string
+	limiter *ratelimit.Limiter
+	// keys are the buckets every delivery takes a token from, one per
+	// value of the per setting of the service
+	keys []string
 }
 
-// sendGotify sends message via Gotify
-func (d *Dispatcher) sendGotify(req *types.WebhookRequest) error {
-	url := os.Getenv("GOTIFY_URL")
-	token := os.Getenv("GOTIFY_TOKEN")
+// limit returns the rate limit of dest, nil if its service has none
+func (d *Dispatcher) limit(dest string) *deliveryLimit {
+	service := d.config.ServiceName(dest)
+	l, ok := d.limits[service]
+	if !ok {
+		return nil
+	}
+	limit := &deliveryLimit{service: service, limiter: l.limiter, keys: []string{service}}
+	if l.per != "" {
+		// settings like channels hold a comma-separated list, each value
+		// has its own limit
+		limit.keys = limit.keys[:0]
+		for _, value := range strings.Split(d.config.Setting(dest, l.per), ",") {
+			limit.keys = append(limit.keys, service+"/"+strings.TrimSpace(value))
+		}
+	}
+	return limit
+}
 
-	if url == "" || token == "" {
-		return fmt.Errorf("GOTIFY_URL and GOTIFY_TOKEN environment variables required")
+// take takes a delivery from every bucket of l, waiting until they allow
+// it unless ctx is a FailFast context
+func (l *deliveryLimit) take(ctx context.Context) error {
+	if l == nil {
+		return nil
 	}
 
-	priority := 5
-	if req.Priority != 0 {
-		priority = req.Priority
-	} else if priorityStr := os.Getenv("GOTIFY_PRIORITY"); priorityStr != "" {
-		if p, err := strconv.Atoi(priorityStr); err == nil {
-			priority = p
+	if failFast, _ := ctx.Value(failFastContext{}).(bool); failFast {
+		// check all buckets first so a blocked one doesn't use up the
+		// tokens of the others
+		for _, key := range l.keys {
+			if blocked, after := l.limiter.Blocked(key); blocked {
+				return &RateLimitError{Service: l.service, RetryAfter: after}
+			}
+		}
+		for _, key := range l.keys {
+			if ok, after := l.limiter.Allow(key); !ok {
+				return &RateLimitError{Service: l.service, RetryAfter: after}
+			}
 		}
+		return nil
 	}
+	for _, key := range l.keys {
+		if err := l.limiter.Wait(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return gotify.SendMessage(url, token, req.Title, req.Message, priority)
+// observe records the metrics of a delivery
+func observe(service string, duration time.Duration, attempts int, err error) {
+	result := metrics.ResultSuccess
+	if err != nil {
+		result = metrics.ResultFailure
+	}
+	metrics.Deliveries.WithLabelValues(service, result).Inc()
+	metrics.DeliveryDuration.WithLabelValues(service).Observe(duration.Seconds())
+	if attempts > 1 {
+		metrics.DeliveryRetries.WithLabelValues(service).Add(float64(attempts - 1))
+	}
 }
 
-// sendMatrix sends message via Matrix
-func (d *Dispatcher) sendMatrix(req *types.WebhookRequest) error {
-	serverURL := os.Getenv("MATRIX_SERVER_URL")
-	accessToken := os.Getenv("MATRIX_ACCESS_TOKEN")
-	roomID := os.Getenv("MATRIX_ROOM_ID")
-	domain := os.Getenv("MATRIX_DOMAIN")
+// FailurePolicy decides whether a fan-out send counts as failed
+type FailurePolicy string
+
+const (
+	// FailAny fails when at least one destination failed
+	FailAny FailurePolicy = "any"
+	// FailAll fails only when every destination failed
+	FailAll FailurePolicy = "all"
+)
 
-	if serverURL == "" || accessToken == "" {
-		return fmt.Errorf("MATRIX_SERVER_URL and MATRIX_ACCESS_TOKEN environment variables required")
+// ParseFailurePolicy parses s, an empty string defaults to FailAny
+func ParseFailurePolicy(s string) (FailurePolicy, error) {
+	switch FailurePolicy(s) {
+	case "", FailAny:
+		return FailAny, nil
+	case FailAll:
+		return FailAll, nil
+	default:
+		return "", fmt.Errorf("invalid failure policy %q, expected %q or %q", s, FailAny, FailAll)
 	}
+}
 
-	room := os.Getenv("MATRIX_ROOM")
-	if room == "" && (roomID == "" || domain == "") {
-		return fmt.Errorf("MATRIX_ROOM or (MATRIX_ROOM_ID and MATRIX_DOMAIN) environment variables required")
+// Failed reports whether deliveries count as failed under the policy
+func (p FailurePolicy) Failed(deliveries []Delivery) bool {
+	failed := 0
+	for _, d := range deliveries {
+		if !d.Success {
+			failed++
+		}
 	}
 
-	return matrix.SendMessage(serverURL, "", "", accessToken, room, roomID, domain, "", req.Message, false)
+	if p == FailAll {
+		return len(deliveries) > 0 && failed == len(deliveries)
+	}
+	return failed > 0
 }
@@ -0,0 +1,392 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/config"
+	"github.com/kha7iq/pingme/internal/types"
+	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeNotifier fails when the message body equals its fail value
+type fakeNotifier struct {
+	fail string
+}
+
+func (f fakeNotifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	if msg.Body == f.fail {
+		return notifier.Result{}, errors.New("fake failure")
+	}
+	return notifier.Result{Service: "fake"}, nil
+}
+
+// flakyNotifier fails with a retryable error the given number of times
+type flakyNotifier struct {
+	failures int
+}
+
+func (f *flakyNotifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	if f.failures > 0 {
+		f.failures--
+		return notifier.Result{}, &helpers.StatusError{StatusCode: http.StatusBadGateway}
+	}
+	return notifier.Result{Service: "fake"}, nil
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name: "fake-ok",
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			return fakeNotifier{}, nil
+		},
+	})
+	notifier.Register(notifier.Service{
+		Name: "fake-flaky",
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			return &flakyNotifier{failures: 2}, nil
+		},
+	})
+	notifier.Register(notifier.Service{
+		Name: "fake-fail",
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			return fakeNotifier{fail: "boom"}, nil
+		},
+	})
+	notifier.Register(notifier.Service{
+		Name: "fake-channel",
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			src("channel", "FAKE_CHANNEL")
+			return fakeNotifier{}, nil
+		},
+	})
+}
+
+func TestSend(t *testing.T) {
+	d := New(&config.Config{})
+	results := d.Send(context.Background(), []string{"fake-ok", "fake-fail", "missing"}, notifier.Message{Body: "boom"})
+
+	assert.Len(t, results, 3)
+	assert.Equal(t, "fake-ok", results[0].Destination)
+	assert.True(t, results[0].Success)
+	assert.False(t, results[1].Success)
+	assert.Equal(t, "fake failure", results[1].Error)
+	assert.False(t, results[2].Success)
+
+	assert.True(t, FailAny.Failed(results))
+	assert.False(t, FailAll.Failed(results))
+	assert.True(t, FailAll.Failed(results[1:]))
+	assert.False(t, FailAny.Failed(results[:1]))
+}
+
+func TestSend_Retry(t *testing.T) {
+	attempts := 2
+	cfg := &config.Config{
+		Retry: helpers.RetryPolicy{Attempts: 3, Delay: time.Millisecond},
+		Targets: map[string]config.Target{
+			"flaky-once": {Service: "fake-flaky", Retry: &helpers.RetryOverride{Attempts: &attempts}},
+		},
+	}
+	results := New(cfg).Send(context.Background(), []string{"fake-flaky", "flaky-once"}, notifier.Message{Body: "hi"})
+
+	assert.True(t, results[0].Success)
+	assert.Equal(t, 3, results[0].Attempts)
+	assert.False(t, results[1].Success)
+	assert.Equal(t, 2, results[1].Attempts)
+}
+
+func TestParseFailurePolicy(t *testing.T) {
+	p, err := ParseFailurePolicy("")
+	assert.Nil(t, err)
+	assert.Equal(t, FailAny, p)
+
+	p, err = ParseFailurePolicy("all")
+	assert.Nil(t, err)
+	assert.Equal(t, FailAll, p)
+
+	_, err = ParseFailurePolicy("some")
+	assert.NotNil(t, err)
+}
+
+func TestDispatch_Routes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pingme.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(`
+routes:
+  - match:
+      source: /webhook/*
+    targets: [fake-ok]
+    routes:
+      - match:
+          priority: ">=1"
+        targets: [fake-ok, fake-fail]
+`), 0o600))
+	cfg, err := config.Load(path)
+	assert.Nil(t, err)
+	d := New(cfg)
+
+	req := &types.WebhookRequest{Message: "hi", Source: "/webhook/grafana"}
+	delivery, err := d.Dispatch(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, "fake-ok", delivery.Destination)
+	assert.True(t, delivery.Success)
+	assert.Equal(t, []string{"fake-ok"}, req.Services)
+
+	req = &types.WebhookRequest{Message: "hi", Priority: 2, Source: "/webhook/grafana"}
+	assert.True(t, d.Route(req))
+	assert.Equal(t, []string{"fake-ok", "fake-fail"}, req.Services)
+
+	// explicit destinations are kept
+	req = &types.WebhookRequest{Message: "hi", Service: "fake-fail", Source: "/webhook/grafana"}
+	assert.True(t, d.Route(req))
+	assert.Equal(t, "fake-fail", req.Service)
+
+	_, err = d.Dispatch(context.Background(), &types.WebhookRequest{Message: "hi", Source: "/webhook"})
+	assert.ErrorIs(t, err, ErrNoRoute)
+}
+
+func TestDeliver_RateLimit(t *testing.T) {
+	d := New(&config.Config{
+		Retry: helpers.RetryPolicy{Attempts: 3},
+		Targets: map[string]config.Target{
+			"alerts": {Service: "fake-channel", Settings: map[string]string{"channel": "alerts"}},
+			"builds": {Service: "fake-channel", Settings: map[string]string{"channel": "builds"}},
+			"both":   {Service: "fake-channel", Settings: map[string]string{"channel": "alerts, ops"}},
+			"ops":    {Service: "fake-channel", Settings: map[string]string{"channel": "ops"}},
+		},
+		RateLimits: map[string]config.RateLimit{
+			"fake-ok":      {Rate: "1/h"},
+			"fake-channel": {Rate: "1/h", Per: "channel"},
+		},
+	})
+	ctx := FailFast(context.Background())
+	msg := notifier.Message{Body: "hi"}
+
+	results := d.Send(ctx, []string{"fake-ok", "alerts", "builds"}, msg)
+	for _, r := range results {
+		assert.True(t, r.Success, r.Destination)
+	}
+
+	// over the limit deliveries fail without retries
+	results = d.Send(ctx, []string{"fake-ok", "alerts", "fake-flaky"}, msg)
+	for _, r := range results[:2] {
+		assert.False(t, r.Success, r.Destination)
+		assert.Equal(t, 1, r.Attempts)
+		assert.Greater(t, r.RetryAfter, 59*time.Minute)
+	}
+	assert.True(t, results[2].Success, "services without limit")
+
+	// lists of channels take a token from each channel, a blocked one
+	// leaves the others alone
+	results = d.Send(ctx, []string{"both", "ops", "ops"}, msg)
+	assert.False(t, results[0].Success)
+	assert.True(t, results[1].Success != results[2].Success, "one token for ops")
+
+	delivery, err := d.Dispatch(ctx, &types.WebhookRequest{Service: "fake-ok", Message: "hi"})
+	var limitErr *RateLimitError
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, "fake-ok", limitErr.Service)
+	assert.Equal(t, limitErr.RetryAfter, delivery.RetryAfter)
+
+	// without FailFast deliveries wait for the limit
+	waiting, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = d.Dispatch(waiting, &types.WebhookRequest{Service: "fake-ok", Message: "hi"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestDeliver_Dedup(t *testing.T) {
+	off := time.Duration(0)
+	d := New(&config.Config{
+		Dedup: time.Hour,
+		Targets: map[string]config.Target{
+			"noisy": {Service: "fake-ok", Dedup: &off},
+		},
+	})
+	ctx := context.Background()
+	send := func(dest, body string) Delivery {
+		return d.Send(ctx, []string{dest}, notifier.Message{Title: "t", Body: body})[0]
+	}
+
+	assert.False(t, send("fake-ok", "hi").Duplicate)
+	dup := send("fake-ok", "hi")
+	assert.True(t, dup.Success)
+	assert.True(t, dup.Duplicate)
+	assert.Equal(t, 0, dup.Attempts)
+	assert.False(t, send("fake-ok", "other").Duplicate)
+	assert.False(t, send("fake-channel", "hi").Duplicate, "destinations are deduplicated separately")
+
+	// targets can turn dedup off
+	assert.False(t, send("noisy", "hi").Duplicate)
+	assert.False(t, send("noisy", "hi").Duplicate)
+
+	// failed deliveries are sent again
+	assert.False(t, send("fake-fail", "boom").Success)
+	assert.False(t, send("fake-fail", "boom").Duplicate)
+}
+
+// recorded receives the messages sent to the fake-record service
+var recorded = make(chan notifier.Message, 10)
+
+type recordNotifier struct{}
+
+func (recordNotifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	recorded <- msg
+	return notifier.Result{Service: "fake"}, nil
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name: "fake-record",
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			return recordNotifier{}, nil
+		},
+	})
+}
+
+func TestDeliver_Digest(t *testing.T) {
+	d := New(&config.Config{
+		Targets: map[string]config.Target{
+			"incidents": {Service: "fake-record", Digest: &config.Digest{Window: time.Hour, Max: 3, GroupBy: []string{"alertname"}}},
+			"slow":      {Service: "fake-record", Digest: &config.Digest{Window: 20 * time.Millisecond}},
+		},
+	})
+	ctx := context.Background()
+	send := func(dest, title string, priority int, extra map[string]interface{}) Delivery {
+		return d.Send(ctx, []string{dest}, notifier.Message{Title: title, Body: "b", Priority: priority, Extra: extra})[0]
+	}
+
+	// without Start messages are sent right away
+	assert.False(t, send("incidents", "direct", 0, nil).Batched)
+	assert.Equal(t, "direct", (<-recorded).Title)
+
+	d.Start(ctx)
+	cpu := map[string]interface{}{"alertname": "HighCPU"}
+	delivery := send("incidents", "host-1 down", 1, cpu)
+	assert.True(t, delivery.Success)
+	assert.True(t, delivery.Batched)
+	send("incidents", "host-2 down", 0, map[string]interface{}{"alertname": "Disk"})
+	send("incidents", "host-1 down", 5, cpu)
+	full := d.digests.batches["incidents\x00alertname=HighCPU"]
+	send("incidents", "host-3 down", 2, cpu)
+
+	// the third message of the group fills its digest
+	msg := <-recorded
+	assert.Equal(t, "Digest: 3 messages (alertname=HighCPU)", msg.Title)
+	assert.Contains(t, msg.Body, "3 messages from ")
+	assert.Contains(t, msg.Body, "\n2× host-1 down\n1× host-3 down")
+	assert.Equal(t, 5, msg.Priority)
+	assert.Equal(t, 3, msg.Extra["digest_count"])
+	assert.Equal(t, []string{"host-1 down", "host-3 down"}, msg.Extra["digest_titles"])
+
+	// the window timer of a full digest does not flush the next one
+	send("incidents", "host-4 down", 0, cpu)
+	d.digests.flush("incidents\x00alertname=HighCPU", full)
+	assert.Empty(t, recorded)
+
+	// digests are sent after the window, a single message as it is
+	send("slow", "lonely", 0, nil)
+	msg = <-recorded
+	assert.Equal(t, "lonely", msg.Title)
+	assert.Equal(t, "b", msg.Body)
+
+	// the rest is flushed on shutdown
+	assert.NoError(t, d.Flush(ctx))
+	titles := []string{(<-recorded).Title, (<-recorded).Title}
+	assert.ElementsMatch(t, []string{"host-2 down", "host-4 down"}, titles)
+	assert.Empty(t, recorded)
+}
+
+func TestDigestMessage(t *testing.T) {
+	first := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	b := &batch{dest: "t", first: first, last: first.Add(time.Minute)}
+	for i := 0; i < maxDigestLines+2; i++ {
+		b.messages = append(b.messages, notifier.Message{Body: "line " + string(rune('a'+i)) + "\nmore"})
+	}
+
+	msg := b.message()
+	assert.Equal(t, "Digest: 22 messages", msg.Title)
+	assert.Contains(t, msg.Body, "22 messages from 10:00:00 UTC to 10:01:00 UTC\n\n1× line a\n")
+	assert.Contains(t, msg.Body, "\n1× line t\n… and 2 more")
+	assert.NotContains(t, msg.Body, "line u")
+}
+
+func TestDeliver_Schedule(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UTC()
+	var closed string
+	for _, day := range []int{-1, 0, 1} {
+		closed += now.AddDate(0, 0, day).Format("2006-01-02") + "\n"
+	}
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "closed.txt"), []byte(closed), 0o600))
+	path := filepath.Join(dir, "pingme.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(`
+targets:
+  quiet-drop:
+    service: fake-record
+    schedule: {timezone: UTC, holidays: closed.txt, out_of_hours: drop}
+  quiet-defer:
+    service: fake-record
+    schedule: {timezone: UTC, holidays: closed.txt}
+  quiet-down:
+    service: fake-record
+    schedule: {timezone: UTC, holidays: closed.txt, out_of_hours: downgrade, priority: -1, bypass: ">=5"}
+  quiet-zero:
+    service: fake-record
+    schedule: {timezone: UTC, holidays: closed.txt, out_of_hours: downgrade, priority: 0}
+  quiet-reroute:
+    service: fake-record
+    schedule: {timezone: UTC, holidays: closed.txt, out_of_hours: reroute, reroute: fake-record}
+`), 0o600))
+	cfg, err := config.Load(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	d := New(cfg)
+	ctx := context.Background()
+	send := func(dest string, priority int) Delivery {
+		return d.Send(ctx, []string{dest}, notifier.Message{Title: dest, Body: "b", Priority: priority})[0]
+	}
+
+	// without Start deferred messages are dropped
+	assert.Equal(t, "drop", send("quiet-defer", 0).OutOfHours)
+	assert.Empty(t, recorded)
+
+	d.Start(ctx)
+	delivery := send("quiet-drop", 0)
+	assert.True(t, delivery.Success)
+	assert.Equal(t, "drop", delivery.OutOfHours)
+
+	delivery = send("quiet-defer", 0)
+	assert.True(t, delivery.Success)
+	assert.Equal(t, "defer", delivery.OutOfHours)
+
+	assert.Equal(t, "", send("quiet-down", 1).OutOfHours)
+	assert.Equal(t, -1, (<-recorded).Priority)
+	send("quiet-down", 5)
+	assert.Equal(t, 5, (<-recorded).Priority, "high priorities bypass the schedule")
+	send("quiet-zero", 1)
+	msg := <-recorded
+	assert.Equal(t, 0, msg.Priority)
+	assert.True(t, msg.PrioritySet, "a downgrade to 0 overrides the priority of the service")
+
+	delivery = send("quiet-reroute", 0)
+	assert.True(t, delivery.Success)
+	assert.Equal(t, "fake-record", delivery.Destination)
+	assert.Equal(t, "reroute", delivery.OutOfHours)
+	assert.Equal(t, "quiet-reroute", (<-recorded).Title)
+
+	// deferred messages are sent once the schedule opens
+	d.deferred.add("fake-record", recordNotifier{}, notifier.Message{Title: "later"}, time.Now().Add(10*time.Millisecond))
+	assert.Equal(t, "later", (<-recorded).Title)
+
+	// deferred messages still waiting are dropped on shutdown
+	assert.NoError(t, d.Flush(ctx))
+	assert.Empty(t, recorded)
+}
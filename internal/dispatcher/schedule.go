@@ -0,0 +1,150 @@
+package dispatcher
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/config"
+	"github.com/kha7iq/pingme/internal/types"
+	"github.com/kha7iq/pingme/service/notifier"
+)
+
+// deferred holds the messages deferred by target schedules until they
+// open
+type deferred struct {
+	d   *Dispatcher
+	ctx context.Context
+	// wg counts the deferred messages being sent
+	wg *sync.WaitGroup
+
+	mu sync.Mutex
+	// timers are the waiting messages by their timer
+	timers map[*time.Timer]waiting
+}
+
+// waiting is a deferred message and its destination
+type waiting struct {
+	dest string
+	msg  notifier.Message
+}
+
+// add sends msg to dest with n at until
+func (q *deferred) add(dest string, n notifier.Notifier, msg notifier.Message, until time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(until), func() {
+		q.mu.Lock()
+		_, waiting := q.timers[timer]
+		delete(q.timers, timer)
+		if waiting {
+			q.wg.Add(1)
+		}
+		q.mu.Unlock()
+		if !waiting {
+			return
+		}
+
+		defer q.wg.Done()
+		if _, err := q.d.deliver(q.ctx, dest, n, msg); err != nil {
+			log.Printf("Failed to send deferred message to %s: %v", dest, err)
+			return
+		}
+		log.Printf("Deferred message sent to %s", dest)
+	})
+	q.timers[timer] = waiting{dest: dest, msg: msg}
+}
+
+// take stops the timers of the messages still waiting and returns them
+func (q *deferred) take() []waiting {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var taken []waiting
+	for timer, w := range q.timers {
+		if timer.Stop() {
+			taken = append(taken, w)
+		}
+		delete(q.timers, timer)
+	}
+	return taken
+}
+
+// stop drops the messages still waiting
+func (q *deferred) stop() {
+	dropped := make(map[string]int)
+	for _, w := range q.take() {
+		dropped[w.dest]++
+	}
+	for dest, count := range dropped {
+		log.Printf("Dropped %d deferred message(s) to %s waiting for its schedule", count, dest)
+	}
+}
+
+// TakeDeferred removes the messages waiting for the schedule of their
+// destination and returns them as requests, e.g. to keep them across a
+// restart instead of having Flush drop them
+func (d *Dispatcher) TakeDeferred() []types.WebhookRequest {
+	if d.deferred == nil {
+		return nil
+	}
+	var reqs []types.WebhookRequest
+	for _, w := range d.deferred.take() {
+		req := types.WebhookRequest{
+			Message:  w.msg.Body,
+			Title:    w.msg.Title,
+			Priority: w.msg.Priority,
+			Extra:    w.msg.Extra,
+		}
+		if _, ok := d.config.Target(w.dest); ok {
+			req.Target = w.dest
+		} else {
+			req.Service = w.dest
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs
+}
+
+// offHours applies the schedule of dest to msg. It returns the
+// delivery if the message was handled, otherwise msg is sent as usual,
+// possibly downgraded
+func (d *Dispatcher) offHours(ctx context.Context, dest string, msg *notifier.Message, n notifier.Notifier) (*Delivery, error) {
+	off := d.config.OffHours(dest, msg.Priority, time.Now())
+	if off == nil {
+		return nil, nil
+	}
+	delivery := &Delivery{Destination: dest, Success: true, OutOfHours: string(off.Action)}
+
+	switch {
+	// the CLI can't wait for the schedule, it drops deferred messages
+	case off.Action == config.Drop, off.Action == config.Defer && (off.Until.IsZero() || d.deferred == nil):
+		log.Printf("Dropped message to %s outside its schedule", dest)
+		delivery.OutOfHours = string(config.Drop)
+		return delivery, nil
+
+	case off.Action == config.Defer:
+		log.Printf("Deferred message to %s until %s", dest, off.Until.Format(time.RFC3339))
+		d.deferred.add(dest, n, *msg, off.Until)
+		return delivery, nil
+
+	case off.Action == config.Downgrade:
+		log.Printf("Downgraded message to %s outside its schedule to priority %d", dest, off.Priority)
+		msg.Priority = off.Priority
+		msg.PrioritySet = true
+		return nil, nil
+
+	default:
+		log.Printf("Rerouted message to %s outside its schedule to %s", dest, off.Reroute)
+		alt, err := d.config.Resolve(off.Reroute)
+		if err != nil {
+			return &Delivery{Destination: off.Reroute, Error: err.Error(), OutOfHours: string(off.Action)}, err
+		}
+		rerouted, err := d.deliver(ctx, off.Reroute, alt, *msg)
+		rerouted.OutOfHours = string(off.Action)
+		return &rerouted, err
+	}
+}
@@ -0,0 +1,426 @@
+// Package escalation sends messages along the steps of an escalation
+// policy until a recipient acknowledges them, with a signed link, the API
+// or a receipt of the service, e.g. Pushover emergency notifications.
+package escalation
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/config"
+	"github.com/kha7iq/pingme/internal/dispatcher"
+	"github.com/kha7iq/pingme/internal/types"
+	"github.com/kha7iq/pingme/service/notifier"
+)
+
+var (
+	// ErrUnknownPolicy is returned by Trigger for a policy missing from
+	// the config file
+	ErrUnknownPolicy = errors.New("unknown escalation policy")
+	// ErrNotFound is returned by Ack for an unknown or expired escalation
+	ErrNotFound = errors.New("escalation not found")
+	// ErrInvalidToken is returned by Verify for a token not signed by the
+	// Manager
+	ErrInvalidToken = errors.New("invalid acknowledgment token")
+)
+
+// Status is the state of an escalation
+type Status string
+
+const (
+	// StatusOpen escalations wait for an acknowledgment
+	StatusOpen Status = "open"
+	// StatusAcknowledged escalations were acknowledged by a recipient
+	StatusAcknowledged Status = "acknowledged"
+	// StatusUnacknowledged escalations went through all steps without
+	// acknowledgment, they can still be acknowledged
+	StatusUnacknowledged Status = "unacknowledged"
+)
+
+const (
+	// retention is how long finished escalations can still be looked up
+	retention = 24 * time.Hour
+	// pruneInterval is how often finished escalations are checked for
+	// expiry
+	pruneInterval = time.Minute
+)
+
+// pollInterval is how often the receipts of open escalations are checked
+var pollInterval = 30 * time.Second
+
+// Incident is a message being escalated
+type Incident struct {
+	ID         string `json:"id"`
+	Escalation string `json:"escalation"`
+	Status     Status `json:"status"`
+	// Step is the number of steps sent so far
+	Step           int                   `json:"step"`
+	AcknowledgedBy string                `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt *time.Time            `json:"acknowledged_at,omitempty"`
+	Results        []dispatcher.Delivery `json:"results,omitempty"`
+	CreatedAt      time.Time             `json:"created_at"`
+	UpdatedAt      time.Time             `json:"updated_at"`
+
+	policy   config.Escalation
+	msg      notifier.Message
+	timer    *time.Timer
+	receipts []receipt
+}
+
+// receipt is a delivery the recipient can acknowledge
+type receipt struct {
+	dest string
+	id   string
+}
+
+// Manager runs the escalations of the webhook server, they are kept in
+// memory
+type Manager struct {
+	dispatcher *dispatcher.Dispatcher
+	config     *config.Config
+	url        string
+	key        []byte
+	now        func() time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	incidents map[string]*Incident
+	closed    bool
+	lastPrune time.Time
+}
+
+// New returns a Manager for the escalation policies of cfg sending with d
+func New(d *dispatcher.Dispatcher, cfg *config.Config) (*Manager, error) {
+	m := &Manager{
+		dispatcher: d,
+		config:     cfg,
+		now:        time.Now,
+		incidents:  make(map[string]*Incident),
+		// replaced by Start
+		ctx: context.Background(),
+	}
+
+	var secret string
+	if cfg.Escalations != nil {
+		m.url = strings.TrimSuffix(cfg.Escalations.URL, "/")
+		secret = cfg.Escalations.Secret
+	}
+	m.key = []byte(secret)
+	if secret == "" {
+		// links are only valid as long as the escalations in memory
+		m.key = make([]byte, 32)
+		if _, err := rand.Read(m.key); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Start sends with ctx from now on and checks the receipts of open
+// escalations until Close is called
+func (m *Manager) Start(ctx context.Context) {
+	m.ctx, m.cancel = context.WithCancel(ctx)
+	m.wg.Add(1)
+	go m.poll()
+}
+
+// Close stops the escalations and waits for the steps being sent, open
+// escalations are dropped
+func (m *Manager) Close() {
+	m.mu.Lock()
+	m.closed = true
+	open := 0
+	for _, inc := range m.incidents {
+		if inc.timer != nil {
+			inc.timer.Stop()
+		}
+		if inc.Status == StatusOpen {
+			open++
+		}
+	}
+	m.mu.Unlock()
+
+	if open > 0 {
+		log.Printf("Dropped %d open escalation(s)", open)
+	}
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.wg.Wait()
+}
+
+// Policy returns the named escalation policy
+func (m *Manager) Policy(name string) (config.Escalation, bool) {
+	return m.config.Escalation(name)
+}
+
+// Trigger starts escalating the message of req along its escalation
+// policy, the first step is sent in the background
+func (m *Manager) Trigger(req *types.WebhookRequest) (Incident, error) {
+	policy, ok := m.config.Escalation(req.Escalation)
+	if !ok {
+		return Incident{}, fmt.Errorf("%w %q", ErrUnknownPolicy, req.Escalation)
+	}
+	msg, err := dispatcher.Message(req)
+	if err != nil {
+		return Incident{}, err
+	}
+	id, err := newID()
+	if err != nil {
+		return Incident{}, err
+	}
+
+	now := m.now()
+	inc := &Incident{
+		ID:         id,
+		Escalation: req.Escalation,
+		Status:     StatusOpen,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		policy:     policy,
+		msg:        msg,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune(now)
+	m.incidents[id] = inc
+	m.wg.Add(1)
+	go m.escalate(id)
+	return *inc, nil
+}
+
+// Get returns the escalation with id
+func (m *Manager) Get(id string) (Incident, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inc, ok := m.incidents[id]
+	if !ok {
+		return Incident{}, false
+	}
+	return *inc, true
+}
+
+// Ack acknowledges the escalation with id, by describes who acknowledged
+// it. Acknowledging it again keeps the first acknowledgment
+func (m *Manager) Ack(id, by string) (Incident, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inc, ok := m.incidents[id]
+	if !ok {
+		return Incident{}, ErrNotFound
+	}
+	if inc.Status != StatusAcknowledged {
+		now := m.now()
+		inc.Status = StatusAcknowledged
+		inc.AcknowledgedBy = by
+		inc.AcknowledgedAt = &now
+		inc.UpdatedAt = now
+		if inc.timer != nil {
+			inc.timer.Stop()
+		}
+		log.Printf("Escalation %s acknowledged by %s", id, by)
+	}
+	return *inc, nil
+}
+
+// Token returns the signed token of the acknowledgment link of id
+func (m *Manager) Token(id string) string {
+	return id + "." + m.sign(id)
+}
+
+// Verify returns the escalation id of a token returned by Token
+func (m *Manager) Verify(token string) (string, error) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return "", ErrInvalidToken
+	}
+	id, sig := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(m.sign(id))) {
+		return "", ErrInvalidToken
+	}
+	return id, nil
+}
+
+func (m *Manager) sign(id string) string {
+	mac := hmac.New(sha256.New, m.key)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// escalate sends the next step of the escalation with id and waits for
+// an acknowledgment before the following one
+func (m *Manager) escalate(id string) {
+	defer m.wg.Done()
+
+	m.mu.Lock()
+	inc, ok := m.incidents[id]
+	if !ok || inc.Status != StatusOpen || m.closed {
+		m.mu.Unlock()
+		return
+	}
+	step := inc.Step
+	inc.Step++
+	targets := inc.policy.Steps[step].Targets
+	msg := m.message(inc, step)
+	m.mu.Unlock()
+
+	log.Printf("Escalation %s: sending step %d to %s", id, step+1, strings.Join(targets, ","))
+	results := m.dispatcher.SendNow(m.ctx, targets, msg)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inc.Results = append(inc.Results, results...)
+	inc.UpdatedAt = m.now()
+	for _, res := range results {
+		if !res.Success {
+			log.Printf("Escalation %s: failed to send to %s: %s", id, res.Destination, res.Error)
+		}
+		for _, r := range res.Receipts {
+			inc.receipts = append(inc.receipts, receipt{dest: res.Destination, id: r})
+		}
+	}
+	if inc.Status != StatusOpen || m.closed {
+		return
+	}
+
+	after := inc.policy.Steps[step].After
+	last := step == len(inc.policy.Steps)-1
+	if last && after == 0 {
+		inc.Status = StatusUnacknowledged
+		return
+	}
+	inc.timer = time.AfterFunc(after, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if inc.Status != StatusOpen || m.closed {
+			return
+		}
+		if last {
+			log.Printf("Escalation %s was not acknowledged", id)
+			inc.Status = StatusUnacknowledged
+			inc.UpdatedAt = m.now()
+			return
+		}
+		m.wg.Add(1)
+		go m.escalate(id)
+	})
+}
+
+// message is the message of a step with the acknowledgment link, m.mu
+// must be held
+func (m *Manager) message(inc *Incident, step int) notifier.Message {
+	msg := inc.msg
+	extra := make(map[string]interface{}, len(msg.Extra)+3)
+	for k, v := range msg.Extra {
+		extra[k] = v
+	}
+	extra["escalation_id"] = inc.ID
+	extra["escalation_step"] = step + 1
+	if m.url != "" {
+		link := m.url + "/ack/" + m.Token(inc.ID)
+		extra["ack_url"] = link
+		msg.Body += "\n\nAcknowledge: " + link
+	}
+	msg.Extra = extra
+	return msg
+}
+
+// poll checks the receipts of open escalations until the Manager is
+// closed
+func (m *Manager) poll() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			m.checkReceipts()
+		}
+	}
+}
+
+// checkReceipts acknowledges the open escalations a receipt of which was
+// acknowledged
+func (m *Manager) checkReceipts() {
+	pending := make(map[string][]receipt)
+	m.mu.Lock()
+	for id, inc := range m.incidents {
+		if inc.Status == StatusOpen && len(inc.receipts) > 0 {
+			pending[id] = append([]receipt(nil), inc.receipts...)
+		}
+	}
+	m.mu.Unlock()
+
+	for id, receipts := range pending {
+		for _, r := range receipts {
+			by, ok := m.acknowledged(r)
+			if ok {
+				_, _ = m.Ack(id, by)
+				break
+			}
+		}
+	}
+}
+
+// acknowledged reports whether the recipient of r acknowledged it and who
+func (m *Manager) acknowledged(r receipt) (string, bool) {
+	n, err := m.config.Resolve(r.dest)
+	if err != nil {
+		return "", false
+	}
+	ack, ok := n.(notifier.Acknowledger)
+	if !ok {
+		return "", false
+	}
+	by, acked, err := ack.Acknowledged(m.ctx, r.id)
+	if err != nil {
+		log.Printf("Failed to check receipt of %s: %v", r.dest, err)
+		return "", false
+	}
+	if !acked {
+		return "", false
+	}
+	if by == "" {
+		return r.dest, true
+	}
+	return by + " via " + r.dest, true
+}
+
+// prune drops finished escalations at most once a minute, m.mu must be
+// held
+func (m *Manager) prune(now time.Time) {
+	if now.Sub(m.lastPrune) < pruneInterval {
+		return
+	}
+	m.lastPrune = now
+	for id, inc := range m.incidents {
+		if inc.Status != StatusOpen && now.Sub(inc.UpdatedAt) > retention {
+			delete(m.incidents, id)
+		}
+	}
+}
+
+// newID returns a random escalation id
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
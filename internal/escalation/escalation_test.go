@@ -0,0 +1,225 @@
+package escalation
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/config"
+	"github.com/kha7iq/pingme/internal/dispatcher"
+	"github.com/kha7iq/pingme/internal/types"
+	"github.com/kha7iq/pingme/service/notifier"
+	"github.com/stretchr/testify/assert"
+)
+
+// sent receives the messages of the fake services with their destination
+var sent = make(chan sentMessage, 10)
+
+type sentMessage struct {
+	dest string
+	msg  notifier.Message
+}
+
+// acked are the receipts acknowledged by "bob"
+var acked sync.Map
+
+// fakeNotifier returns a receipt for messages with priority 2
+type fakeNotifier struct {
+	name string
+}
+
+func (f fakeNotifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	sent <- sentMessage{dest: f.name, msg: msg}
+	result := notifier.Result{Service: f.name}
+	if msg.Priority == 2 {
+		result.Receipts = []string{"receipt-" + f.name}
+	}
+	return result, nil
+}
+
+func (f fakeNotifier) Acknowledged(ctx context.Context, receipt string) (string, bool, error) {
+	_, ok := acked.Load(receipt)
+	return "bob", ok, nil
+}
+
+func init() {
+	for _, name := range []string{"fake-a", "fake-b", "fake-c"} {
+		name := name
+		notifier.Register(notifier.Service{
+			Name: name,
+			New: func(src notifier.Source) (notifier.Notifier, error) {
+				return fakeNotifier{name: name}, nil
+			},
+		})
+	}
+}
+
+func newManager(t *testing.T) *Manager {
+	t.Helper()
+	dir := t.TempDir()
+	now := time.Now().UTC()
+	var closed string
+	for _, day := range []int{-1, 0, 1} {
+		closed += now.AddDate(0, 0, day).Format("2006-01-02") + "\n"
+	}
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "closed.txt"), []byte(closed), 0o600))
+	path := filepath.Join(dir, "pingme.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(`
+targets:
+  pager:
+    service: fake-a
+    dedup: 1h
+    digest: {window: 1h}
+    schedule: {timezone: UTC, holidays: closed.txt, out_of_hours: drop}
+escalations:
+  url: https://pingme.example.com/
+  policies:
+    fast:
+      steps:
+        - targets: [fake-a]
+          after: 20ms
+        - targets: [fake-b]
+          after: 20ms
+        - targets: [fake-c]
+    slow:
+      steps:
+        - targets: [fake-a]
+          after: 1h
+        - targets: [fake-b]
+    paging:
+      steps:
+        - targets: [pager]
+          after: 20ms
+        - targets: [pager]
+`), 0o600))
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(dispatcher.New(cfg), cfg)
+	assert.Nil(t, err)
+	return m
+}
+
+// waitFor polls the escalation until it has status
+func waitFor(t *testing.T, m *Manager, id string, status Status) Incident {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if inc, _ := m.Get(id); inc.Status == status {
+			return inc
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("escalation %s not %s", id, status)
+	return Incident{}
+}
+
+func TestTrigger_Steps(t *testing.T) {
+	m := newManager(t)
+	m.Start(context.Background())
+	defer m.Close()
+
+	inc, err := m.Trigger(&types.WebhookRequest{Escalation: "fast", Title: "Disk full", Message: "db-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, StatusOpen, inc.Status)
+
+	for _, dest := range []string{"fake-a", "fake-b", "fake-c"} {
+		s := <-sent
+		assert.Equal(t, dest, s.dest)
+		assert.Equal(t, "Disk full", s.msg.Title)
+	}
+	inc = waitFor(t, m, inc.ID, StatusUnacknowledged)
+	assert.Equal(t, 3, inc.Step)
+	assert.Len(t, inc.Results, 3)
+
+	_, err = m.Trigger(&types.WebhookRequest{Escalation: "missing", Message: "hi"})
+	assert.ErrorIs(t, err, ErrUnknownPolicy)
+}
+
+func TestTrigger_SendNow(t *testing.T) {
+	m := newManager(t)
+	m.dispatcher.Start(context.Background())
+	m.Start(context.Background())
+	defer m.Close()
+
+	// escalations ignore the schedule, dedup window and digest of targets
+	inc, err := m.Trigger(&types.WebhookRequest{Escalation: "paging", Title: "Disk full", Message: "db-1"})
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		s := <-sent
+		assert.Equal(t, "fake-a", s.dest)
+		assert.Equal(t, "Disk full", s.msg.Title)
+	}
+	inc = waitFor(t, m, inc.ID, StatusUnacknowledged)
+	for _, res := range inc.Results {
+		assert.True(t, res.Success)
+		assert.False(t, res.Batched)
+		assert.False(t, res.Duplicate)
+		assert.Empty(t, res.OutOfHours)
+	}
+}
+
+func TestAck(t *testing.T) {
+	m := newManager(t)
+	m.Start(context.Background())
+
+	inc, err := m.Trigger(&types.WebhookRequest{Escalation: "slow", Message: "db-1"})
+	assert.Nil(t, err)
+	s := <-sent
+	assert.Equal(t, "fake-a", s.dest)
+
+	// the link is signed and included in the message
+	link := "https://pingme.example.com/ack/" + m.Token(inc.ID)
+	assert.Equal(t, "db-1\n\nAcknowledge: "+link, s.msg.Body)
+	assert.Equal(t, link, s.msg.Extra["ack_url"])
+	assert.Equal(t, 1, s.msg.Extra["escalation_step"])
+
+	inc, err = m.Ack(inc.ID, "alice")
+	assert.Nil(t, err)
+	assert.Equal(t, StatusAcknowledged, inc.Status)
+	assert.NotNil(t, inc.AcknowledgedAt)
+	inc, _ = m.Ack(inc.ID, "carol")
+	assert.Equal(t, "alice", inc.AcknowledgedBy, "the first acknowledgment is kept")
+
+	_, err = m.Ack("unknown", "alice")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	m.Close()
+	assert.Empty(t, sent, "acknowledged escalations stop")
+}
+
+func TestVerify(t *testing.T) {
+	m := newManager(t)
+	token := m.Token("abc")
+	id, err := m.Verify(token)
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", id)
+
+	for _, token := range []string{"abc", "abd." + strings.SplitN(token, ".", 2)[1], token + "x", ""} {
+		_, err = m.Verify(token)
+		assert.ErrorIs(t, err, ErrInvalidToken, token)
+	}
+	_, err = newManager(t).Verify(token)
+	assert.ErrorIs(t, err, ErrInvalidToken, "random secrets differ")
+}
+
+func TestReceipts(t *testing.T) {
+	defer func(interval time.Duration) { pollInterval = interval }(pollInterval)
+	pollInterval = 10 * time.Millisecond
+
+	m := newManager(t)
+	m.Start(context.Background())
+	defer m.Close()
+
+	inc, err := m.Trigger(&types.WebhookRequest{Escalation: "slow", Message: "db-1", Priority: 2})
+	assert.Nil(t, err)
+	<-sent
+
+	acked.Store("receipt-fake-a", true)
+	inc = waitFor(t, m, inc.ID, StatusAcknowledged)
+	assert.Equal(t, "bob via fake-a", inc.AcknowledgedBy)
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/kha7iq/pingme/internal/escalation"
+	"github.com/kha7iq/pingme/internal/middleware"
+	"github.com/kha7iq/pingme/internal/queue"
+)
+
+// MessagesHandler reports the delivery status of queued messages and the
+// state of escalations
+type MessagesHandler struct {
+	queue       *queue.Queue
+	escalations *escalation.Manager
+}
+
+// NewMessagesHandler creates a new handler for GET /messages/{id}, q or
+// esc may be nil
+func NewMessagesHandler(q *queue.Queue, esc *escalation.Manager) *MessagesHandler {
+	return &MessagesHandler{queue: q, escalations: esc}
+}
+
+// ServeHTTP implements http.Handler interface
+func (h *MessagesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+	if h.queue != nil {
+		if msg, ok := h.queue.Get(id); ok {
+			writeJSON(w, msg)
+			return
+		}
+	}
+	if h.escalations != nil {
+		if inc, ok := h.escalations.Get(id); ok {
+			writeJSON(w, inc)
+			return
+		}
+	}
+	http.Error(w, "Message not found", http.StatusNotFound)
+}
+
+// AckHandler acknowledges escalations with POST /messages/{id}/ack
+type AckHandler struct {
+	escalations *escalation.Manager
+}
+
+// NewAckHandler creates a new handler for POST /messages/{id}/ack
+func NewAckHandler(esc *escalation.Manager) *AckHandler {
+	return &AckHandler{escalations: esc}
+}
+
+// ServeHTTP implements http.Handler interface
+func (h *AckHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	by := "API"
+	if caller := middleware.CallerFrom(r.Context()); caller != nil {
+		by = caller.Name
+	}
+	inc, err := h.escalations.Ack(r.PathValue("id"), by)
+	if errors.Is(err, escalation.ErrNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, inc)
+}
+
+// ackPage asks to confirm the acknowledgment, link previews of chat apps
+// fetch the link and must not acknowledge it
+var ackPage = template.Must(template.New("ack").Parse(`<!DOCTYPE html>
+<html>
+<head><meta name="viewport" content="width=device-width, initial-scale=1"><title>PingMe</title></head>
+<body style="font-family: sans-serif; text-align: center; margin-top: 3em">
+{{if .Done}}<p>Acknowledged by {{.Incident.AcknowledgedBy}}.</p>
+{{else}}<p>Escalation <b>{{.Incident.Escalation}}</b> is {{.Incident.Status}}.</p>
+<form method="post"><button type="submit" style="font-size: 1.5em; padding: 0.5em 1em">Acknowledge</button></form>
+{{end}}</body>
+</html>
+`))
+
+// AckLinkHandler acknowledges escalations with the signed links included
+// in their messages. GET shows a confirmation page, POST acknowledges
+type AckLinkHandler struct {
+	escalations *escalation.Manager
+}
+
+// NewAckLinkHandler creates a new handler for /ack/{token}, the token
+// authenticates the request
+func NewAckLinkHandler(esc *escalation.Manager) *AckLinkHandler {
+	return &AckLinkHandler{escalations: esc}
+}
+
+// ServeHTTP implements http.Handler interface
+func (h *AckLinkHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := h.escalations.Verify(r.PathValue("token"))
+	if err != nil {
+		log.Printf("Invalid acknowledgment link from %s", r.RemoteAddr)
+		http.Error(w, "Invalid link", http.StatusNotFound)
+		return
+	}
+	inc, ok := h.escalations.Get(id)
+	if r.Method == http.MethodPost {
+		inc, err = h.escalations.Ack(id, "link")
+		ok = err == nil
+	}
+	if !ok {
+		http.Error(w, "Escalation expired", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	data := struct {
+		Incident escalation.Incident
+		Done     bool
+	}{inc, inc.Status == escalation.StatusAcknowledged}
+	if err := ackPage.Execute(w, data); err != nil {
+		log.Printf("Failed to render acknowledgment page: %v", err)
+	}
+}
+
+// writeJSON writes v as JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kha7iq/pingme/internal/dispatcher"
+	"github.com/kha7iq/pingme/internal/escalation"
+	"github.com/kha7iq/pingme/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAckHandler(t *testing.T) {
+	cfg := newConfig(t, `
+escalations:
+  policies:
+    oncall:
+      steps:
+        - targets: [fake]
+          after: 1h
+        - targets: [fake]
+`)
+	esc, err := escalation.New(dispatcher.New(cfg), cfg)
+	assert.Nil(t, err)
+	esc.Start(context.Background())
+	defer esc.Close()
+
+	inc, err := esc.Trigger(&types.WebhookRequest{Escalation: "oncall", Message: "hi"})
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name   string
+		method string
+		id     string
+		status int
+		body   string
+	}{
+		{"method", http.MethodGet, inc.ID, http.StatusMethodNotAllowed, "Method not allowed"},
+		{"acknowledged", http.MethodPost, inc.ID, http.StatusOK, `"acknowledged_by":"API"`},
+		{"not found", http.MethodPost, "gone", http.StatusNotFound, "Message not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/messages/"+tt.id+"/ack", nil)
+			req.SetPathValue("id", tt.id)
+			rec := httptest.NewRecorder()
+			NewAckHandler(esc).ServeHTTP(rec, req)
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.body)
+		})
+	}
+}
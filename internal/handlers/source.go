@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kha7iq/pingme/internal/dispatcher"
+	"github.com/kha7iq/pingme/internal/sources"
+	"github.com/kha7iq/pingme/internal/types"
+)
+
+// maxSourceBody limits the size of payloads accepted from webhook sources
+const maxSourceBody = 1 << 20
+
+// SourceHandler accepts webhooks in the native format of another tool,
+// e.g. Alertmanager, and delivers them like regular webhook requests
+type SourceHandler struct {
+	name    string
+	source  sources.Source
+	targets []string
+	webhook *WebhookHandler
+	// keep the destination chosen by the source instead of routing
+	keep bool
+}
+
+// NewSourceHandler creates a handler converting payloads with source. The
+// destination is, in this order, chosen by the query, targets, the routes
+// of the config file or, if nothing else applies, by the source itself.
+func NewSourceHandler(name string, source sources.Source, targets []string, webhook *WebhookHandler) *SourceHandler {
+	return &SourceHandler{
+		name:    name,
+		source:  source,
+		targets: targets,
+		webhook: webhook,
+	}
+}
+
+// NewHookHandler creates a handler converting payloads with the mapping
+// source of a hook. A destination chosen by the mapping is only replaced
+// by the query, routes apply to messages without one.
+func NewHookHandler(name string, source sources.Source, webhook *WebhookHandler) *SourceHandler {
+	return &SourceHandler{
+		name:    name,
+		source:  source,
+		webhook: webhook,
+		keep:    true,
+	}
+}
+
+// ServeHTTP implements http.Handler interface
+func (h *SourceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.webhook.sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSourceBody))
+	if err != nil {
+		h.webhook.sendError(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	reqs, err := h.source(r, body)
+	if errors.Is(err, sources.ErrUnauthorized) {
+		log.Printf("Authentication failed for %s from %s: %v", r.URL.Path, r.RemoteAddr, err)
+		h.webhook.sendError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		h.webhook.sendError(w, fmt.Sprintf("Invalid %s payload: %v", h.name, err), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	for i := range reqs {
+		reqs[i].Source = r.URL.Path
+		h.route(query, &reqs[i])
+		if failOn := query.Get("fail_on"); failOn != "" {
+			reqs[i].FailOn = failOn
+		}
+	}
+
+	h.webhook.submit(w, r, reqs)
+}
+
+// route chooses the destination of req, see NewSourceHandler
+func (h *SourceHandler) route(query url.Values, req *types.WebhookRequest) {
+	ChooseDestination(query, h.targets, h.keep, h.webhook.dispatcher.Routes(req), req)
+}
+
+// Reasons returned by ChooseDestination
+const (
+	ByQuery   = "query"
+	ByTargets = "integration targets"
+	BySource  = "source"
+	ByRoutes  = "routes"
+)
+
+// ChooseDestination sets the destination of req posted to an integration
+// or hook from the target, service or services query parameter, the
+// configured targets, the destination chosen by the source if keep is set,
+// the targets of the matching routes and last the source default. It
+// returns which of them applied, or an empty string if req has none
+func ChooseDestination(query url.Values, targets []string, keep bool, routed []string, req *types.WebhookRequest) string {
+	target, service, services := query.Get("target"), query.Get("service"), query.Get("services")
+	switch {
+	case target != "" || service != "" || services != "":
+		req.Target, req.Service, req.Services = target, service, nil
+		for _, v := range strings.Split(services, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				req.Services = append(req.Services, v)
+			}
+		}
+		return ByQuery
+	case len(targets) > 0:
+		dispatcher.SetDestination(req, targets)
+		return ByTargets
+	case keep && dispatcher.HasDestination(req):
+		return BySource
+	case len(routed) > 0:
+		dispatcher.SetDestination(req, routed)
+		return ByRoutes
+	case dispatcher.HasDestination(req):
+		return BySource
+	default:
+		return ""
+	}
+}
@@ -1,33 +1,65 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/kha7iq/pingme/internal/config"
 	"github.com/kha7iq/pingme/internal/dispatcher"
+	"github.com/kha7iq/pingme/internal/escalation"
+	"github.com/kha7iq/pingme/internal/idempotency"
+	"github.com/kha7iq/pingme/internal/middleware"
+	"github.com/kha7iq/pingme/internal/queue"
+	"github.com/kha7iq/pingme/internal/render"
 	"github.com/kha7iq/pingme/internal/types"
 )
 
+// dispatchTimeout bounds the time spent delivering a message so the
+// response can still be written before the server's WriteTimeout
+const dispatchTimeout = 12 * time.Second
+
 // WebhookHandler handles incoming webhook requests
 type WebhookHandler struct {
-	dispatcher *dispatcher.Dispatcher
+	dispatcher  *dispatcher.Dispatcher
+	queue       *queue.Queue
+	idempotency *idempotency.Store
+	escalations *escalation.Manager
 }
 
-// NewWebhookHandler creates a new webhook handler
-func NewWebhookHandler() *WebhookHandler {
+// NewWebhookHandler creates a new webhook handler. With a non-nil queue
+// requests are delivered asynchronously and answered with 202 Accepted.
+// With a non-nil store repeated requests with an idempotency key get the
+// first response. Requests naming an escalation policy are escalated by
+// esc, they are rejected if it is nil.
+func NewWebhookHandler(d *dispatcher.Dispatcher, q *queue.Queue, idem *idempotency.Store, esc *escalation.Manager) *WebhookHandler {
 	return &WebhookHandler{
-		dispatcher: dispatcher.New(),
+		dispatcher:  d,
+		queue:       q,
+		idempotency: idem,
+		escalations: esc,
 	}
 }
 
 // WebhookResponse represents the response sent back
 type WebhookResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-	Error   string `json:"error,omitempty"`
+	Success  bool                  `json:"success"`
+	ID       string                `json:"id,omitempty"`
+	IDs      []string              `json:"ids,omitempty"`
+	Message  string                `json:"message"`
+	Error    string                `json:"error,omitempty"`
+	Attempts int                   `json:"attempts,omitempty"`
+	Results  []dispatcher.Delivery `json:"results,omitempty"`
 }
 
 // ServeHTTP implements http.Handler interface
@@ -53,44 +85,357 @@ func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate request
-	if err := h.validateRequest(&req); err != nil {
+	req.Source = r.URL.Path
+
+	h.submit(w, r, []types.WebhookRequest{req})
+}
+
+// submit validates and delivers the requests, queued in async mode.
+// Sources of other formats convert their payload to one or more requests
+// and submit them here.
+func (h *WebhookHandler) submit(w http.ResponseWriter, r *http.Request, reqs []types.WebhookRequest) {
+	if len(reqs) == 0 {
+		h.sendJSON(w, WebhookResponse{Success: true, Message: "Nothing to send"}, http.StatusOK)
+		return
+	}
+
+	// Repeated requests get the first successful response
+	if key := idempotencyKey(r, reqs); key != "" && h.idempotency != nil {
+		resp, err := h.idempotency.Begin(key, fingerprint(r, reqs))
+		switch {
+		case errors.Is(err, idempotency.ErrInProgress):
+			h.sendError(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, idempotency.ErrMismatch):
+			h.sendError(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case resp != nil:
+			log.Printf("Replaying response of repeated request to %s", r.URL.Path)
+			idempotency.Replay(w, resp)
+			return
+		}
+		rec := idempotency.NewRecorder(w)
+		defer func() { h.idempotency.Finish(key, rec.Response()) }()
+		w = rec
+	}
+
+	// Route requests without destination and validate them
+	for i := range reqs {
+		if reqs[i].Escalation == "" {
+			h.dispatcher.Route(&reqs[i])
+		}
+		if err := h.validateRequest(&reqs[i]); err != nil {
+			h.sendError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if caller := middleware.CallerFrom(r.Context()); caller != nil {
+			if dest := deniedDestination(caller.Scope, h.destinations(&reqs[i])); dest != "" {
+				log.Printf("%s denied sending to %s", caller.Name, dest)
+				h.sendError(w, fmt.Sprintf("Not allowed to send to %s", dest), http.StatusForbidden)
+				return
+			}
+		}
+		// Log incoming request
+		log.Printf("Webhook received: destination=%s, message_length=%d", destination(&reqs[i]), len(reqs[i].Message))
+	}
+
+	// Escalations run in the background in sync and async mode
+	if len(reqs) == 1 && reqs[0].Escalation != "" {
+		h.escalate(w, &reqs[0])
+		return
+	}
+
+	if h.queue != nil {
+		h.enqueue(w, reqs)
+		return
+	}
+
+	// Dispatch message to appropriate service, the context is cancelled
+	// if the client disconnects or the server shuts down. Deliveries over
+	// a rate limit fail instead of waiting, the caller gets 429
+	ctx, cancel := context.WithTimeout(dispatcher.FailFast(r.Context()), dispatchTimeout)
+	defer cancel()
+
+	if len(reqs) > 1 {
+		h.deliverAll(ctx, w, reqs)
+		return
+	}
+
+	req := &reqs[0]
+	if len(req.Services) > 0 {
+		h.fanOut(ctx, w, req)
+		return
+	}
+
+	delivery, err := h.dispatcher.Dispatch(ctx, req)
+	if err != nil {
+		log.Printf("Failed to dispatch message to %s after %d attempt(s): %v", destination(req), delivery.Attempts, err)
+		h.sendJSON(w, WebhookResponse{
+			Success:  false,
+			Error:    fmt.Sprintf("Failed to send message: %v", err),
+			Attempts: delivery.Attempts,
+		}, failureStatus(w, delivery))
+		return
+	}
+
+	if delivery.Duplicate {
+		h.sendJSON(w, WebhookResponse{
+			Success: true,
+			Message: fmt.Sprintf("Duplicate message to %s suppressed", destination(req)),
+		}, http.StatusOK)
+		return
+	}
+
+	switch delivery.OutOfHours {
+	case string(config.Drop):
+		h.sendJSON(w, WebhookResponse{
+			Success: true,
+			Message: fmt.Sprintf("Message to %s dropped outside its schedule", destination(req)),
+		}, http.StatusOK)
+		return
+	case string(config.Defer):
+		h.sendJSON(w, WebhookResponse{
+			Success: true,
+			Message: fmt.Sprintf("Message to %s deferred until its schedule opens", destination(req)),
+		}, http.StatusAccepted)
+		return
+	}
+
+	if delivery.Batched {
+		h.sendJSON(w, WebhookResponse{
+			Success: true,
+			Message: fmt.Sprintf("Message added to digest of %s", destination(req)),
+		}, http.StatusAccepted)
+		return
+	}
+
+	log.Printf("Message sent to %s after %d attempt(s)", destination(req), delivery.Attempts)
+	h.sendJSON(w, WebhookResponse{
+		Success:  true,
+		Message:  fmt.Sprintf("Message sent successfully via %s", destination(req)),
+		Attempts: delivery.Attempts,
+	}, http.StatusOK)
+}
+
+// deliverAll delivers several requests one after another and reports the
+// result of every delivery
+func (h *WebhookHandler) deliverAll(ctx context.Context, w http.ResponseWriter, reqs []types.WebhookRequest) {
+	var (
+		results []dispatcher.Delivery
+		failed  int
+	)
+	for i := range reqs {
+		deliveries, err := h.dispatcher.Deliver(ctx, &reqs[i])
+		results = append(results, deliveries...)
+		if err != nil {
+			failed++
+			log.Printf("Failed to dispatch message to %s: %v", destination(&reqs[i]), err)
+		}
+	}
+
+	resp := WebhookResponse{
+		Success: failed == 0,
+		Results: results,
+	}
+	if failed > 0 {
+		resp.Error = fmt.Sprintf("Failed to send %d of %d messages", failed, len(reqs))
+		h.sendJSON(w, resp, failureStatus(w, results...))
+		return
+	}
+	resp.Message = fmt.Sprintf("%d messages sent", len(reqs))
+	h.sendJSON(w, resp, http.StatusOK)
+}
+
+// fanOut dispatches the request to every entry of req.Services concurrently
+// and reports the result of each delivery
+func (h *WebhookHandler) fanOut(ctx context.Context, w http.ResponseWriter, req *types.WebhookRequest) {
+	policy, _ := dispatcher.ParseFailurePolicy(req.FailOn)
+	msg, err := dispatcher.Message(req)
+	if err != nil {
 		h.sendError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	results := h.dispatcher.Send(ctx, req.Services, msg)
 
-	// Log incoming request
-	log.Printf("Webhook received: service=%s, message_length=%d", req.Service, len(req.Message))
+	for _, res := range results {
+		if !res.Success {
+			log.Printf("Failed to dispatch message to %s after %d attempt(s): %s", res.Destination, res.Attempts, res.Error)
+		}
+	}
 
-	// Dispatch message to appropriate service
-	if err := h.dispatcher.Dispatch(r.Context(), &req); err != nil {
-		log.Printf("Failed to dispatch message to %s: %v", req.Service, err)
-		h.sendError(w, fmt.Sprintf("Failed to send message: %v", err), http.StatusInternalServerError)
+	resp := WebhookResponse{
+		Success: !policy.Failed(results),
+		Results: results,
+	}
+	if !resp.Success {
+		resp.Error = fmt.Sprintf("Failed to send message (fail_on=%s)", policy)
+		h.sendJSON(w, resp, failureStatus(w, results...))
 		return
 	}
+	resp.Message = fmt.Sprintf("Message dispatched to %d destinations", len(results))
+	h.sendJSON(w, resp, http.StatusOK)
+}
+
+// enqueue queues the requests for asynchronous delivery
+func (h *WebhookHandler) enqueue(w http.ResponseWriter, reqs []types.WebhookRequest) {
+	ids := make([]string, 0, len(reqs))
+	for i := range reqs {
+		msg, err := h.queue.Enqueue(reqs[i])
+		if err != nil {
+			log.Printf("Failed to queue message to %s: %v", destination(&reqs[i]), err)
+			h.sendJSON(w, WebhookResponse{
+				Success: false,
+				IDs:     ids,
+				Error:   fmt.Sprintf("Failed to queue message: %v", err),
+			}, http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("Message %s to %s queued", msg.ID, destination(&reqs[i]))
+		ids = append(ids, msg.ID)
+	}
 
-	// Send success response
-	h.sendSuccess(w, fmt.Sprintf("Message sent successfully via %s", req.Service))
+	if len(ids) > 1 {
+		h.sendJSON(w, WebhookResponse{
+			Success: true,
+			IDs:     ids,
+			Message: fmt.Sprintf("%d messages queued for delivery", len(ids)),
+		}, http.StatusAccepted)
+		return
+	}
+
+	w.Header().Set("Location", "/messages/"+ids[0])
+	h.sendJSON(w, WebhookResponse{
+		Success: true,
+		ID:      ids[0],
+		Message: fmt.Sprintf("Message queued for delivery via %s", destination(&reqs[0])),
+	}, http.StatusAccepted)
+}
+
+// escalate starts the escalation of req
+func (h *WebhookHandler) escalate(w http.ResponseWriter, req *types.WebhookRequest) {
+	inc, err := h.escalations.Trigger(req)
+	if err != nil {
+		log.Printf("Failed to start %s: %v", destination(req), err)
+		h.sendError(w, fmt.Sprintf("Failed to start escalation: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Escalation %s of policy %s started", inc.ID, inc.Escalation)
+
+	w.Header().Set("Location", "/messages/"+inc.ID)
+	h.sendJSON(w, WebhookResponse{
+		Success: true,
+		ID:      inc.ID,
+		Message: fmt.Sprintf("Escalation %s started", inc.Escalation),
+	}, http.StatusAccepted)
 }
 
 // validateRequest validates the webhook request
 func (h *WebhookHandler) validateRequest(req *types.WebhookRequest) error {
-	if req.Service == "" {
-		return fmt.Errorf("service field is required")
+	if req.Escalation != "" {
+		if dispatcher.HasDestination(req) {
+			return fmt.Errorf("escalation can't be combined with service, target or services")
+		}
+		if h.escalations == nil {
+			return fmt.Errorf("no escalation policies configured")
+		}
+		if _, ok := h.escalations.Policy(req.Escalation); !ok {
+			return fmt.Errorf("%w %q", escalation.ErrUnknownPolicy, req.Escalation)
+		}
+	} else if !dispatcher.HasDestination(req) {
+		return dispatcher.ErrNoRoute
+	}
+	if _, err := dispatcher.ParseFailurePolicy(req.FailOn); err != nil {
+		return err
+	}
+	if req.Message == "" && req.Template == "" {
+		return fmt.Errorf("message or template field is required")
 	}
-	if req.Message == "" {
-		return fmt.Errorf("message field is required")
+	if req.Template != "" {
+		if _, err := render.Parse("request", req.Template); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-// sendSuccess sends a successful JSON response
-func (h *WebhookHandler) sendSuccess(w http.ResponseWriter, message string) {
-	resp := WebhookResponse{
-		Success: true,
-		Message: message,
+// destinations returns every target or service req may be sent to,
+// escalations may be sent to the targets of all their steps
+func (h *WebhookHandler) destinations(req *types.WebhookRequest) []string {
+	if req.Escalation != "" && h.escalations != nil {
+		policy, _ := h.escalations.Policy(req.Escalation)
+		return policy.Targets()
 	}
-	h.sendJSON(w, resp, http.StatusOK)
+	if len(req.Services) > 0 {
+		return req.Services
+	}
+	return []string{destination(req)}
+}
+
+// deniedDestination returns the first of dests scope does not allow, or
+// an empty string if all are allowed
+func deniedDestination(scope config.Scope, dests []string) string {
+	for _, dest := range dests {
+		if !scope.AllowsDestination(dest) {
+			return dest
+		}
+	}
+	return ""
+}
+
+// idempotencyKey returns the key of the Idempotency-Key header or the id
+// of a single request, scoped to the caller so clients can't see each
+// other's responses. Requests without key return an empty string
+func idempotencyKey(r *http.Request, reqs []types.WebhookRequest) string {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" && len(reqs) == 1 {
+		key = reqs[0].ID
+	}
+	if key == "" {
+		return ""
+	}
+	caller := ""
+	if c := middleware.CallerFrom(r.Context()); c != nil {
+		caller = c.Name
+	}
+	return caller + "\x00" + r.URL.Path + "\x00" + key
+}
+
+// fingerprint identifies the content of a request to detect a key reused
+// for a different request
+func fingerprint(r *http.Request, reqs []types.WebhookRequest) string {
+	data, _ := json.Marshal(reqs)
+	sum := sha256.Sum256(append([]byte(r.URL.Path+"\x00"), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+// failureStatus returns the status of a failed request, 429 with a
+// Retry-After header if deliveries failed on a rate limit
+func failureStatus(w http.ResponseWriter, deliveries ...dispatcher.Delivery) int {
+	var after time.Duration
+	for _, d := range deliveries {
+		if d.RetryAfter > after {
+			after = d.RetryAfter
+		}
+	}
+	if after == 0 {
+		return http.StatusInternalServerError
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(after.Seconds()))))
+	return http.StatusTooManyRequests
+}
+
+// destination describes where the request is delivered to for logs and responses
+func destination(req *types.WebhookRequest) string {
+	if req.Escalation != "" {
+		return "escalation " + req.Escalation
+	}
+	if len(req.Services) > 0 {
+		return strings.Join(req.Services, ",")
+	}
+	if req.Target != "" {
+		return req.Target
+	}
+	return req.Service
 }
 
 // sendError sends an error JSON response
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/config"
+	"github.com/kha7iq/pingme/internal/dispatcher"
+	"github.com/kha7iq/pingme/internal/idempotency"
+	"github.com/kha7iq/pingme/internal/middleware"
+	"github.com/kha7iq/pingme/internal/queue"
+	"github.com/kha7iq/pingme/service/notifier"
+	"github.com/stretchr/testify/assert"
+)
+
+// sends counts the messages sent by the fake service
+var sends atomic.Int32
+
+// release unblocks the messages of the blocking service, started receives
+// a value when one is being sent
+var (
+	release = make(chan struct{})
+	started = make(chan struct{}, 10)
+)
+
+type fakeNotifier struct {
+	block bool
+}
+
+func (f fakeNotifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	if f.block {
+		started <- struct{}{}
+		<-release
+	}
+	sends.Add(1)
+	return notifier.Result{Service: "fake"}, nil
+}
+
+func init() {
+	for name, block := range map[string]bool{"fake": false, "blocking": true} {
+		block := block
+		notifier.Register(notifier.Service{
+			Name: name,
+			New: func(src notifier.Source) (notifier.Notifier, error) {
+				return fakeNotifier{block: block}, nil
+			},
+		})
+	}
+}
+
+// newConfig loads a config file with content
+func newConfig(t *testing.T, content string) *config.Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pingme.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// post sends body to handler as POST /webhook with the headers given as
+// name, value pairs
+func post(handler http.Handler, body string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// decode returns the webhook response of w
+func decode(t *testing.T, w *httptest.ResponseRecorder) WebhookResponse {
+	t.Helper()
+	var resp WebhookResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp), w.Body.String())
+	return resp
+}
+
+func TestWebhook(t *testing.T) {
+	handler := NewWebhookHandler(dispatcher.New(nil), nil, nil, nil)
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		want   string
+	}{
+		{"sent", http.MethodPost, `{"service": "fake", "message": "hi"}`, http.StatusOK, "Message sent successfully via fake"},
+		{"fan out", http.MethodPost, `{"services": ["fake", "fake"], "message": "hi"}`, http.StatusOK, "Message dispatched to 2 destinations"},
+		{"method", http.MethodGet, ``, http.StatusMethodNotAllowed, "Method not allowed"},
+		{"json", http.MethodPost, `{"service": `, http.StatusBadRequest, "Invalid JSON"},
+		{"no destination", http.MethodPost, `{"message": "hi"}`, http.StatusBadRequest, "no service, target or services given"},
+		{"no message", http.MethodPost, `{"service": "fake"}`, http.StatusBadRequest, "message or template field is required"},
+		{"fail on", http.MethodPost, `{"services": ["fake"], "message": "hi", "fail_on": "some"}`, http.StatusBadRequest, "some"},
+		{"escalation", http.MethodPost, `{"escalation": "oncall", "message": "hi"}`, http.StatusBadRequest, "no escalation policies configured"},
+		{"unknown service", http.MethodPost, `{"service": "carrier-pigeon", "message": "hi"}`, http.StatusInternalServerError, "unsupported service"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/webhook", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			assert.Contains(t, w.Body.String(), tt.want)
+		})
+	}
+}
+
+func TestWebhook_Scope(t *testing.T) {
+	cfg := newConfig(t, `
+targets:
+  ops:
+    service: fake
+  oncall:
+    service: fake
+api_keys:
+  - name: ci
+    key: ci-key
+    targets: [ops]
+`)
+	handler := middleware.Auth(NewWebhookHandler(dispatcher.New(cfg), nil, nil, nil), cfg)
+
+	tests := []struct {
+		body   string
+		status int
+		want   string
+	}{
+		{`{"target": "ops", "message": "hi"}`, http.StatusOK, "Message sent successfully via ops"},
+		{`{"target": "oncall", "message": "hi"}`, http.StatusForbidden, "Not allowed to send to oncall"},
+		{`{"service": "fake", "message": "hi"}`, http.StatusForbidden, "Not allowed to send to fake"},
+		{`{"services": ["ops", "oncall"], "message": "hi"}`, http.StatusForbidden, "Not allowed to send to oncall"},
+	}
+	for _, tt := range tests {
+		w := post(handler, tt.body, "Authorization", "Bearer ci-key")
+		assert.Equal(t, tt.status, w.Code, tt.body)
+		assert.Contains(t, w.Body.String(), tt.want)
+	}
+}
+
+func TestWebhook_Idempotency(t *testing.T) {
+	handler := NewWebhookHandler(dispatcher.New(nil), nil, idempotency.New(time.Hour), nil)
+	sends.Store(0)
+
+	first := post(handler, `{"service": "fake", "message": "hi"}`, "Idempotency-Key", "k1")
+	assert.Equal(t, http.StatusOK, first.Code)
+	again := post(handler, `{"service": "fake", "message": "hi"}`, "Idempotency-Key", "k1")
+	assert.Equal(t, http.StatusOK, again.Code)
+	assert.Equal(t, first.Body.String(), again.Body.String(), "the first response is replayed")
+	assert.Equal(t, int32(1), sends.Load())
+
+	// the id of the request is a key too
+	post(handler, `{"id": "k2", "service": "fake", "message": "hi"}`)
+	post(handler, `{"id": "k2", "service": "fake", "message": "hi"}`)
+	assert.Equal(t, int32(2), sends.Load())
+
+	w := post(handler, `{"service": "fake", "message": "other"}`, "Idempotency-Key", "k1")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, decode(t, w).Error, "idempotency key")
+
+	// requests still in progress conflict
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- post(handler, `{"service": "blocking", "message": "hi"}`, "Idempotency-Key", "k3")
+	}()
+	<-started
+	w = post(handler, `{"service": "blocking", "message": "hi"}`, "Idempotency-Key", "k3")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	release <- struct{}{}
+	assert.Equal(t, http.StatusOK, (<-done).Code)
+	assert.Equal(t, int32(3), sends.Load())
+}
+
+func TestWebhook_Async(t *testing.T) {
+	q := queue.New(dispatcher.New(nil), 1, 10, nil)
+	assert.Nil(t, q.Start(context.Background()))
+	defer func() { _ = q.Close(context.Background()) }()
+
+	mux := http.NewServeMux()
+	mux.Handle("/webhook", NewWebhookHandler(dispatcher.New(nil), q, nil, nil))
+	mux.Handle("/messages/{id}", NewMessagesHandler(q, nil))
+
+	w := post(mux, `{"service": "fake", "message": "hi"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	resp := decode(t, w)
+	assert.NotEmpty(t, resp.ID)
+	location := w.Header().Get("Location")
+	assert.Equal(t, "/messages/"+resp.ID, location)
+
+	var msg queue.Message
+	for i := 0; i < 100 && msg.Status != queue.StatusSent; i++ {
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, location, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &msg))
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, queue.StatusSent, msg.Status)
+	assert.Equal(t, resp.ID, msg.ID)
+
+	w = post(mux, `[{"service": "fake", "message": "hi"}]`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	for method, status := range map[string]int{http.MethodGet: http.StatusNotFound, http.MethodPost: http.StatusMethodNotAllowed} {
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, "/messages/unknown", nil))
+		assert.Equal(t, status, w.Code, method)
+	}
+}
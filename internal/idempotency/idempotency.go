@@ -0,0 +1,165 @@
+// Package idempotency stores the responses of webhook requests sent with
+// an idempotency key, so a repeated request gets the original response
+// instead of being delivered again.
+package idempotency
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInProgress is returned by Begin while the first request with the
+	// key is still being handled
+	ErrInProgress = errors.New("a request with this idempotency key is in progress")
+	// ErrMismatch is returned by Begin for a different request reusing a
+	// key
+	ErrMismatch = errors.New("idempotency key was used for a different request")
+)
+
+// DefaultTTL is how long responses are kept by default
+const DefaultTTL = 24 * time.Hour
+
+// pruneInterval is how often expired responses are dropped
+const pruneInterval = time.Minute
+
+// replayedHeaders are the response headers stored with the body
+var replayedHeaders = []string{"Content-Type", "Location"}
+
+// Response is a stored response
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Store keeps the responses of requests by idempotency key for a TTL
+type Store struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastPrune time.Time
+}
+
+type entry struct {
+	fingerprint string
+	// response is nil while the request is in progress
+	response *Response
+	expires  time.Time
+}
+
+// New returns a Store keeping responses for ttl
+func New(ttl time.Duration) *Store {
+	return &Store{ttl: ttl, now: time.Now, entries: make(map[string]*entry)}
+}
+
+// Begin claims key for a request identified by fingerprint. It returns
+// the stored response if the request was already handled, otherwise the
+// caller handles the request and reports the outcome with Finish
+func (s *Store) Begin(key, fingerprint string) (*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.prune(now)
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		switch {
+		case e.fingerprint != fingerprint:
+			return nil, ErrMismatch
+		case e.response == nil:
+			return nil, ErrInProgress
+		default:
+			return e.response, nil
+		}
+	}
+	// the claim expires too in case Finish is never called
+	s.entries[key] = &entry{fingerprint: fingerprint, expires: now.Add(s.ttl)}
+	return nil, nil
+}
+
+// Finish stores the response of the request claimed with Begin. A nil
+// response releases the key, so the request can be repeated
+func (s *Store) Finish(key string, resp *Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	if resp == nil {
+		delete(s.entries, key)
+		return
+	}
+	e.response = resp
+	e.expires = s.now().Add(s.ttl)
+}
+
+// prune drops expired responses at most once a minute, s.mu must be held
+func (s *Store) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < pruneInterval {
+		return
+	}
+	s.lastPrune = now
+	for key, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// Recorder is a ResponseWriter recording the response it writes
+type Recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// NewRecorder returns a Recorder writing to w
+func NewRecorder(w http.ResponseWriter) *Recorder {
+	return &Recorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+// WriteHeader implements http.ResponseWriter
+func (r *Recorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter
+func (r *Recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Response returns the recorded response if it was successful, failed
+// requests may be repeated and return nil
+func (r *Recorder) Response() *Response {
+	if r.status < 200 || r.status >= 300 {
+		return nil
+	}
+	header := make(http.Header)
+	for _, name := range replayedHeaders {
+		if v := r.Header().Get(name); v != "" {
+			header.Set(name, v)
+		}
+	}
+	return &Response{Status: r.status, Header: header, Body: r.body.Bytes()}
+}
+
+// Replay writes resp to w, marked with an Idempotent-Replayed header
+func Replay(w http.ResponseWriter, resp *Response) {
+	for name, values := range resp.Header {
+		for _, v := range values {
+			w.Header().Add(name, v)
+		}
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(resp.Status)
+	_, _ = w.Write(resp.Body)
+}
@@ -0,0 +1,73 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	s := New(time.Hour)
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+	done := &Response{Status: http.StatusOK, Body: []byte(`{"success":true}`)}
+
+	resp, err := s.Begin("k1", "a")
+	assert.Nil(t, err)
+	assert.Nil(t, resp)
+
+	_, err = s.Begin("k1", "a")
+	assert.ErrorIs(t, err, ErrInProgress)
+	_, err = s.Begin("k1", "b")
+	assert.ErrorIs(t, err, ErrMismatch)
+
+	s.Finish("k1", done)
+	resp, err = s.Begin("k1", "a")
+	assert.Nil(t, err)
+	assert.Equal(t, done, resp)
+
+	// failed requests release the key
+	_, err = s.Begin("k2", "a")
+	assert.Nil(t, err)
+	s.Finish("k2", nil)
+	resp, err = s.Begin("k2", "a")
+	assert.Nil(t, err)
+	assert.Nil(t, resp)
+
+	// responses expire after the TTL
+	now = now.Add(time.Hour)
+	resp, err = s.Begin("k1", "b")
+	assert.Nil(t, err)
+	assert.Nil(t, resp)
+	assert.Len(t, s.entries, 1, "expired entries are pruned")
+}
+
+func TestRecorder(t *testing.T) {
+	w := httptest.NewRecorder()
+	rec := NewRecorder(w)
+	rec.Header().Set("Content-Type", "application/json")
+	rec.Header().Set("Location", "/messages/1")
+	rec.Header().Set("X-Other", "x")
+	rec.WriteHeader(http.StatusAccepted)
+	_, _ = rec.Write([]byte(`{"id":"1"}`))
+
+	resp := rec.Response()
+	assert.Equal(t, http.StatusAccepted, resp.Status)
+	assert.Equal(t, `{"id":"1"}`, string(resp.Body))
+	assert.Equal(t, http.Header{"Content-Type": {"application/json"}, "Location": {"/messages/1"}}, resp.Header)
+	assert.Equal(t, `{"id":"1"}`, w.Body.String(), "the response is written through")
+
+	replayed := httptest.NewRecorder()
+	Replay(replayed, resp)
+	assert.Equal(t, http.StatusAccepted, replayed.Code)
+	assert.Equal(t, "/messages/1", replayed.Header().Get("Location"))
+	assert.Equal(t, "true", replayed.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, `{"id":"1"}`, replayed.Body.String())
+
+	failed := NewRecorder(httptest.NewRecorder())
+	failed.WriteHeader(http.StatusTooManyRequests)
+	assert.Nil(t, failed.Response())
+}
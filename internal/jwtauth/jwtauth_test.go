@@ -0,0 +1,172 @@
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, key crypto.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	assert.Nil(t, err)
+	return s
+}
+
+func TestParseKeys_JWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	jwks, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(edPub)},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "x", "e": "x"},
+	}})
+	assert.Nil(t, err)
+
+	keys, err := ParseKeys(jwks)
+	assert.Nil(t, err)
+	assert.Len(t, keys, 3)
+	assert.True(t, rsaKey.PublicKey.Equal(keys["rsa"]))
+	assert.True(t, ecKey.PublicKey.Equal(keys["ec"]))
+	assert.Equal(t, edPub, keys["ed"])
+
+	for _, doc := range []string{
+		`{"keys":[]}`,
+		`{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`,
+		`{"keys":[{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}]}`,
+		`{"keys":[{"kty":"OKP","crv":"X25519","x":"AQ"}]}`,
+		`{"keys":[{"kty":"OKP","crv":"Ed25519","x":"AQ","kid":"a"},{"kty":"OKP","crv":"Ed25519","x":"AQ","kid":"a"}]}`,
+		`not a key`,
+	} {
+		_, err = ParseKeys([]byte(doc))
+		assert.NotNil(t, err, doc)
+	}
+}
+
+func TestParseKeys_PEM(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.Nil(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.Nil(t, err)
+
+	keys, err := ParseKeys(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	assert.Nil(t, err)
+	assert.True(t, key.PublicKey.Equal(keys[""]))
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	v := NewWithKeys(map[string]crypto.PublicKey{
+		"rsa": &rsaKey.PublicKey,
+		"ed":  edKey.Public(),
+	}, Options{Issuer: "https://issuer.example", Audience: "pingme", Leeway: time.Minute})
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss": "https://issuer.example",
+			"aud": []string{"other", "pingme"},
+			"sub": "ci",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+	}
+
+	claims, err := v.Verify(signToken(t, jwt.SigningMethodRS256, rsaKey, "rsa", valid()))
+	assert.Nil(t, err)
+	assert.Equal(t, "ci", claims["sub"])
+	_, err = v.Verify(signToken(t, jwt.SigningMethodEdDSA, edKey, "ed", valid()))
+	assert.Nil(t, err)
+
+	// expired within the leeway
+	c := valid()
+	c["exp"] = time.Now().Add(-30 * time.Second).Unix()
+	_, err = v.Verify(signToken(t, jwt.SigningMethodRS256, rsaKey, "rsa", c))
+	assert.Nil(t, err)
+
+	invalid := map[string]func(jwt.MapClaims){
+		"expired":     func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() },
+		"no exp":      func(c jwt.MapClaims) { delete(c, "exp") },
+		"not yet":     func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(time.Hour).Unix() },
+		"issuer":      func(c jwt.MapClaims) { c["iss"] = "https://evil.example" },
+		"audience":    func(c jwt.MapClaims) { c["aud"] = "other" },
+		"no audience": func(c jwt.MapClaims) { delete(c, "aud") },
+		"no issuer":   func(c jwt.MapClaims) { delete(c, "iss") },
+	}
+	for name, modify := range invalid {
+		c := valid()
+		modify(c)
+		_, err = v.Verify(signToken(t, jwt.SigningMethodRS256, rsaKey, "rsa", c))
+		assert.NotNil(t, err, name)
+	}
+
+	// wrong key, unknown kid, symmetric and unsigned tokens
+	_, err = v.Verify(signToken(t, jwt.SigningMethodRS256, rsaKey, "ed", valid()))
+	assert.NotNil(t, err)
+	_, err = v.Verify(signToken(t, jwt.SigningMethodRS256, rsaKey, "missing", valid()))
+	assert.NotNil(t, err)
+	_, err = v.Verify(signToken(t, jwt.SigningMethodRS256, rsaKey, "", valid()))
+	assert.NotNil(t, err, "several keys require a kid")
+	_, err = v.Verify(signToken(t, jwt.SigningMethodHS256, []byte("secret"), "rsa", valid()))
+	assert.NotNil(t, err)
+	_, err = v.Verify(signToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "rsa", valid()))
+	assert.NotNil(t, err)
+
+	// a single key doesn't need a kid
+	single := NewWithKeys(map[string]crypto.PublicKey{"": &rsaKey.PublicKey}, Options{})
+	_, err = single.Verify(signToken(t, jwt.SigningMethodPS256, rsaKey, "", valid()))
+	assert.Nil(t, err)
+}
+
+func TestMatchClaims(t *testing.T) {
+	claims := map[string]interface{}{
+		"sub":    "system:serviceaccount:ci:deployer",
+		"groups": []interface{}{"dev", "ops"},
+		"level":  float64(3),
+	}
+	match := func(want map[string]string) bool {
+		patterns := make(map[string]*regexp.Regexp)
+		for k, v := range want {
+			patterns[k] = Pattern(v)
+		}
+		return MatchClaims(claims, patterns)
+	}
+
+	assert.True(t, match(map[string]string{"sub": "system:serviceaccount:ci:*"}))
+	assert.True(t, match(map[string]string{"groups": "ops", "level": "3"}))
+	assert.False(t, match(map[string]string{"sub": "system:serviceaccount:ci"}))
+	assert.False(t, match(map[string]string{"groups": "admin"}))
+	assert.False(t, match(map[string]string{"missing": "*"}))
+	// regular expression characters are literal
+	assert.False(t, match(map[string]string{"sub": "system.serviceaccount.ci.deployer"}))
+}
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds the metrics exposed by the webhook server
+var Registry = prometheus.NewRegistry()
+
+// deliveryBuckets cover slow services and retries with backoff
+var deliveryBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+var (
+	// HTTPRequests counts handled http requests
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pingme_http_requests_total",
+		Help: "Total number of http requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	// HTTPDuration observes the time spent handling http requests
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pingme_http_request_duration_seconds",
+		Help:    "Duration of http requests in seconds by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// Deliveries counts sent messages by service and result
+	Deliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pingme_deliveries_total",
+		Help: "Total number of message deliveries by service and result.",
+	}, []string{"service", "result"})
+	// DeliveryDuration observes the time spent delivering a message, including retries
+	DeliveryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pingme_delivery_duration_seconds",
+		Help:    "Duration of message deliveries in seconds by service, including retries.",
+		Buckets: deliveryBuckets,
+	}, []string{"service"})
+	// DeliveryRetries counts retried delivery attempts
+	DeliveryRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pingme_delivery_retries_total",
+		Help: "Total number of retried delivery attempts by service.",
+	}, []string{"service"})
+
+	// queueDepth returns the number of messages waiting for delivery
+	queueDepth atomic.Pointer[func() float64]
+)
+
+func init() {
+	Registry.MustRegister(
+		HTTPRequests, HTTPDuration,
+		Deliveries, DeliveryDuration, DeliveryRetries,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "pingme_queue_depth",
+			Help: "Number of messages waiting for delivery in async mode.",
+		}, func() float64 {
+			if f := queueDepth.Load(); f != nil {
+				return (*f)()
+			}
+			return 0
+		}),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// SetQueueDepth sets the function reporting the queue depth
+func SetQueueDepth(f func() float64) {
+	queueDepth.Store(&f)
+}
+
+// Result label values of Deliveries
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Handler serves the metrics of the Registry. If token is not empty
+// requests must send it as "Authorization: Bearer <token>", otherwise the
+// caller is responsible for protecting the handler
+func Handler(token string) http.Handler {
+	metrics := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if token != "" {
+			provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				log.Printf("Authentication failed for %s from %s", r.URL.Path, r.RemoteAddr)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		metrics.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	SetQueueDepth(func() float64 { return 7 })
+	Deliveries.WithLabelValues("slack", ResultSuccess).Inc()
+
+	rec := httptest.NewRecorder()
+	Handler("secret").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = httptest.NewRecorder()
+	Handler("secret").ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	Handler("secret").ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "# TYPE pingme_deliveries_total counter")
+	assert.Contains(t, rec.Body.String(), `pingme_deliveries_total{result="success",service="slack"} 1`)
+	assert.Contains(t, rec.Body.String(), "pingme_queue_depth 7")
+}
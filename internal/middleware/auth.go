@@ -1,34 +1,95 @@
 package middleware
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
-	"io"
+	"context"
+	"crypto/subtle"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/config"
+	"github.com/kha7iq/pingme/internal/secret"
 )
 
-// Auth middleware handles authentication using environment variables
-func Auth(next http.Handler) http.Handler {
+// Caller is the authenticated sender of a request whose permissions are
+// limited, e.g. by a named API key, JWT grants or mTLS clients
+type Caller struct {
+	// Name identifies the caller in logs, e.g. the API key name or the
+	// JWT subject
+	Name  string
+	Scope config.Scope
+}
+
+// callerContext is the context key of the Caller of a request
+type callerContext struct{}
+
+// CallerFrom returns the caller of the request, nil if the request was
+// not authenticated with a named API key, a JWT or a client certificate
+func CallerFrom(ctx context.Context) *Caller {
+	caller, _ := ctx.Value(callerContext{}).(*Caller)
+	return caller
+}
+
+// Auth middleware handles authentication using environment variables.
+// The named API keys of cfg enable the apikey method if PINGME_AUTH_METHOD
+// is not set, the jwt method uses the jwt settings of cfg and the mtls
+// method the client certificates allowed by the mtls settings of cfg.
+// Requests to the verified paths, or below those ending with a slash, are
+// passed on, their handlers verify the sender themselves
+func Auth(next http.Handler, cfg *config.Config, verified ...string) http.Handler {
+	keys := cfg.APIKeys
+	hmacAuth := newHMACVerifier()
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Skip auth for health endpoint
 		if r.URL.Path == "/health" || r.URL.Path == "/" {
 			next.ServeHTTP(w, r)
 			return
 		}
+		for _, path := range verified {
+			if r.URL.Path == path || (strings.HasSuffix(path, "/") && strings.HasPrefix(r.URL.Path, path)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
 
 		// Get auth method from environment
 		authMethod := os.Getenv("PINGME_AUTH_METHOD")
+		if authMethod == "" && len(keys) > 0 {
+			authMethod = "apikey"
+		}
 
-		var authenticated bool
+		var (
+			authenticated bool
+			key           *config.APIKey
+			caller        *Caller
+		)
 		switch authMethod {
 		case "apikey":
-			authenticated = validateAPIKey(r)
+			key, authenticated = validateAPIKey(r, keys)
+		case "jwt":
+			var err error
+			if caller, err = validateJWT(r, cfg); errors.Is(err, config.ErrNoGrant) {
+				log.Printf("%s has no grant for %s from %s", caller.Name, r.URL.Path, r.RemoteAddr)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			} else if err != nil {
+				log.Printf("Invalid JWT for %s from %s: %v", r.URL.Path, r.RemoteAddr, err)
+			}
+			authenticated = err == nil
+		case "mtls":
+			var err error
+			if caller, err = validateClientCert(r, cfg); errors.Is(err, config.ErrNoClient) {
+				log.Printf("%s is not allowed to call %s from %s", caller.Name, r.URL.Path, r.RemoteAddr)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			authenticated = err == nil
 		case "hmac":
-			authenticated = validateHMAC(r)
+			authenticated = hmacAuth.validate(r)
 		case "basic":
 			authenticated = validateBasicAuth(r)
 		default:
@@ -41,78 +102,98 @@ func Auth(next http.Handler) http.Handler {
 			return
 		}
 
+		if key != nil {
+			if key.Expired(time.Now()) {
+				log.Printf("Authentication failed for %s from %s: API key %q expired", r.URL.Path, r.RemoteAddr, key.Name)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			caller = &Caller{Name: "API key " + strconv.Quote(key.Name), Scope: key.Scope}
+		}
+		if caller != nil {
+			if !caller.Scope.AllowsEndpoint(r.URL.Path) {
+				log.Printf("%s denied access to %s from %s", caller.Name, r.URL.Path, r.RemoteAddr)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), callerContext{}, caller))
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
-// validateAPIKey checks if the request has a valid API key
-// Set PINGME_API_KEYS="key1,key2,key3" (comma-separated)
-func validateAPIKey(r *http.Request) bool {
-	// Check Authorization header: "Bearer <api_key>"
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		return false
+// validateJWT verifies the bearer JWT of the request and returns the
+// caller with the scope granted to the token
+func validateJWT(r *http.Request, cfg *config.Config) (*Caller, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, errors.New("no bearer token")
+	}
+	name, scope, err := cfg.VerifyJWT(token)
+	return &Caller{Name: "JWT of " + strconv.Quote(name), Scope: scope}, err
+}
+
+// validateClientCert returns the caller of a request with a client
+// certificate verified by the TLS handshake and the scope granted to it
+func validateClientCert(r *http.Request, cfg *config.Config) (*Caller, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, errors.New("no verified client certificate")
 	}
+	name, scope, err := cfg.VerifyClientCert(r.TLS.VerifiedChains[0][0])
+	return &Caller{Name: "client certificate " + strconv.Quote(name), Scope: scope}, err
+}
 
-	parts := strings.Split(authHeader, " ")
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		return false
+// bearerToken returns the token of an "Authorization: Bearer <token>"
+// header
+func bearerToken(r *http.Request) (string, bool) {
+	parts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(parts) != 2 || parts[0] != "Bearer" || parts[1] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// validateAPIKey checks if the request has a valid API key and returns
+// the matching named key from keys. Keys from the environment allow
+// everything and return no named key
+// Set PINGME_API_KEYS="key1,key2,key3" (comma-separated), keys may be
+// hashed, see the secret package
+func validateAPIKey(r *http.Request, keys []config.APIKey) (*config.APIKey, bool) {
+	// Check Authorization header: "Bearer <api_key>"
+	providedKey, ok := bearerToken(r)
+	if !ok {
+		return nil, false
 	}
 
-	providedKey := parts[1]
+	for i := range keys {
+		if secret.Verify(keys[i].Key, providedKey) {
+			return &keys[i], true
+		}
+	}
 
 	// Get valid keys from environment
 	validKeysStr := os.Getenv("PINGME_API_KEYS")
 	if validKeysStr == "" {
-		log.Println("PINGME_API_KEYS not set")
-		return false
+		if len(keys) == 0 {
+			log.Println("PINGME_API_KEYS not set")
+		}
+		return nil, false
 	}
 
 	validKeys := strings.Split(validKeysStr, ",")
 	for _, validKey := range validKeys {
-		if strings.TrimSpace(validKey) == providedKey {
-			return true
+		if secret.Verify(strings.TrimSpace(validKey), providedKey) {
+			return nil, true
 		}
 	}
 
-	return false
-}
-
-// validateHMAC validates HMAC-SHA256 signature
-// Expects X-Signature header with hex-encoded HMAC
-// Set PINGME_HMAC_SECRET="your-secret"
-func validateHMAC(r *http.Request) bool {
-	secret := os.Getenv("PINGME_HMAC_SECRET")
-	if secret == "" {
-		log.Println("PINGME_HMAC_SECRET not set")
-		return false
-	}
-
-	// Get signature from header
-	providedSignature := r.Header.Get("X-Signature")
-	if providedSignature == "" {
-		return false
-	}
-
-	// Read body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		return false
-	}
-	// Important: Restore body for next handler
-	r.Body = io.NopCloser(strings.NewReader(string(body)))
-
-	// Calculate expected signature
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write(body)
-	expectedSignature := hex.EncodeToString(mac.Sum(nil))
-
-	// Compare signatures
-	return hmac.Equal([]byte(providedSignature), []byte(expectedSignature))
+	return nil, false
 }
 
 // validateBasicAuth validates HTTP Basic Authentication
-// Set PINGME_BASIC_USER="username" and PINGME_BASIC_PASS="password"
+// Set PINGME_BASIC_USER="username" and PINGME_BASIC_PASS="password", the
+// password may be a bcrypt or argon2id hash, see the secret package
 func validateBasicAuth(r *http.Request) bool {
 	expectedUser := os.Getenv("PINGME_BASIC_USER")
 	expectedPass := os.Getenv("PINGME_BASIC_PASS")
@@ -127,5 +208,8 @@ func validateBasicAuth(r *http.Request) bool {
 		return false
 	}
 
-	return user == expectedUser && pass == expectedPass
+	// check both so the response time doesn't reveal a valid user
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(expectedUser)) == 1
+	passOK := secret.Verify(expectedPass, pass)
+	return userOK && passOK
 }
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/kha7iq/pingme/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuth(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	assert.Nil(t, err)
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "jwt.pem")
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	path := filepath.Join(dir, "pingme.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(`
+api_keys:
+  - name: ci
+    key: ci-key
+    endpoints: [/webhook]
+  - name: old
+    key: old-key
+    expires: 2020-01-01T00:00:00Z
+jwt:
+  key_file: `+keyFile+`
+  grants:
+    - claims:
+        sub: "ci:*"
+      endpoints: [/webhook]
+mtls:
+  clients:
+    - subject: ci
+      endpoints: [/webhook]
+`), 0o600))
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := Auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if caller := CallerFrom(r.Context()); caller != nil {
+			w.Header().Set("X-Caller", caller.Name)
+		}
+	}), cfg, "/ack/")
+
+	token := func(sub string) string {
+		s, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+			"sub": sub,
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}).SignedString(priv)
+		assert.Nil(t, err)
+		return "Bearer " + s
+	}
+	cert := func(cn string) *tls.ConnectionState {
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: cn}}}}}
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		auth   string
+		tls    *tls.ConnectionState
+		status int
+		caller string
+	}{
+		{name: "health", method: "apikey", path: "/health", status: http.StatusOK},
+		{name: "metrics", method: "apikey", path: "/metrics", status: http.StatusUnauthorized},
+		{name: "verified path", method: "apikey", path: "/ack/token", status: http.StatusOK},
+		{name: "apikey default", path: "/webhook", auth: "Bearer ci-key", status: http.StatusOK, caller: `API key "ci"`},
+		{name: "apikey missing", path: "/webhook", status: http.StatusUnauthorized},
+		{name: "apikey wrong", path: "/webhook", auth: "Bearer wrong", status: http.StatusUnauthorized},
+		{name: "apikey basic", path: "/webhook", auth: "Basic Y2kta2V5Og==", status: http.StatusUnauthorized},
+		{name: "apikey endpoint", path: "/webhook/github", auth: "Bearer ci-key", status: http.StatusForbidden},
+		{name: "apikey expired", path: "/webhook", auth: "Bearer old-key", status: http.StatusUnauthorized},
+		{name: "apikey env", path: "/webhook/github", auth: "Bearer env-key", status: http.StatusOK},
+		{name: "jwt", method: "jwt", path: "/webhook", auth: token("ci:deploy"), status: http.StatusOK, caller: `JWT of "ci:deploy"`},
+		{name: "jwt endpoint", method: "jwt", path: "/webhook/github", auth: token("ci:deploy"), status: http.StatusForbidden},
+		{name: "jwt no grant", method: "jwt", path: "/webhook", auth: token("bob"), status: http.StatusForbidden},
+		{name: "jwt invalid", method: "jwt", path: "/webhook", auth: "Bearer ci-key", status: http.StatusUnauthorized},
+		{name: "jwt missing", method: "jwt", path: "/webhook", status: http.StatusUnauthorized},
+		{name: "mtls", method: "mtls", path: "/webhook", tls: cert("ci"), status: http.StatusOK, caller: `client certificate "ci"`},
+		{name: "mtls endpoint", method: "mtls", path: "/hooks/deploy", tls: cert("ci"), status: http.StatusForbidden},
+		{name: "mtls no client", method: "mtls", path: "/webhook", tls: cert("bob"), status: http.StatusForbidden},
+		{name: "mtls no certificate", method: "mtls", path: "/webhook", tls: &tls.ConnectionState{}, status: http.StatusUnauthorized},
+		{name: "mtls plain", method: "mtls", path: "/webhook", status: http.StatusUnauthorized},
+		{name: "basic", method: "basic", path: "/webhook", auth: "Basic YWxpY2U6czNjcmV0", status: http.StatusOK},
+		{name: "basic wrong", method: "basic", path: "/webhook", auth: "Basic YWxpY2U6d3Jvbmc=", status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PINGME_AUTH_METHOD", tt.method)
+			t.Setenv("PINGME_API_KEYS", "env-key")
+			t.Setenv("PINGME_BASIC_USER", "alice")
+			t.Setenv("PINGME_BASIC_PASS", "s3cret")
+
+			r := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			r.TLS = tt.tls
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.caller, w.Header().Get("X-Caller"))
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTolerance is the maximum age of signed timestamps if
+// PINGME_HMAC_TOLERANCE is not set
+const defaultTolerance = 5 * time.Minute
+
+// hmacVerifier validates HMAC-SHA256 signatures of request bodies. In
+// timestamp mode the signature covers "<timestamp>.<body>", or
+// "<timestamp>.<nonce>.<body>" if nonces are required, so captured requests
+// can only be replayed within the tolerance window or not at all
+type hmacVerifier struct {
+	// secrets are the active secrets, any of them may sign a request so
+	// secrets can be rotated
+	secrets   [][]byte
+	timestamp bool
+	tolerance time.Duration
+	// nonces remembers the nonces seen within the tolerance window, nil
+	// if nonces are not required
+	nonces *nonceCache
+	now    func() time.Time
+}
+
+// newHMACVerifier configures a verifier from the environment:
+//
+//	PINGME_HMAC_SECRET     secret, several separated by "," during rotation
+//	PINGME_HMAC_TIMESTAMP  "true" to require a signed X-Signature-Timestamp
+//	PINGME_HMAC_TOLERANCE  maximum clock difference, default 5m
+//	PINGME_HMAC_NONCE      "true" to require a signed X-Signature-Nonce used only once, implies PINGME_HMAC_TIMESTAMP
+func newHMACVerifier() *hmacVerifier {
+	v := &hmacVerifier{tolerance: defaultTolerance, now: time.Now}
+	for _, s := range strings.Split(os.Getenv("PINGME_HMAC_SECRET"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			v.secrets = append(v.secrets, []byte(s))
+		}
+	}
+
+	v.timestamp = envBool("PINGME_HMAC_TIMESTAMP")
+	if envBool("PINGME_HMAC_NONCE") {
+		v.timestamp = true
+		v.nonces = newNonceCache()
+	}
+	if s := os.Getenv("PINGME_HMAC_TOLERANCE"); s != "" {
+		tolerance, err := time.ParseDuration(s)
+		if err != nil || tolerance <= 0 {
+			log.Printf("Invalid PINGME_HMAC_TOLERANCE %q, using %s", s, defaultTolerance)
+		} else {
+			v.tolerance = tolerance
+		}
+	}
+	return v
+}
+
+// envBool reports whether the environment variable is set to true
+func envBool(name string) bool {
+	b, _ := strconv.ParseBool(os.Getenv(name))
+	return b
+}
+
+// validate checks the signature of r
+// Expects X-Signature header with hex-encoded HMAC, optionally prefixed
+// with "sha256=", and in timestamp mode X-Signature-Timestamp with the
+// unix time in seconds and X-Signature-Nonce if nonces are required
+func (v *hmacVerifier) validate(r *http.Request) bool {
+	if len(v.secrets) == 0 {
+		log.Println("PINGME_HMAC_SECRET not set")
+		return false
+	}
+
+	// Get signature from header
+	provided, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get("X-Signature"), "sha256="))
+	if err != nil || len(provided) == 0 {
+		return false
+	}
+
+	// Read body
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return false
+	}
+	// Important: Restore body for next handler
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var (
+		signed = body
+		nonce  string
+	)
+	if v.timestamp {
+		ts := r.Header.Get("X-Signature-Timestamp")
+		if !v.fresh(ts) {
+			return false
+		}
+		prefix := ts + "."
+		if v.nonces != nil {
+			if nonce = r.Header.Get("X-Signature-Nonce"); nonce == "" {
+				return false
+			}
+			prefix += nonce + "."
+		}
+		signed = append([]byte(prefix), body...)
+	}
+
+	valid := false
+	for _, secret := range v.secrets {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		if hmac.Equal(provided, mac.Sum(nil)) {
+			valid = true
+		}
+	}
+	if !valid {
+		return false
+	}
+
+	// only remember nonces of valid requests, so nobody can block them
+	if v.nonces != nil && !v.nonces.add(nonce, v.now(), 2*v.tolerance) {
+		log.Printf("Rejected replayed HMAC nonce for %s from %s", r.URL.Path, r.RemoteAddr)
+		return false
+	}
+	return true
+}
+
+// fresh reports whether the unix timestamp ts is within the tolerance
+func (v *hmacVerifier) fresh(ts string) bool {
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	diff := v.now().Sub(time.Unix(sec, 0))
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= v.tolerance
+}
+
+// nonceCache remembers nonces until they expire
+type nonceCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	nextPrune time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: make(map[string]time.Time)}
+}
+
+// add stores nonce for ttl and reports whether it was unused. Expired
+// nonces are dropped at most once a minute
+func (c *nonceCache) add(nonce string, now time.Time, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.After(c.nextPrune) {
+		for n, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, n)
+			}
+		}
+		c.nextPrune = now.Add(time.Minute)
+	}
+
+	if exp, ok := c.seen[nonce]; ok && now.Before(exp) {
+		return false
+	}
+	c.seen[nonce] = now.Add(ttl)
+	return true
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sign(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestHMAC_Body(t *testing.T) {
+	t.Setenv("PINGME_HMAC_SECRET", "old, new")
+	v := newHMACVerifier()
+	body := `{"service":"slack","message":"hi"}`
+
+	for _, sig := range []string{sign("old", body), "sha256=" + sign("new", body)} {
+		r := httptest.NewRequest("POST", "/webhook", strings.NewReader(body))
+		r.Header.Set("X-Signature", sig)
+		assert.True(t, v.validate(r), sig)
+
+		// the body is still readable
+		read, _ := io.ReadAll(r.Body)
+		assert.Equal(t, body, string(read))
+	}
+
+	for _, sig := range []string{"", "zz", sign("other", body), "sha1=" + sign("new", body)} {
+		r := httptest.NewRequest("POST", "/webhook", strings.NewReader(body))
+		r.Header.Set("X-Signature", sig)
+		assert.False(t, v.validate(r), sig)
+	}
+}
+
+func TestHMAC_Timestamp(t *testing.T) {
+	t.Setenv("PINGME_HMAC_SECRET", "s3cret")
+	t.Setenv("PINGME_HMAC_TIMESTAMP", "true")
+	t.Setenv("PINGME_HMAC_TOLERANCE", "1m")
+	v := newHMACVerifier()
+	now := time.Unix(1700000000, 0)
+	v.now = func() time.Time { return now }
+	body := `{"message":"hi"}`
+
+	request := func(ts int64, sig string) bool {
+		r := httptest.NewRequest("POST", "/webhook", strings.NewReader(body))
+		r.Header.Set("X-Signature-Timestamp", strconv.FormatInt(ts, 10))
+		r.Header.Set("X-Signature", sig)
+		return v.validate(r)
+	}
+	ts := now.Unix()
+	assert.True(t, request(ts, sign("s3cret", "1700000000."+body)))
+	assert.True(t, request(ts-60, sign("s3cret", "1699999940."+body)))
+	// outside the tolerance window
+	assert.False(t, request(ts-61, sign("s3cret", "1699999939."+body)))
+	assert.False(t, request(ts+61, sign("s3cret", "1700000061."+body)))
+	// the timestamp is part of the signature
+	assert.False(t, request(ts, sign("s3cret", body)))
+	assert.False(t, request(ts, sign("s3cret", "1699999999."+body)))
+}
+
+func TestHMAC_Nonce(t *testing.T) {
+	t.Setenv("PINGME_HMAC_SECRET", "s3cret")
+	t.Setenv("PINGME_HMAC_NONCE", "true")
+	v := newHMACVerifier()
+	now := time.Now()
+	v.now = func() time.Time { return now }
+	ts := strconv.FormatInt(now.Unix(), 10)
+	body := `{"message":"hi"}`
+
+	request := func(nonce, sig string) bool {
+		r := httptest.NewRequest("POST", "/webhook", strings.NewReader(body))
+		r.Header.Set("X-Signature-Timestamp", ts)
+		r.Header.Set("X-Signature-Nonce", nonce)
+		r.Header.Set("X-Signature", sig)
+		return v.validate(r)
+	}
+	assert.False(t, request("", sign("s3cret", ts+".."+body)))
+	// invalid signatures don't use up the nonce
+	assert.False(t, request("n1", sign("other", ts+".n1."+body)))
+	assert.True(t, request("n1", sign("s3cret", ts+".n1."+body)))
+	assert.False(t, request("n1", sign("s3cret", ts+".n1."+body)))
+	assert.True(t, request("n2", sign("s3cret", ts+".n2."+body)))
+
+	// nonces are forgotten once their timestamps are too old anyway
+	now = now.Add(11 * time.Minute)
+	assert.True(t, v.nonces.add("n1", now, time.Minute))
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/metrics"
+)
+
+// routeKey is the context key of the route recorded by Route
+type routeKey struct{}
+
+// Metrics middleware records request counts and durations by route. The
+// route is the matched pattern, e.g. /messages/{id}, so ids do not end up
+// as label values. It is recorded by Route, which wraps the mux
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		wrapped := &responseWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+
+		// the mux sets the pattern on its own copy of the request, the
+		// holder carries it back out
+		route := new(string)
+		next.ServeHTTP(wrapped, r.WithContext(context.WithValue(r.Context(), routeKey{}, route)))
+
+		// requests rejected before reaching the router have no pattern
+		if *route == "" {
+			*route = "unmatched"
+		}
+		metrics.HTTPRequests.WithLabelValues(*route, r.Method, strconv.Itoa(wrapped.statusCode)).Inc()
+		metrics.HTTPDuration.WithLabelValues(*route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Route records the pattern of mux matching the request for Metrics
+func Route(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeKey{}).(*string); ok {
+			if _, pattern := mux.Handler(r); pattern != "" {
+				*route = pattern
+			}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/ratelimit"
+)
+
+// RateLimit middleware limits the requests of every client to limiter and
+// answers requests over the limit with 429 and Retry-After. Clients are
+// identified by the caller set by Auth, e.g. the API key name, or by
+// their remote IP
+func RateLimit(next http.Handler, limiter *ratelimit.Limiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// health checks are not limited
+		if r.URL.Path == "/health" || r.URL.Path == "/" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := clientKey(r)
+		if ok, after := limiter.Allow(client); !ok {
+			tooManyRequests(w, r, client, after)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LimitFailures middleware counts failed authentication attempts against
+// the remote IP bucket of limiter, the one RateLimit uses for anonymous
+// requests, and answers every request of an IP without tokens left with
+// 429 before checking its credentials. It runs before Auth so guessing API
+// keys or passwords is throttled, while authenticated callers behind the
+// same IP keep their own limits
+func LimitFailures(next http.Handler, limiter *ratelimit.Limiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := ipKey(r)
+		if blocked, after := limiter.Blocked(client); blocked {
+			tooManyRequests(w, r, client, after)
+			return
+		}
+
+		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(wrapped, r)
+		if wrapped.statusCode == http.StatusUnauthorized {
+			limiter.Allow(client)
+		}
+	})
+}
+
+func tooManyRequests(w http.ResponseWriter, r *http.Request, client string, after time.Duration) {
+	log.Printf("Rate limit exceeded by %s for %s", client, r.URL.Path)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(after.Seconds()))))
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+}
+
+// clientKey identifies the client of a request for rate limits
+func clientKey(r *http.Request) string {
+	if caller := CallerFrom(r.Context()); caller != nil {
+		return caller.Name
+	}
+	return ipKey(r)
+}
+
+// ipKey identifies the remote IP of a request for rate limits
+func ipKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "IP " + host
+}
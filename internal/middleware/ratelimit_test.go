@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	handler := RateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		ratelimit.New(ratelimit.Limit{Events: 2, Per: time.Minute}))
+
+	request := func(path, remote string, caller *Caller) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", path, nil)
+		r.RemoteAddr = remote
+		if caller != nil {
+			r = r.WithContext(context.WithValue(r.Context(), callerContext{}, caller))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, request("/webhook", "10.0.0.1:1234", nil).Code)
+	}
+	w := request("/webhook", "10.0.0.1:5678", nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+
+	// other clients and health checks are not affected
+	assert.Equal(t, http.StatusOK, request("/webhook", "10.0.0.2:1234", nil).Code)
+	assert.Equal(t, http.StatusOK, request("/health", "10.0.0.1:1234", nil).Code)
+
+	// callers are limited by name, whatever their IP
+	ci := &Caller{Name: `API key "ci"`}
+	assert.Equal(t, http.StatusOK, request("/webhook", "10.0.0.1:1234", ci).Code)
+	assert.Equal(t, http.StatusOK, request("/webhook", "10.0.0.3:1234", ci).Code)
+	assert.Equal(t, http.StatusTooManyRequests, request("/webhook", "10.0.0.4:1234", ci).Code)
+}
+
+func TestLimitFailures(t *testing.T) {
+	status := http.StatusUnauthorized
+	handler := LimitFailures(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}), ratelimit.New(ratelimit.Limit{Events: 2, Per: time.Minute}))
+
+	request := func(remote string) int {
+		r := httptest.NewRequest("POST", "/webhook", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// successful requests don't use up the bucket of the IP
+	status = http.StatusOK
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, request("10.0.0.1:1234"))
+	}
+
+	status = http.StatusUnauthorized
+	assert.Equal(t, http.StatusUnauthorized, request("10.0.0.1:1234"))
+	assert.Equal(t, http.StatusUnauthorized, request("10.0.0.1:1234"))
+
+	// once the failures used up the bucket even valid credentials wait
+	status = http.StatusOK
+	assert.Equal(t, http.StatusTooManyRequests, request("10.0.0.1:1234"))
+	assert.Equal(t, http.StatusOK, request("10.0.0.2:1234"))
+}
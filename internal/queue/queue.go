@@ -0,0 +1,386 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/config"
+	"github.com/kha7iq/pingme/internal/dispatcher"
+	"github.com/kha7iq/pingme/internal/types"
+)
+
+var (
+	// ErrFull is returned by Enqueue when the queue has no capacity left
+	ErrFull = errors.New("queue is full")
+	// ErrClosed is returned by Enqueue once the queue is shutting down
+	ErrClosed = errors.New("queue is closed")
+)
+
+// Status is the delivery state of a queued message
+type Status string
+
+const (
+	// StatusQueued messages wait for a worker
+	StatusQueued Status = "queued"
+	// StatusSending messages are being delivered
+	StatusSending Status = "sending"
+	// StatusSent messages were delivered
+	StatusSent Status = "sent"
+	// StatusAccepted messages were added to a digest or deferred by a
+	// target schedule, they are sent later
+	StatusAccepted Status = "accepted"
+	// StatusFailed messages could not be delivered
+	StatusFailed Status = "failed"
+)
+
+// Default settings of the queue
+const (
+	DefaultWorkers = 4
+	DefaultSize    = 1000
+	// deliveryTimeout bounds the delivery of a single message, including retries
+	deliveryTimeout = 5 * time.Minute
+	// retention is how long finished messages can still be looked up
+	retention = time.Hour
+	// pruneInterval is how often finished messages are checked for expiry
+	pruneInterval = time.Minute
+)
+
+// Message is a queued webhook request and its delivery state
+type Message struct {
+	ID        string                `json:"id"`
+	Status    Status                `json:"status"`
+	Attempts  int                   `json:"attempts"`
+	Error     string                `json:"error,omitempty"`
+	Results   []dispatcher.Delivery `json:"results,omitempty"`
+	CreatedAt time.Time             `json:"created_at"`
+	UpdatedAt time.Time             `json:"updated_at"`
+
+	request types.WebhookRequest
+}
+
+// Queue delivers webhook requests in the background with a bounded pool
+// of workers. With a Store pending messages survive restarts.
+type Queue struct {
+	dispatcher *dispatcher.Dispatcher
+	store      Store
+	workers    int
+	jobs       chan string
+	stop       chan struct{}
+
+	mu        sync.Mutex
+	messages  map[string]*Message
+	closed    bool
+	lastPrune time.Time
+
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+}
+
+// New creates a queue holding up to size pending messages, delivered by
+// the given number of workers. Non-positive values use the defaults. store
+// may be nil to keep messages in memory only.
+func New(d *dispatcher.Dispatcher, workers, size int, store Store) *Queue {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &Queue{
+		dispatcher: d,
+		store:      store,
+		workers:    workers,
+		jobs:       make(chan string, size),
+		stop:       make(chan struct{}),
+		messages:   make(map[string]*Message),
+	}
+}
+
+// Start replays the messages pending in the store and launches the
+// workers, they stop when ctx is cancelled or the queue is closed
+func (q *Queue) Start(ctx context.Context) error {
+	if q.store != nil {
+		pending, err := q.store.Pending()
+		if err != nil {
+			return fmt.Errorf("failed to load pending messages: %w", err)
+		}
+		if len(pending) > 0 {
+			if free := cap(q.jobs) - len(q.jobs); len(pending) > free {
+				q.jobs = make(chan string, len(pending)+cap(q.jobs))
+			}
+			for _, msg := range pending {
+				msg := msg
+				msg.Status = StatusQueued
+				q.messages[msg.ID] = &msg
+				q.jobs <- msg.ID
+			}
+			log.Printf("Replaying %d undelivered messages", len(pending))
+		}
+	}
+
+	ctx, q.cancel = context.WithCancel(ctx)
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work(ctx)
+	}
+	log.Printf("Delivery queue started with %d workers", q.workers)
+	return nil
+}
+
+// Close stops accepting messages. Without a store it waits until the
+// pending messages are delivered, with a store only until the running
+// deliveries are done, the remaining messages stay in the store. Deliveries
+// still running when ctx is done are cancelled, with a store they are
+// saved to be retried on the next start, as are the messages deferred by
+// target schedules.
+func (q *Queue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+		if q.store != nil {
+			close(q.stop)
+		}
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if q.cancel != nil {
+			q.cancel()
+		}
+		<-done
+		err = ctx.Err()
+	}
+
+	if q.store != nil {
+		q.keepDeferred()
+		if closeErr := q.store.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// keepDeferred saves the messages waiting for the schedule of their
+// target in the store, they are deferred again on the next start
+func (q *Queue) keepDeferred() {
+	reqs := q.dispatcher.TakeDeferred()
+	for _, req := range reqs {
+		msg, err := newMessage(req)
+		if err == nil {
+			err = q.store.Put(msg)
+		}
+		if err != nil {
+			log.Printf("Failed to save deferred message to %s: %v", req.Target+req.Service, err)
+		}
+	}
+	if len(reqs) > 0 {
+		log.Printf("Saved %d deferred message(s) for the next start", len(reqs))
+	}
+}
+
+// Enqueue adds req to the queue and returns the queued message
+func (q *Queue) Enqueue(req types.WebhookRequest) (Message, error) {
+	msg, err := newMessage(req)
+	if err != nil {
+		return Message{}, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return Message{}, ErrClosed
+	}
+	q.prune(msg.CreatedAt)
+
+	// only Enqueue sends to jobs and it holds q.mu, so a free slot can
+	// not be taken before the message is sent
+	if len(q.jobs) >= cap(q.jobs) {
+		return Message{}, ErrFull
+	}
+	if q.store != nil {
+		if err := q.store.Put(msg); err != nil {
+			return Message{}, err
+		}
+	}
+	q.jobs <- msg.ID
+	q.messages[msg.ID] = &msg
+	return msg, nil
+}
+
+// Len returns the number of messages waiting for delivery
+func (q *Queue) Len() int {
+	return len(q.jobs)
+}
+
+// Get returns the message with the given id
+func (q *Queue) Get(id string) (Message, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	msg, ok := q.messages[id]
+	if !ok {
+		return Message{}, false
+	}
+	return *msg, true
+}
+
+// work delivers messages until the queue is closed or ctx is done
+func (q *Queue) work(ctx context.Context) {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.stop:
+			return
+		case id, ok := <-q.jobs:
+			if !ok || q.stopping() {
+				return
+			}
+			q.deliver(ctx, id)
+		}
+	}
+}
+
+// stopping reports whether workers should stop taking messages, leaving
+// them in the store
+func (q *Queue) stopping() bool {
+	select {
+	case <-q.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// deliver sends a single message and records the outcome
+func (q *Queue) deliver(ctx context.Context, id string) {
+	req, ok := q.update(id, func(m *Message) { m.Status = StatusSending })
+	if !ok {
+		return
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+	results, err := q.dispatcher.Deliver(sendCtx, &req)
+
+	// interrupted by shutdown, keep the message for the next start
+	if err != nil && ctx.Err() != nil && q.store != nil {
+		q.update(id, func(m *Message) {
+			m.Status = StatusQueued
+			if storeErr := q.store.Put(*m); storeErr != nil {
+				log.Printf("Failed to save message %s: %v", id, storeErr)
+				return
+			}
+			log.Printf("Delivery of message %s interrupted, saved for the next start", id)
+		})
+		return
+	}
+
+	q.update(id, func(m *Message) {
+		m.Results = results
+		for _, res := range results {
+			if res.Attempts > m.Attempts {
+				m.Attempts = res.Attempts
+			}
+		}
+		if err != nil {
+			m.Status = StatusFailed
+			m.Error = err.Error()
+			log.Printf("Failed to deliver message %s after %d attempt(s): %v", id, m.Attempts, err)
+			return
+		}
+		if later(results) {
+			m.Status = StatusAccepted
+			log.Printf("Message %s accepted for a digest or schedule", id)
+			return
+		}
+		m.Status = StatusSent
+		log.Printf("Message %s delivered after %d attempt(s)", id, m.Attempts)
+	})
+
+	if q.store != nil {
+		if err := q.store.Delete(id); err != nil {
+			log.Printf("Failed to remove message %s from store: %v", id, err)
+		}
+	}
+}
+
+// later reports whether any of results is sent later by the dispatcher
+func later(results []dispatcher.Delivery) bool {
+	for _, res := range results {
+		if res.Batched || res.OutOfHours == string(config.Defer) {
+			return true
+		}
+	}
+	return false
+}
+
+// update applies fn to the message with the given id and returns its request
+func (q *Queue) update(id string, fn func(m *Message)) (types.WebhookRequest, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	msg, ok := q.messages[id]
+	if !ok {
+		return types.WebhookRequest{}, false
+	}
+	fn(msg)
+	msg.UpdatedAt = time.Now().UTC()
+	return msg.request, true
+}
+
+// prune drops finished messages older than the retention period, the
+// caller must hold q.mu
+func (q *Queue) prune(now time.Time) {
+	if now.Sub(q.lastPrune) < pruneInterval {
+		return
+	}
+	q.lastPrune = now
+
+	for id, msg := range q.messages {
+		finished := msg.Status == StatusSent || msg.Status == StatusFailed || msg.Status == StatusAccepted
+		if finished && now.Sub(msg.UpdatedAt) > retention {
+			delete(q.messages, id)
+		}
+	}
+}
+
+// newMessage returns req as a new queued message
+func newMessage(req types.WebhookRequest) (Message, error) {
+	id, err := newID()
+	if err != nil {
+		return Message{}, err
+	}
+	now := time.Now().UTC()
+	return Message{
+		ID:        id,
+		Status:    StatusQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+		request:   req,
+	}, nil
+}
+
+// newID returns a random message id
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
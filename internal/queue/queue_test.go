@@ -0,0 +1,119 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/config"
+	"github.com/kha7iq/pingme/internal/dispatcher"
+	"github.com/kha7iq/pingme/internal/types"
+	"github.com/kha7iq/pingme/service/notifier"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeNotifier fails when the message body equals "boom"
+type fakeNotifier struct{}
+
+func (fakeNotifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	if msg.Body == "boom" {
+		return notifier.Result{}, errors.New("fake failure")
+	}
+	return notifier.Result{Service: "fake"}, nil
+}
+
+// blockingNotifier blocks until the context is done
+type blockingNotifier struct{}
+
+func (blockingNotifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	<-ctx.Done()
+	return notifier.Result{}, ctx.Err()
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name: "blocking",
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			return blockingNotifier{}, nil
+		},
+	})
+	notifier.Register(notifier.Service{
+		Name: "fake",
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			return fakeNotifier{}, nil
+		},
+	})
+}
+
+// wait polls the queue until the message is finished
+func wait(t *testing.T, q *Queue, id string) Message {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		msg, ok := q.Get(id)
+		assert.True(t, ok)
+		if msg.Status == StatusSent || msg.Status == StatusFailed || msg.Status == StatusAccepted {
+			return msg
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("message %s not delivered", id)
+	return Message{}
+}
+
+func TestQueue(t *testing.T) {
+	q := New(dispatcher.New(&config.Config{}), 2, 10, nil)
+	assert.Nil(t, q.Start(context.Background()))
+
+	ok, err := q.Enqueue(types.WebhookRequest{Service: "fake", Message: "hello"})
+	assert.Nil(t, err)
+	assert.Equal(t, StatusQueued, ok.Status)
+	failed, err := q.Enqueue(types.WebhookRequest{Services: []string{"fake", "fake"}, Message: "boom"})
+	assert.Nil(t, err)
+
+	msg := wait(t, q, ok.ID)
+	assert.Equal(t, StatusSent, msg.Status)
+	assert.Equal(t, 1, msg.Attempts)
+
+	msg = wait(t, q, failed.ID)
+	assert.Equal(t, StatusFailed, msg.Status)
+	assert.Len(t, msg.Results, 2)
+	assert.NotEmpty(t, msg.Error)
+
+	_, ok2 := q.Get("missing")
+	assert.False(t, ok2)
+
+	assert.Nil(t, q.Close(context.Background()))
+	_, err = q.Enqueue(types.WebhookRequest{Service: "fake", Message: "late"})
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestQueue_Accepted(t *testing.T) {
+	d := dispatcher.New(&config.Config{
+		Targets: map[string]config.Target{
+			"digest": {Service: "fake", Digest: &config.Digest{Window: time.Hour}},
+		},
+	})
+	d.Start(context.Background())
+	q := New(d, 1, 10, nil)
+	assert.Nil(t, q.Start(context.Background()))
+
+	// digests are sent later, the message is not reported as sent
+	queued, err := q.Enqueue(types.WebhookRequest{Target: "digest", Message: "hello"})
+	assert.Nil(t, err)
+	msg := wait(t, q, queued.ID)
+	assert.Equal(t, StatusAccepted, msg.Status)
+	assert.True(t, msg.Results[0].Batched)
+
+	assert.Nil(t, q.Close(context.Background()))
+	assert.Nil(t, d.Flush(context.Background()))
+}
+
+func TestQueue_Full(t *testing.T) {
+	q := New(dispatcher.New(&config.Config{}), 1, 1, nil)
+
+	_, err := q.Enqueue(types.WebhookRequest{Service: "fake", Message: "first"})
+	assert.Nil(t, err)
+	_, err = q.Enqueue(types.WebhookRequest{Service: "fake", Message: "second"})
+	assert.Equal(t, ErrFull, err)
+}
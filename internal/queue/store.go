@@ -0,0 +1,240 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/kha7iq/pingme/internal/types"
+)
+
+// Store persists queued messages until they are delivered
+type Store interface {
+	// Put saves a pending message, replacing an earlier version
+	Put(msg Message) error
+	// Delete removes a message once it is no longer pending
+	Delete(id string) error
+	// Pending returns the saved messages in the order they were queued
+	Pending() ([]Message, error)
+	// Close releases the store
+	Close() error
+}
+
+// journalFile is the name of the queue journal inside the data directory
+const journalFile = "queue.log"
+
+// compactAfter is the number of deleted records after which the journal
+// is rewritten
+const compactAfter = 1000
+
+// record is a single line of the journal
+type record struct {
+	Op      string                `json:"op"`
+	ID      string                `json:"id"`
+	Message *Message              `json:"message,omitempty"`
+	Request *types.WebhookRequest `json:"request,omitempty"`
+}
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// FileStore is a Store backed by an append-only journal. Every change is
+// appended and synced to disk, the journal is compacted on open and after
+// compactAfter deletes.
+type FileStore struct {
+	path string
+
+	mu      sync.Mutex
+	file    *os.File
+	pending map[string]Message
+	deleted int
+}
+
+// OpenFileStore opens or creates the journal in dir
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	s := &FileStore{
+		path:    filepath.Join(dir, journalFile),
+		pending: make(map[string]Message),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load replays the journal into s.pending
+func (s *FileStore) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open queue journal: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// a crash while appending leaves a partial last line
+			log.Printf("Skipping corrupt queue journal entry at line %d: %v", line, err)
+			continue
+		}
+		switch rec.Op {
+		case opPut:
+			if rec.Message == nil || rec.Request == nil {
+				continue
+			}
+			msg := *rec.Message
+			msg.request = *rec.Request
+			s.pending[rec.ID] = msg
+		case opDelete:
+			delete(s.pending, rec.ID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read queue journal: %w", err)
+	}
+	return nil
+}
+
+// compact rewrites the journal with the pending messages only and opens
+// it for appending
+func (s *FileStore) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to compact queue journal: %w", err)
+	}
+
+	w := bufio.NewWriter(f)
+	for _, msg := range s.sorted() {
+		if err := writeRecord(w, putRecord(msg)); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to compact queue journal: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to compact queue journal: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to compact queue journal: %w", err)
+	}
+	f.Close()
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to compact queue journal: %w", err)
+	}
+
+	s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open queue journal: %w", err)
+	}
+	s.deleted = 0
+	return nil
+}
+
+// Put implements Store
+func (s *FileStore) Put(msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.append(putRecord(msg)); err != nil {
+		return err
+	}
+	s.pending[msg.ID] = msg
+	return nil
+}
+
+// Delete implements Store
+func (s *FileStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pending[id]; !ok {
+		return nil
+	}
+	if err := s.append(record{Op: opDelete, ID: id}); err != nil {
+		return err
+	}
+	delete(s.pending, id)
+
+	s.deleted++
+	if s.deleted >= compactAfter && s.deleted > len(s.pending) {
+		return s.compact()
+	}
+	return nil
+}
+
+// Pending implements Store
+func (s *FileStore) Pending() ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sorted(), nil
+}
+
+// Close implements Store
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// append writes rec to the journal and syncs it to disk
+func (s *FileStore) append(rec record) error {
+	if err := writeRecord(s.file, rec); err != nil {
+		return fmt.Errorf("failed to write queue journal: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync queue journal: %w", err)
+	}
+	return nil
+}
+
+// sorted returns the pending messages ordered by creation time
+func (s *FileStore) sorted() []Message {
+	msgs := make([]Message, 0, len(s.pending))
+	for _, msg := range s.pending {
+		msgs = append(msgs, msg)
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].CreatedAt.Before(msgs[j].CreatedAt)
+	})
+	return msgs
+}
+
+// putRecord returns the journal record saving msg
+func putRecord(msg Message) record {
+	req := msg.request
+	return record{Op: opPut, ID: msg.ID, Message: &msg, Request: &req}
+}
+
+// writeRecord writes rec as a single line
+func writeRecord(w io.Writer, rec record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,160 @@
+package queue
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/config"
+	"github.com/kha7iq/pingme/internal/dispatcher"
+	"github.com/kha7iq/pingme/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func testMessage(id string, created time.Time) Message {
+	return Message{
+		ID:        id,
+		Status:    StatusQueued,
+		CreatedAt: created,
+		request:   types.WebhookRequest{Service: "fake", Message: "hello " + id},
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir)
+	assert.Nil(t, err)
+
+	now := time.Now()
+	assert.Nil(t, s.Put(testMessage("b", now.Add(time.Second))))
+	assert.Nil(t, s.Put(testMessage("a", now)))
+	assert.Nil(t, s.Put(testMessage("c", now.Add(2*time.Second))))
+	assert.Nil(t, s.Delete("c"))
+	assert.Nil(t, s.Close())
+
+	// a partial line written during a crash is skipped
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_APPEND|os.O_WRONLY, 0o600)
+	assert.Nil(t, err)
+	_, err = f.WriteString(`{"op":"put","id":"d","mess`)
+	assert.Nil(t, err)
+	f.Close()
+
+	s, err = OpenFileStore(dir)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	pending, err := s.Pending()
+	assert.Nil(t, err)
+	assert.Len(t, pending, 2)
+	assert.Equal(t, "a", pending[0].ID)
+	assert.Equal(t, "hello a", pending[0].request.Message)
+	assert.Equal(t, "b", pending[1].ID)
+}
+
+func TestQueue_Replay(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir)
+	assert.Nil(t, err)
+
+	// queued but never started, as if the server crashed
+	q := New(dispatcher.New(&config.Config{}), 1, 10, s)
+	msg, err := q.Enqueue(types.WebhookRequest{Service: "fake", Message: "survivor"})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Close())
+
+	s, err = OpenFileStore(dir)
+	assert.Nil(t, err)
+	q = New(dispatcher.New(&config.Config{}), 1, 10, s)
+	assert.Nil(t, q.Start(context.Background()))
+
+	replayed := wait(t, q, msg.ID)
+	assert.Equal(t, StatusSent, replayed.Status)
+	assert.Nil(t, q.Close(context.Background()))
+
+	s, err = OpenFileStore(dir)
+	assert.Nil(t, err)
+	defer s.Close()
+	pending, err := s.Pending()
+	assert.Nil(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestQueue_CloseSavesInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir)
+	assert.Nil(t, err)
+
+	q := New(dispatcher.New(&config.Config{}), 1, 10, s)
+	assert.Nil(t, q.Start(context.Background()))
+	msg, err := q.Enqueue(types.WebhookRequest{Service: "blocking", Message: "stuck"})
+	assert.Nil(t, err)
+	for m, _ := q.Get(msg.ID); m.Status != StatusSending; m, _ = q.Get(msg.ID) {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.NotNil(t, q.Close(ctx))
+
+	s, err = OpenFileStore(dir)
+	assert.Nil(t, err)
+	defer s.Close()
+	pending, err := s.Pending()
+	assert.Nil(t, err)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, msg.ID, pending[0].ID)
+}
+
+func TestQueue_CloseSavesDeferred(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UTC()
+	var closed string
+	for _, day := range []int{-1, 0, 1} {
+		closed += now.AddDate(0, 0, day).Format("2006-01-02") + "\n"
+	}
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "closed.txt"), []byte(closed), 0o600))
+	path := filepath.Join(dir, "pingme.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(`
+targets:
+  quiet:
+    service: fake
+    schedule: {timezone: UTC, holidays: closed.txt}
+`), 0o600))
+	cfg, err := config.Load(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	start := func() *Queue {
+		s, err := OpenFileStore(filepath.Join(dir, "data"))
+		assert.Nil(t, err)
+		d := dispatcher.New(cfg)
+		d.Start(context.Background())
+		q := New(d, 1, 10, s)
+		assert.Nil(t, q.Start(context.Background()))
+		return q
+	}
+
+	q := start()
+	msg, err := q.Enqueue(types.WebhookRequest{Target: "quiet", Message: "later", Priority: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, StatusAccepted, wait(t, q, msg.ID).Status)
+	assert.Nil(t, q.Close(context.Background()))
+
+	s, err := OpenFileStore(filepath.Join(dir, "data"))
+	assert.Nil(t, err)
+	pending, err := s.Pending()
+	assert.Nil(t, err)
+	assert.Nil(t, s.Close())
+	if !assert.Len(t, pending, 1) {
+		return
+	}
+	assert.Equal(t, types.WebhookRequest{Target: "quiet", Message: "later", Priority: 2}, pending[0].request)
+
+	// the next start defers it again
+	q = start()
+	assert.Equal(t, StatusAccepted, wait(t, q, pending[0].ID).Status)
+	assert.Nil(t, q.Close(context.Background()))
+}
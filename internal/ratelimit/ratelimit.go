@@ -0,0 +1,213 @@
+// Package ratelimit implements token bucket rate limits keyed by client,
+// service or destination.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit allows Events per Per with bursts of up to Burst events. The zero
+// Limit allows everything
+type Limit struct {
+	Events int
+	Per    time.Duration
+	// Burst is the bucket size, Events if zero
+	Burst int
+}
+
+// Parse parses a limit like "30/s", "5/5s", "600/m" or "100/h". A number
+// without period is per second, an empty string or "0" is no limit
+func Parse(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return Limit{}, nil
+	}
+	events, period, found := strings.Cut(s, "/")
+	n, err := strconv.Atoi(strings.TrimSpace(events))
+	if err != nil || n < 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, expected e.g. 30/s or 600/m", s)
+	}
+	l := Limit{Events: n, Per: time.Second}
+	if !found {
+		return l, nil
+	}
+
+	period = strings.TrimSpace(period)
+	switch period {
+	case "s":
+		l.Per = time.Second
+	case "m":
+		l.Per = time.Minute
+	case "h":
+		l.Per = time.Hour
+	default:
+		if l.Per, err = time.ParseDuration(period); err != nil || l.Per <= 0 {
+			return Limit{}, fmt.Errorf("invalid rate limit %q, expected e.g. 30/s or 600/m", s)
+		}
+	}
+	return l, nil
+}
+
+// Unlimited reports whether l allows everything
+func (l Limit) Unlimited() bool {
+	return l.Events <= 0 || l.Per <= 0
+}
+
+// String formats l like Parse accepts it
+func (l Limit) String() string {
+	if l.Unlimited() {
+		return "unlimited"
+	}
+	switch l.Per {
+	case time.Second:
+		return fmt.Sprintf("%d/s", l.Events)
+	case time.Minute:
+		return fmt.Sprintf("%d/m", l.Events)
+	case time.Hour:
+		return fmt.Sprintf("%d/h", l.Events)
+	}
+	return fmt.Sprintf("%d/%s", l.Events, l.Per)
+}
+
+// rate returns the tokens added per second
+func (l Limit) rate() float64 {
+	return float64(l.Events) / l.Per.Seconds()
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Events)
+}
+
+// Limiter keeps a token bucket per key
+type Limiter struct {
+	limit Limit
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// pruneInterval is how often full buckets are dropped
+const pruneInterval = time.Minute
+
+// New returns a Limiter applying limit to every key
+func New(limit Limit) *Limiter {
+	return &Limiter{limit: limit, now: time.Now, buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a token for key if one is available. Otherwise it returns
+// false and how long until the next token is available
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil || l.limit.Unlimited() {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, l.wait(b.tokens)
+}
+
+// Blocked reports whether key has no token left without taking one, and
+// how long until the next token is available
+func (l *Limiter) Blocked(key string) (bool, time.Duration) {
+	if l == nil || l.limit.Unlimited() {
+		return false, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key)
+	if b.tokens >= 1 {
+		return false, 0
+	}
+	return true, l.wait(b.tokens)
+}
+
+// Wait takes a token for key, waiting until one is available or ctx is
+// done
+func (l *Limiter) Wait(ctx context.Context, key string) error {
+	if l == nil || l.limit.Unlimited() {
+		return nil
+	}
+	l.mu.Lock()
+	b := l.bucket(key)
+	// reserve the token now so waiters are served in order
+	b.tokens--
+	delay := l.wait(b.tokens + 1)
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// give the reserved token back
+		l.mu.Lock()
+		l.bucket(key).tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// wait returns how long until a bucket with tokens has a whole token
+func (l *Limiter) wait(tokens float64) time.Duration {
+	if tokens >= 1 {
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - tokens) / l.limit.rate() * float64(time.Second)))
+}
+
+// bucket returns the refilled bucket of key, l.mu must be held
+func (l *Limiter) bucket(key string) *bucket {
+	now := l.now()
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.limit.burst(), last: now}
+		l.buckets[key] = b
+		return b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(l.limit.burst(), b.tokens+elapsed*l.limit.rate())
+	}
+	b.last = now
+	return b
+}
+
+// prune drops the buckets that have refilled, at most once a minute
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < pruneInterval {
+		return
+	}
+	l.lastPrune = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.limit.rate() >= l.limit.burst() {
+			delete(l.buckets, key)
+		}
+	}
+}
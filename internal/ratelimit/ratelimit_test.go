@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	for s, want := range map[string]Limit{
+		"30/s":   {Events: 30, Per: time.Second},
+		"30":     {Events: 30, Per: time.Second},
+		"600/m":  {Events: 600, Per: time.Minute},
+		"100/h":  {Events: 100, Per: time.Hour},
+		"5/5s":   {Events: 5, Per: 5 * time.Second},
+		" 1 / s": {Events: 1, Per: time.Second},
+		"":       {},
+		"0":      {},
+	} {
+		l, err := Parse(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, want, l, s)
+	}
+	for _, s := range []string{"fast", "-1/s", "10/week", "10/0s", "/s"} {
+		_, err := Parse(s)
+		assert.NotNil(t, err, s)
+	}
+
+	assert.Equal(t, "30/s", Limit{Events: 30, Per: time.Second}.String())
+	assert.Equal(t, "5/5s", Limit{Events: 5, Per: 5 * time.Second}.String())
+	assert.Equal(t, "unlimited", Limit{}.String())
+}
+
+func TestAllow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := New(Limit{Events: 2, Per: time.Second})
+	l.now = func() time.Time { return now }
+
+	ok, _ := l.Allow("a")
+	assert.True(t, ok)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+	ok, retry := l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retry)
+
+	// keys have their own buckets
+	ok, _ = l.Allow("b")
+	assert.True(t, ok)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+	ok, _ = l.Allow("a")
+	assert.False(t, ok)
+
+	// buckets don't fill beyond the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		ok, _ = l.Allow("a")
+		assert.True(t, ok)
+	}
+	ok, _ = l.Allow("a")
+	assert.False(t, ok)
+
+	// full buckets are pruned
+	now = now.Add(2 * time.Minute)
+	l.Allow("c")
+	assert.Len(t, l.buckets, 1)
+
+	ok, _ = New(Limit{}).Allow("a")
+	assert.True(t, ok)
+	ok, _ = (*Limiter)(nil).Allow("a")
+	assert.True(t, ok)
+}
+
+func TestBlocked(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := New(Limit{Events: 1, Per: time.Second})
+	l.now = func() time.Time { return now }
+
+	// checking doesn't take a token
+	blocked, _ := l.Blocked("a")
+	assert.False(t, blocked)
+	ok, _ := l.Allow("a")
+	assert.True(t, ok)
+
+	blocked, retry := l.Blocked("a")
+	assert.True(t, blocked)
+	assert.Equal(t, time.Second, retry)
+
+	blocked, _ = (*Limiter)(nil).Blocked("a")
+	assert.False(t, blocked)
+}
+
+func TestWait(t *testing.T) {
+	l := New(Limit{Events: 1, Per: 50 * time.Millisecond})
+	ctx := context.Background()
+
+	start := time.Now()
+	assert.Nil(t, l.Wait(ctx, "a"))
+	assert.Nil(t, l.Wait(ctx, "a"))
+	assert.Nil(t, l.Wait(ctx, "a"))
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+
+	// cancelled waits return the reserved token
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	l = New(Limit{Events: 1, Per: time.Hour})
+	assert.Nil(t, l.Wait(ctx, "a"))
+	assert.ErrorIs(t, l.Wait(cancelled, "a"), context.Canceled)
+	_, retry := l.Allow("a")
+	assert.Greater(t, retry, 59*time.Minute)
+}
@@ -0,0 +1,112 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/kha7iq/pingme/service/notifier"
+)
+
+// titleBlock is the name of the optional template block rendering the title
+const titleBlock = "title"
+
+// Data is passed to templates when rendering a message
+type Data struct {
+	Title    string
+	Message  string
+	Priority int
+	Extra    map[string]interface{}
+	Vars     map[string]string
+	Env      map[string]string
+}
+
+// Template renders the title and message of a notification. The template
+// itself renders the message, a block defined as {{define "title"}} renders
+// the title. Without a title block the title is left untouched.
+type Template struct {
+	tpl *template.Template
+}
+
+// Parse parses text as a Template
+func Parse(name, text string) (*Template, error) {
+	tpl, err := template.New(name).Funcs(Funcs()).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+	}
+	return &Template{tpl: tpl}, nil
+}
+
+// Execute renders the title and message for data
+func (t *Template) Execute(data Data) (title, message string, err error) {
+	var buf bytes.Buffer
+	if err := t.tpl.Execute(&buf, data); err != nil {
+		return "", "", fmt.Errorf("failed to render message: %w", err)
+	}
+	message = strings.TrimSpace(buf.String())
+
+	title = data.Title
+	if t.tpl.Lookup(titleBlock) != nil {
+		buf.Reset()
+		if err := t.tpl.ExecuteTemplate(&buf, titleBlock, data); err != nil {
+			return "", "", fmt.Errorf("failed to render title: %w", err)
+		}
+		title = strings.TrimSpace(buf.String())
+	}
+	return title, message, nil
+}
+
+// Apply renders msg through the template, vars and env are made available
+// to the template as .Vars and .Env
+func (t *Template) Apply(msg notifier.Message, vars, env map[string]string) (notifier.Message, error) {
+	title, body, err := t.Execute(Data{
+		Title:    msg.Title,
+		Message:  msg.Body,
+		Priority: msg.Priority,
+		Extra:    msg.Extra,
+		Vars:     vars,
+		Env:      env,
+	})
+	if err != nil {
+		return msg, err
+	}
+	msg.Title = title
+	msg.Body = body
+	return msg, nil
+}
+
+// Environ returns the process environment as a map for use as Data.Env
+func Environ() map[string]string {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// templateNotifier renders messages through a template before sending
+type templateNotifier struct {
+	next notifier.Notifier
+	tpl  *Template
+	env  map[string]string
+}
+
+// Wrap returns a Notifier rendering every message through tpl before
+// sending it with next. env is exposed to the template as .Env
+func Wrap(next notifier.Notifier, tpl *Template, env map[string]string) notifier.Notifier {
+	return &templateNotifier{next: next, tpl: tpl, env: env}
+}
+
+// Send implements notifier.Notifier
+func (n *templateNotifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	msg, err := n.tpl.Apply(msg, nil, n.env)
+	if err != nil {
+		return notifier.Result{}, err
+	}
+	return n.next.Send(ctx, msg)
+}
@@ -0,0 +1,61 @@
+package render
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kha7iq/pingme/service/notifier"
+	"github.com/stretchr/testify/assert"
+)
+
+type recorder struct {
+	msg notifier.Message
+}
+
+func (r *recorder) Send(_ context.Context, msg notifier.Message) (notifier.Result, error) {
+	r.msg = msg
+	return notifier.Result{Service: "recorder"}, nil
+}
+
+func TestExecute(t *testing.T) {
+	tpl, err := Parse("test", `{{define "title"}}[{{.Vars.env | upper}}] {{.Title}}{{end}}
+{{.Message}} on {{.Extra.host | default "unknown"}}`)
+	assert.Nil(t, err)
+
+	title, message, err := tpl.Execute(Data{
+		Title:   "deploy",
+		Message: "done",
+		Extra:   map[string]interface{}{"host": "web-1"},
+		Vars:    map[string]string{"env": "prod"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "[PROD] deploy", title)
+	assert.Equal(t, "done on web-1", message)
+}
+
+func TestExecuteKeepsTitle(t *testing.T) {
+	tpl, err := Parse("test", `{{.Message | trunc 4}} {{.Extra.missing | default "-"}}`)
+	assert.Nil(t, err)
+
+	title, message, err := tpl.Execute(Data{Title: "title", Message: "message"})
+	assert.Nil(t, err)
+	assert.Equal(t, "title", title)
+	assert.Equal(t, "mess -", message)
+}
+
+func TestParseError(t *testing.T) {
+	_, err := Parse("test", `{{.Message`)
+	assert.NotNil(t, err)
+}
+
+func TestWrap(t *testing.T) {
+	tpl, err := Parse("test", `{{.Env.HOST}}: {{.Message}}`)
+	assert.Nil(t, err)
+
+	rec := &recorder{}
+	n := Wrap(rec, tpl, map[string]string{"HOST": "web-1"})
+	_, err = n.Send(context.Background(), notifier.Message{Title: "t", Body: "up"})
+	assert.Nil(t, err)
+	assert.Equal(t, "web-1: up", rec.msg.Body)
+	assert.Equal(t, "t", rec.msg.Title)
+}
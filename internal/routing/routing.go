@@ -0,0 +1,335 @@
+// Package routing chooses the targets of messages sent without a
+// destination from a tree of rules, similar to Alertmanager routes.
+package routing
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/schedule"
+)
+
+// Route sends matching messages to its targets. Child routes are tried in
+// order, the first matching child handles the message unless it sets
+// Continue. A route whose children all fail to match handles the message
+// itself.
+type Route struct {
+	Match Match `yaml:"match"`
+	// Targets are targets or services, a route without targets inherits
+	// those of its parent
+	Targets []string `yaml:"targets"`
+	// Continue tries the following sibling routes even if this one matched
+	Continue bool    `yaml:"continue"`
+	Routes   []Route `yaml:"routes"`
+}
+
+// Match holds the conditions of a route, all of them must be met. An empty
+// Match matches every message.
+type Match struct {
+	// Source is a glob matched against the path the message was posted
+	// to, e.g. "/webhook/alertmanager" or "/hooks/*"
+	Source string `yaml:"source"`
+	// Priority compares the message priority, e.g. "2", ">=1" or "<0"
+	Priority string `yaml:"priority"`
+	// Extra requires fields of the message extra to equal the value.
+	// Nested fields are separated by dots, e.g. "commonLabels.severity"
+	Extra map[string]string `yaml:"extra"`
+	// ExtraRE requires fields of the message extra to match a regular
+	// expression, anchored at both ends
+	ExtraRE map[string]string `yaml:"extra_re"`
+	// Time is a time of day range like "09:00-17:00", ranges ending before
+	// they start wrap around midnight
+	Time string `yaml:"time"`
+	// Days restricts matching to week days or ranges of them, e.g.
+	// ["mon-fri", "sun"]
+	Days []string `yaml:"days"`
+	// Timezone for Time and Days, defaults to the local time zone
+	Timezone string `yaml:"timezone"`
+}
+
+// Message is the part of a message routes match on
+type Message struct {
+	Source   string
+	Priority int
+	Extra    map[string]interface{}
+	Time     time.Time
+}
+
+// Result is a route that handled a message
+type Result struct {
+	// Route is the position of the route in the tree, e.g. "routes[1].routes[0]"
+	Route   string
+	Targets []string
+}
+
+// Tree is a compiled list of routes
+type Tree struct {
+	routes []*route
+}
+
+type route struct {
+	name     string
+	targets  []string
+	cont     bool
+	match    *matcher
+	children []*route
+}
+
+// New compiles routes into a Tree
+func New(routes []Route) (*Tree, error) {
+	compiled, err := compile("routes", routes)
+	if err != nil {
+		return nil, err
+	}
+	return &Tree{routes: compiled}, nil
+}
+
+func compile(prefix string, routes []Route) ([]*route, error) {
+	var compiled []*route
+	for i, r := range routes {
+		name := fmt.Sprintf("%s[%d]", prefix, i)
+		m, err := newMatcher(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		children, err := compile(name+".routes", r.Routes)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, &route{
+			name:     name,
+			targets:  r.Targets,
+			cont:     r.Continue,
+			match:    m,
+			children: children,
+		})
+	}
+	return compiled, nil
+}
+
+// Match returns the routes handling msg, without any if no route matched
+func (t *Tree) Match(msg Message) []Result {
+	if t == nil {
+		return nil
+	}
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	results, _ := match(t.routes, msg, nil)
+	return results
+}
+
+// Targets returns the targets of every route handling msg, without
+// duplicates
+func (t *Tree) Targets(msg Message) []string {
+	return Targets(t.Match(msg))
+}
+
+// Targets returns the targets of results without duplicates
+func Targets(results []Result) []string {
+	var targets []string
+	for _, res := range results {
+		for _, target := range res.Targets {
+			if !contains(targets, target) {
+				targets = append(targets, target)
+			}
+		}
+	}
+	return targets
+}
+
+// match tries routes in order and reports whether any of them matched
+func match(routes []*route, msg Message, inherited []string) ([]Result, bool) {
+	var (
+		results []Result
+		matched bool
+	)
+	for _, r := range routes {
+		if !r.match.matches(msg) {
+			continue
+		}
+		matched = true
+
+		targets := r.targets
+		if len(targets) == 0 {
+			targets = inherited
+		}
+		children, ok := match(r.children, msg, targets)
+		if ok {
+			results = append(results, children...)
+		} else if len(targets) > 0 {
+			results = append(results, Result{Route: r.name, Targets: targets})
+		}
+
+		if !r.cont {
+			break
+		}
+	}
+	return results, matched
+}
+
+// matcher is a compiled Match
+type matcher struct {
+	source   string
+	priority func(int) bool
+	extra    map[string]string
+	extraRE  map[string]*regexp.Regexp
+	from, to time.Duration
+	hasTime  bool
+	days     [7]bool
+	location *time.Location
+}
+
+func newMatcher(m Match) (*matcher, error) {
+	c := &matcher{source: m.Source, extra: m.Extra, location: time.Local}
+
+	if m.Source != "" {
+		if _, err := path.Match(m.Source, ""); err != nil {
+			return nil, fmt.Errorf("invalid source %q: %w", m.Source, err)
+		}
+	}
+
+	if m.Priority != "" {
+		cmp, err := ParsePriority(m.Priority)
+		if err != nil {
+			return nil, err
+		}
+		c.priority = cmp
+	}
+
+	if len(m.ExtraRE) > 0 {
+		c.extraRE = make(map[string]*regexp.Regexp, len(m.ExtraRE))
+		for k, expr := range m.ExtraRE {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression for %s: %w", k, err)
+			}
+			c.extraRE[k] = re
+		}
+	}
+
+	var err error
+	if m.Time != "" {
+		if c.from, c.to, err = schedule.TimeRange(m.Time); err != nil {
+			return nil, err
+		}
+		c.hasTime = true
+	}
+	if c.days, err = schedule.Weekdays(m.Days); err != nil {
+		return nil, err
+	}
+
+	if m.Timezone != "" {
+		loc, err := time.LoadLocation(m.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", m.Timezone, err)
+		}
+		c.location = loc
+	}
+	return c, nil
+}
+
+// matches reports whether msg meets every condition
+func (c *matcher) matches(msg Message) bool {
+	if c.source != "" {
+		if ok, _ := path.Match(c.source, msg.Source); !ok {
+			return false
+		}
+	}
+	if c.priority != nil && !c.priority(msg.Priority) {
+		return false
+	}
+	for k, want := range c.extra {
+		v, ok := Lookup(msg.Extra, k)
+		if !ok || v != want {
+			return false
+		}
+	}
+	for k, re := range c.extraRE {
+		v, ok := Lookup(msg.Extra, k)
+		if !ok || !re.MatchString(v) {
+			return false
+		}
+	}
+
+	now := msg.Time.In(c.location)
+	if !c.days[now.Weekday()] {
+		return false
+	}
+	if c.hasTime {
+		clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+		if c.from <= c.to {
+			return clock >= c.from && clock < c.to
+		}
+		return clock >= c.from || clock < c.to
+	}
+	return true
+}
+
+// ParsePriority parses a priority condition, a number optionally prefixed
+// by one of =, !=, <, <=, > or >=
+func ParsePriority(s string) (func(int) bool, error) {
+	s = strings.TrimSpace(s)
+	op := ""
+	for _, prefix := range []string{"<=", ">=", "!=", "<", ">", "="} {
+		if strings.HasPrefix(s, prefix) {
+			op, s = prefix, strings.TrimSpace(s[len(prefix):])
+			break
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid priority condition %q", op+s)
+	}
+	switch op {
+	case "<=":
+		return func(p int) bool { return p <= n }, nil
+	case ">=":
+		return func(p int) bool { return p >= n }, nil
+	case "!=":
+		return func(p int) bool { return p != n }, nil
+	case "<":
+		return func(p int) bool { return p < n }, nil
+	case ">":
+		return func(p int) bool { return p > n }, nil
+	default:
+		return func(p int) bool { return p == n }, nil
+	}
+}
+
+// Lookup returns the value of a dotted key in extra formatted as string
+func Lookup(extra map[string]interface{}, key string) (string, bool) {
+	var v interface{} = extra
+	for _, part := range strings.Split(key, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if v, ok = m[part]; !ok {
+			return "", false
+		}
+	}
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package routing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustNew(t *testing.T, routes []Route) *Tree {
+	t.Helper()
+	tree, err := New(routes)
+	assert.Nil(t, err)
+	return tree
+}
+
+func TestMatch_FirstMatchAndContinue(t *testing.T) {
+	critical := Match{Extra: map[string]string{"severity": "critical"}}
+	msg := Message{Extra: map[string]interface{}{"severity": "critical"}}
+
+	tree := mustNew(t, []Route{
+		{Match: critical, Targets: []string{"pager"}},
+		{Targets: []string{"slack"}},
+	})
+	assert.Equal(t, []string{"pager"}, tree.Targets(msg))
+	assert.Equal(t, []string{"slack"}, tree.Targets(Message{}))
+
+	tree = mustNew(t, []Route{
+		{Match: critical, Targets: []string{"pager", "slack"}, Continue: true},
+		{Targets: []string{"slack"}},
+	})
+	results := tree.Match(msg)
+	assert.Equal(t, []Result{
+		{Route: "routes[0]", Targets: []string{"pager", "slack"}},
+		{Route: "routes[1]", Targets: []string{"slack"}},
+	}, results)
+	assert.Equal(t, []string{"pager", "slack"}, tree.Targets(msg))
+}
+
+func TestMatch_Nested(t *testing.T) {
+	tree := mustNew(t, []Route{{
+		Match:   Match{Source: "/webhook/*"},
+		Targets: []string{"slack"},
+		Routes: []Route{
+			{Match: Match{Priority: ">=1"}, Targets: []string{"pager"}},
+			{Match: Match{ExtraRE: map[string]string{"event": "push|release"}}},
+		},
+	}})
+
+	assert.Equal(t, []Result{{Route: "routes[0].routes[0]", Targets: []string{"pager"}}},
+		tree.Match(Message{Source: "/webhook/github", Priority: 2}))
+	assert.Equal(t, []Result{{Route: "routes[0].routes[1]", Targets: []string{"slack"}}},
+		tree.Match(Message{Source: "/webhook/github", Extra: map[string]interface{}{"event": "push"}}))
+	assert.Equal(t, []Result{{Route: "routes[0]", Targets: []string{"slack"}}},
+		tree.Match(Message{Source: "/webhook/github", Extra: map[string]interface{}{"event": "pushes"}}))
+	assert.Empty(t, tree.Match(Message{Source: "/hooks/cron", Priority: 2}))
+}
+
+func TestMatch_Time(t *testing.T) {
+	tree := mustNew(t, []Route{
+		{Match: Match{Time: "22:00-07:00", Timezone: "UTC"}, Targets: []string{"night"}},
+		{Match: Match{Days: []string{"Sat", "sun"}, Timezone: "UTC"}, Targets: []string{"weekend"}},
+		{Match: Match{Days: []string{"mon-wed"}, Timezone: "UTC"}, Targets: []string{"early"}},
+		{Targets: []string{"day"}},
+	})
+
+	at := func(s string) Message {
+		ts, err := time.Parse(time.RFC3339, s)
+		assert.Nil(t, err)
+		return Message{Time: ts}
+	}
+	assert.Equal(t, []string{"night"}, tree.Targets(at("2024-05-06T23:30:00Z")))
+	assert.Equal(t, []string{"night"}, tree.Targets(at("2024-05-06T06:59:00Z")))
+	assert.Equal(t, []string{"early"}, tree.Targets(at("2024-05-06T07:00:00Z")))
+	assert.Equal(t, []string{"day"}, tree.Targets(at("2024-05-09T07:00:00Z")))
+	assert.Equal(t, []string{"weekend"}, tree.Targets(at("2024-05-04T12:00:00Z")))
+
+	tokyo := mustNew(t, []Route{{Match: Match{Time: "22:00-07:00", Timezone: "Asia/Tokyo"}, Targets: []string{"night"}}})
+	assert.Equal(t, []string{"night"}, tokyo.Targets(at("2024-05-06T14:30:00Z")))
+	assert.Empty(t, tokyo.Targets(at("2024-05-06T23:30:00Z")))
+}
+
+func TestNew_Invalid(t *testing.T) {
+	for _, m := range []Match{
+		{Source: "[/webhook"},
+		{Priority: "high"},
+		{ExtraRE: map[string]string{"event": "("}},
+		{Time: "22:00"},
+		{Time: "22:00-25:00"},
+		{Days: []string{"monday"}},
+		{Timezone: "Mars/Olympus"},
+	} {
+		_, err := New([]Route{{Targets: []string{"slack"}, Routes: []Route{{Match: m}}}})
+		assert.ErrorContains(t, err, "routes[0].routes[0]", m)
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	tests := []struct {
+		cond string
+		in   []int
+		out  []int
+	}{
+		{"2", []int{2}, []int{1, 3}},
+		{"= 2", []int{2}, []int{1}},
+		{"!=0", []int{-1, 1}, []int{0}},
+		{">=1", []int{1, 2}, []int{0}},
+		{">1", []int{2}, []int{1}},
+		{"<0", []int{-2, -1}, []int{0}},
+		{"<=0", []int{-1, 0}, []int{1}},
+	}
+	for _, tt := range tests {
+		cmp, err := ParsePriority(tt.cond)
+		assert.Nil(t, err)
+		for _, p := range tt.in {
+			assert.True(t, cmp(p), "%s %d", tt.cond, p)
+		}
+		for _, p := range tt.out {
+			assert.False(t, cmp(p), "%s %d", tt.cond, p)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	extra := map[string]interface{}{
+		"commonLabels": map[string]interface{}{"severity": "critical"},
+		"count":        float64(3),
+		"firing":       true,
+		"empty":        nil,
+	}
+
+	v, ok := Lookup(extra, "commonLabels.severity")
+	assert.True(t, ok)
+	assert.Equal(t, "critical", v)
+	v, _ = Lookup(extra, "count")
+	assert.Equal(t, "3", v)
+	v, _ = Lookup(extra, "firing")
+	assert.Equal(t, "true", v)
+
+	for _, key := range []string{"empty", "missing", "count.value", "commonLabels.team"} {
+		_, ok = Lookup(extra, key)
+		assert.False(t, ok, key)
+	}
+}
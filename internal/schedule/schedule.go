@@ -0,0 +1,240 @@
+// Package schedule decides when a target may be notified from weekly
+// hours in a time zone and a file of holiday dates.
+package schedule
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schedule are the hours a target may be notified in
+type Schedule struct {
+	// Timezone for Hours and Holidays, defaults to the local time zone
+	Timezone string `yaml:"timezone"`
+	// Hours are the weekly open hours, an empty list is always open
+	// except on holidays
+	Hours []Hours `yaml:"hours"`
+	// Holidays is a file of dates like "2024-12-25", one per line, that
+	// are closed all day. Relative paths are relative to the config file
+	Holidays string `yaml:"holidays"`
+}
+
+// Hours opens a schedule on days at a time of day
+type Hours struct {
+	// Days are week days or ranges of them, e.g. ["mon-fri", "sun"], all
+	// days if empty
+	Days []string `yaml:"days"`
+	// Time is a time of day range like "09:00-17:00", ranges ending
+	// before they start continue into the next day. All day if empty
+	Time string `yaml:"time"`
+}
+
+// Calendar is a compiled Schedule
+type Calendar struct {
+	location *time.Location
+	hours    []hours
+	holidays map[string]bool
+}
+
+// hours is a compiled Hours, the range wraps into the next day if to is
+// not after from
+type hours struct {
+	days     [7]bool
+	from, to time.Duration
+}
+
+// dateLayout is the layout of holiday dates
+const dateLayout = "2006-01-02"
+
+// maxDays limits the search for the next opening
+const maxDays = 400
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// New compiles s, reading the holidays file relative to dir
+func New(s Schedule, dir string) (*Calendar, error) {
+	c := &Calendar{location: time.Local}
+
+	if s.Timezone != "" {
+		loc, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", s.Timezone, err)
+		}
+		c.location = loc
+	}
+
+	for _, h := range s.Hours {
+		compiled, err := newHours(h)
+		if err != nil {
+			return nil, err
+		}
+		c.hours = append(c.hours, compiled)
+	}
+
+	if s.Holidays != "" {
+		file := s.Holidays
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		holidays, err := readHolidays(file)
+		if err != nil {
+			return nil, err
+		}
+		c.holidays = holidays
+	}
+	return c, nil
+}
+
+func newHours(h Hours) (hours, error) {
+	var compiled hours
+	var err error
+	if compiled.days, err = Weekdays(h.Days); err != nil {
+		return compiled, err
+	}
+	if h.Time != "" {
+		compiled.from, compiled.to, err = TimeRange(h.Time)
+	}
+	return compiled, err
+}
+
+// Weekdays parses week days or ranges of them, e.g. ["mon-fri", "sun"],
+// indexed by time.Weekday. Empty days are all days
+func Weekdays(days []string) ([7]bool, error) {
+	var parsed [7]bool
+	if len(days) == 0 {
+		for i := range parsed {
+			parsed[i] = true
+		}
+	}
+	for _, d := range days {
+		first, last, isRange := strings.Cut(d, "-")
+		from, ok := weekdays[strings.ToLower(strings.TrimSpace(first))]
+		to, ok2 := weekdays[strings.ToLower(strings.TrimSpace(last))]
+		if !isRange {
+			to, ok2 = from, ok
+		}
+		if !ok || !ok2 {
+			return parsed, fmt.Errorf("invalid days %q, expected mon, tue, wed, thu, fri, sat, sun or a range like mon-fri", d)
+		}
+		for day := from; ; day = (day + 1) % 7 {
+			parsed[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+	return parsed, nil
+}
+
+// TimeRange parses a time of day range like "09:00-17:00" into the time
+// since midnight of its start and end
+func TimeRange(s string) (from, to time.Duration, err error) {
+	first, last, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid time %q, expected HH:MM-HH:MM", s)
+	}
+	if from, err = parseClock(first); err != nil {
+		return 0, 0, err
+	}
+	if to, err = parseClock(last); err != nil {
+		return 0, 0, err
+	}
+	return from, to, nil
+}
+
+// parseClock parses a time of day like "09:30"
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// readHolidays reads the dates of a holidays file, text after the date
+// and lines starting with # are ignored
+func readHolidays(file string) (map[string]bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read holidays: %w", err)
+	}
+	defer f.Close()
+
+	holidays := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if _, err := time.Parse(dateLayout, fields[0]); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid date %q, expected YYYY-MM-DD", file, line, fields[0])
+		}
+		holidays[fields[0]] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read holidays: %w", err)
+	}
+	return holidays, nil
+}
+
+// Open reports whether the schedule is open at t
+func (c *Calendar) Open(t time.Time) bool {
+	t = t.In(c.location)
+	if c.holidays[t.Format(dateLayout)] {
+		return false
+	}
+	if len(c.hours) == 0 {
+		return true
+	}
+
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	day, yesterday := t.Weekday(), (t.Weekday()+6)%7
+	for _, h := range c.hours {
+		if h.from < h.to {
+			if h.days[day] && clock >= h.from && clock < h.to {
+				return true
+			}
+			continue
+		}
+		// the range continues into the next day
+		if (h.days[day] && clock >= h.from) || (h.days[yesterday] && clock < h.to) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns the first time from t on the schedule is open, false if it
+// doesn't open within a year
+func (c *Calendar) Next(t time.Time) (time.Time, bool) {
+	if c.Open(t) {
+		return t, true
+	}
+
+	local := t.In(c.location)
+	for i := 0; i < maxDays; i++ {
+		// the schedule can only open at midnight or the start of hours
+		midnight := time.Date(local.Year(), local.Month(), local.Day()+i, 0, 0, 0, 0, c.location)
+		candidates := []time.Time{midnight}
+		for _, h := range c.hours {
+			candidates = append(candidates, time.Date(midnight.Year(), midnight.Month(), midnight.Day(),
+				int(h.from/time.Hour), int(h.from%time.Hour/time.Minute), 0, 0, c.location))
+		}
+		sort.Slice(candidates, func(a, b int) bool { return candidates[a].Before(candidates[b]) })
+		for _, candidate := range candidates {
+			if candidate.After(t) && c.Open(candidate) {
+				return candidate, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
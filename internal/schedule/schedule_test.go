@@ -0,0 +1,104 @@
+package schedule
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustNew(t *testing.T, s Schedule, dir string) *Calendar {
+	t.Helper()
+	c, err := New(s, dir)
+	assert.Nil(t, err)
+	return c
+}
+
+func TestCalendar_Open(t *testing.T) {
+	c := mustNew(t, Schedule{
+		Timezone: "Europe/Berlin",
+		Hours: []Hours{
+			{Days: []string{"mon-fri"}, Time: "09:00-17:00"},
+			{Days: []string{"sat"}, Time: "22:00-02:00"},
+		},
+	}, "")
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	at := func(day int, hour, minute int) time.Time {
+		// 2024-05-06 is a Monday
+		return time.Date(2024, 5, 6+day, hour, minute, 0, 0, berlin)
+	}
+
+	assert.True(t, c.Open(at(0, 9, 0)))
+	assert.True(t, c.Open(at(4, 16, 59)))
+	assert.False(t, c.Open(at(0, 17, 0)))
+	assert.False(t, c.Open(at(0, 8, 59)))
+	assert.True(t, c.Open(time.Date(2024, 5, 6, 7, 30, 0, 0, time.UTC)), "times are compared in the schedule time zone")
+	assert.True(t, c.Open(at(5, 23, 0)))
+	assert.True(t, c.Open(at(6, 1, 59)), "ranges continue into the next day")
+	assert.False(t, c.Open(at(6, 2, 0)))
+	assert.False(t, c.Open(at(6, 23, 0)))
+
+	assert.True(t, mustNew(t, Schedule{}, "").Open(at(6, 3, 0)), "no hours are always open")
+	weekend := mustNew(t, Schedule{Hours: []Hours{{Days: []string{"fri-mon"}}}}, "")
+	assert.True(t, weekend.Open(time.Date(2024, 5, 12, 12, 0, 0, 0, time.Local)))
+	assert.False(t, weekend.Open(time.Date(2024, 5, 8, 12, 0, 0, 0, time.Local)))
+}
+
+func TestCalendar_Holidays(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "holidays.txt"), []byte(`
+# public holidays
+2024-05-09 Ascension Day
+2024-12-25
+`), 0o600))
+
+	c := mustNew(t, Schedule{Hours: []Hours{{Days: []string{"mon-fri"}, Time: "09:00-17:00"}}, Holidays: "holidays.txt"}, dir)
+	assert.False(t, c.Open(time.Date(2024, 5, 9, 10, 0, 0, 0, time.Local)))
+	assert.True(t, c.Open(time.Date(2024, 5, 10, 10, 0, 0, 0, time.Local)))
+
+	next, ok := c.Next(time.Date(2024, 5, 8, 18, 0, 0, 0, time.Local))
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2024, 5, 10, 9, 0, 0, 0, time.Local), next)
+
+	_, err := New(Schedule{Holidays: "missing.txt"}, dir)
+	assert.ErrorContains(t, err, "failed to read holidays")
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "bad.txt"), []byte("25.12.2024\n"), 0o600))
+	_, err = New(Schedule{Holidays: "bad.txt"}, dir)
+	assert.ErrorContains(t, err, "bad.txt:1: invalid date")
+}
+
+func TestCalendar_Next(t *testing.T) {
+	c := mustNew(t, Schedule{Hours: []Hours{
+		{Days: []string{"mon-fri"}, Time: "09:00-17:00"},
+		{Days: []string{"sat"}, Time: "22:00-02:00"},
+	}}, "")
+
+	// Monday evening opens Tuesday morning
+	next, ok := c.Next(time.Date(2024, 5, 6, 20, 15, 0, 0, time.Local))
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2024, 5, 7, 9, 0, 0, 0, time.Local), next)
+
+	// Friday evening opens Saturday night
+	next, _ = c.Next(time.Date(2024, 5, 10, 17, 0, 0, 0, time.Local))
+	assert.Equal(t, time.Date(2024, 5, 11, 22, 0, 0, 0, time.Local), next)
+
+	// open times are returned as they are
+	now := time.Date(2024, 5, 6, 10, 0, 0, 0, time.Local)
+	next, _ = c.Next(now)
+	assert.Equal(t, now, next)
+}
+
+func TestNew_Invalid(t *testing.T) {
+	for _, s := range []Schedule{
+		{Timezone: "Mars/Olympus"},
+		{Hours: []Hours{{Days: []string{"mon-xyz"}}}},
+		{Hours: []Hours{{Days: []string{"weekdays"}}}},
+		{Hours: []Hours{{Time: "09:00"}}},
+		{Hours: []Hours{{Time: "9am-5pm"}}},
+	} {
+		_, err := New(s, "")
+		assert.NotNil(t, err, "%+v", s)
+	}
+}
@@ -0,0 +1,155 @@
+// Package secret hashes and verifies credentials of the webhook server, so
+// config files and environment variables don't need to hold them in
+// plaintext.
+//
+// A stored secret is one of:
+//
+//	sha256:<hex>                           SHA-256 of the secret, for random API keys
+//	$2a$..., $2b$..., $2y$...              bcrypt, for passwords
+//	$argon2id$v=19$m=...,t=...,p=...$...   argon2id in PHC format, for passwords
+//	anything else                          the plaintext secret
+package secret
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Algorithms supported by Hash
+const (
+	SHA256   = "sha256"
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+// argon2id parameters of new hashes, the second recommendation of RFC 9106
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+	argonKeyLen  = 32
+	argonSaltLen = 16
+)
+
+// Hash hashes secret with algorithm, the result can be passed to Verify
+func Hash(algorithm, secret string) (string, error) {
+	switch algorithm {
+	case SHA256:
+		sum := sha256.Sum256([]byte(secret))
+		return "sha256:" + hex.EncodeToString(sum[:]), nil
+	case Bcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	case Argon2id:
+		salt := make([]byte, argonSaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(secret), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argonMemory, argonTime, argonThreads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	default:
+		return "", fmt.Errorf("unsupported algorithm %q, expected %s, %s or %s", algorithm, SHA256, Bcrypt, Argon2id)
+	}
+}
+
+// Verify reports whether provided matches the stored secret, hashed or in
+// plaintext. Comparisons take constant time, malformed hashes never match
+func Verify(stored, provided string) bool {
+	switch {
+	case strings.HasPrefix(stored, "sha256:"):
+		want, err := hex.DecodeString(strings.TrimPrefix(stored, "sha256:"))
+		if err != nil {
+			return false
+		}
+		sum := sha256.Sum256([]byte(provided))
+		return subtle.ConstantTimeCompare(sum[:], want) == 1
+	case isBcrypt(stored):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(provided)) == nil
+	case strings.HasPrefix(stored, "$argon2id$"):
+		p, err := parseArgon2id(stored)
+		if err != nil {
+			return false
+		}
+		key := argon2.IDKey([]byte(provided), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+		return subtle.ConstantTimeCompare(key, p.key) == 1
+	default:
+		// hashing first keeps the comparison independent of the lengths
+		a, b := sha256.Sum256([]byte(stored)), sha256.Sum256([]byte(provided))
+		return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+	}
+}
+
+// Validate returns an error if stored looks like a hash but is malformed,
+// so typos are reported when the config is loaded instead of failing
+// every login
+func Validate(stored string) error {
+	switch {
+	case strings.HasPrefix(stored, "sha256:"):
+		sum, err := hex.DecodeString(strings.TrimPrefix(stored, "sha256:"))
+		if err != nil || len(sum) != sha256.Size {
+			return errors.New("invalid sha256 hash, expected sha256:<64 hex digits>")
+		}
+	case isBcrypt(stored):
+		if _, err := bcrypt.Cost([]byte(stored)); err != nil {
+			return fmt.Errorf("invalid bcrypt hash: %w", err)
+		}
+	case strings.HasPrefix(stored, "$argon2id$"):
+		if _, err := parseArgon2id(stored); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isBcrypt(s string) bool {
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
+
+// argon2idHash is a parsed argon2id hash
+type argon2idHash struct {
+	time, memory uint32
+	threads      uint8
+	salt, key    []byte
+}
+
+// parseArgon2id parses "$argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>"
+func parseArgon2id(s string) (*argon2idHash, error) {
+	invalid := errors.New("invalid argon2id hash, expected $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>")
+
+	parts := strings.Split(s, "$")
+	if len(parts) != 6 {
+		return nil, invalid
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, invalid
+	}
+	var p argon2idHash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return nil, invalid
+	}
+	if p.time == 0 || p.threads == 0 {
+		return nil, invalid
+	}
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, invalid
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(p.key) == 0 {
+		return nil, invalid
+	}
+	return &p, nil
+}
@@ -0,0 +1,47 @@
+package secret
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashVerify(t *testing.T) {
+	for _, algorithm := range []string{SHA256, Bcrypt, Argon2id} {
+		hash, err := Hash(algorithm, "s3cret")
+		assert.Nil(t, err, algorithm)
+		assert.NotContains(t, hash, "s3cret")
+		assert.Nil(t, Validate(hash), algorithm)
+
+		assert.True(t, Verify(hash, "s3cret"), algorithm)
+		assert.False(t, Verify(hash, "s3cret "), algorithm)
+		assert.False(t, Verify(hash, ""), algorithm)
+	}
+
+	_, err := Hash("md5", "s3cret")
+	assert.NotNil(t, err)
+}
+
+func TestVerify_Known(t *testing.T) {
+	// printf s3cret | sha256sum
+	assert.True(t, Verify("sha256:1ec1c26b50d5d3c58d9583181af8076655fe00756bf7285940ba3670f99fcba0", "s3cret"))
+	assert.True(t, Verify("plain", "plain"))
+	assert.False(t, Verify("plain", "plain2"))
+	assert.False(t, Verify("", "x"))
+}
+
+func TestValidate(t *testing.T) {
+	assert.Nil(t, Validate("plaintext"))
+	for _, s := range []string{
+		"sha256:abc",
+		"sha256:" + strings.Repeat("z", 64),
+		"$2b$10$short",
+		"$argon2id$v=19$m=65536,t=3,p=4$c2FsdA",
+		"$argon2id$v=18$m=65536,t=3,p=4$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=0,p=4$c2FsdA$a2V5",
+	} {
+		assert.NotNil(t, Validate(s), s)
+		assert.False(t, Verify(s, ""), s)
+	}
+}
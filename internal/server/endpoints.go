@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/kha7iq/pingme/internal/config"
+	"github.com/kha7iq/pingme/internal/sources"
+)
+
+// Endpoint is a path accepting the webhook payloads of another tool
+type Endpoint struct {
+	// Path is /webhook/<name> for integrations and /hooks/<name> for hooks
+	Path string
+	// Name of the integration or hook
+	Name   string
+	Source sources.Source
+	// Targets configured for the integration
+	Targets []string
+	// Verified endpoints check the sender with their own secret instead
+	// of the webhook authentication
+	Verified bool
+	// Hook endpoints keep the destination chosen by their mapping
+	Hook bool
+}
+
+// Endpoints returns the integrations and the hooks of cfg. Without verify
+// the sources accept payloads without checking the integration secret
+func Endpoints(cfg *config.Config, verify bool) ([]Endpoint, error) {
+	github := cfg.Integration("github")
+	gitlab := cfg.Integration("gitlab")
+	if !verify {
+		github.Secret, gitlab.Secret = "", ""
+	}
+	integrations := []struct {
+		name   string
+		source sources.Source
+		secret string
+	}{
+		// Native payloads of other tools
+		{"alertmanager", sources.Alertmanager, ""},
+		{"grafana", sources.Grafana, ""},
+		// Repository events, verified with the secret of the integration
+		{"github", sources.GitHub(github.Secret, github.Events), github.Secret},
+		{"gitlab", sources.GitLab(gitlab.Secret, gitlab.Events), gitlab.Secret},
+	}
+
+	var endpoints []Endpoint
+	for _, in := range integrations {
+		endpoints = append(endpoints, Endpoint{
+			Path:     "/webhook/" + in.name,
+			Name:     in.name,
+			Source:   in.source,
+			Targets:  cfg.Integration(in.name).Targets,
+			Verified: in.secret != "",
+		})
+	}
+
+	// Payloads mapped by hooks from the config file
+	if cfg == nil {
+		return endpoints, nil
+	}
+	names := make([]string, 0, len(cfg.Hooks))
+	for name := range cfg.Hooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		source, err := sources.Map(cfg.Hooks[name])
+		if err != nil {
+			return nil, fmt.Errorf("hook %s: %w", name, err)
+		}
+		endpoints = append(endpoints, Endpoint{
+			Path:   "/hooks/" + name,
+			Name:   name,
+			Source: source,
+			Hook:   true,
+		})
+	}
+	return endpoints, nil
+}
+
+// FindEndpoint returns the endpoint serving path
+func FindEndpoint(endpoints []Endpoint, path string) (Endpoint, bool) {
+	for _, e := range endpoints {
+		if e.Path == path {
+			return e, true
+		}
+	}
+	return Endpoint{}, false
+}
@@ -4,33 +4,146 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/kha7iq/pingme/internal/config"
+	"github.com/kha7iq/pingme/internal/dispatcher"
+	"github.com/kha7iq/pingme/internal/escalation"
 	"github.com/kha7iq/pingme/internal/handlers"
+	"github.com/kha7iq/pingme/internal/idempotency"
+	"github.com/kha7iq/pingme/internal/metrics"
 	"github.com/kha7iq/pingme/internal/middleware"
+	"github.com/kha7iq/pingme/internal/queue"
+	"github.com/kha7iq/pingme/internal/ratelimit"
 )
 
+// Options holds optional server settings
+type Options struct {
+	// Async answers webhooks with 202 Accepted and delivers them in the
+	// background
+	Async bool
+	// Workers is the number of concurrent deliveries in async mode
+	Workers int
+	// QueueSize is the number of messages that can wait for delivery
+	QueueSize int
+	// DataDir persists queued messages so they survive restarts, it
+	// implies Async
+	DataDir string
+	// Metrics exposes Prometheus metrics at /metrics
+	Metrics bool
+	// MetricsToken protects /metrics with a bearer token instead of the
+	// webhook authentication
+	MetricsToken string
+	// TLSCert and TLSKey are PEM files serving HTTPS, they are reloaded
+	// when they change
+	TLSCert string
+	TLSKey  string
+	// ClientCA is a PEM file of CAs verifying client certificates for the
+	// mtls auth method
+	ClientCA string
+	// RateLimit limits the requests of every client, e.g. "10/s" or
+	// "600/m", clients are identified by API key, JWT, certificate or IP
+	RateLimit string
+	// IdempotencyTTL is how long responses of requests with an idempotency
+	// key are replayed, 0 disables idempotency keys
+	IdempotencyTTL time.Duration
+}
+
 // Server represents the HTTP server
 type Server struct {
 	httpServer *http.Server
 	host       string
 	port       string
+	config     *config.Config
+	options    Options
+	dispatcher *dispatcher.Dispatcher
+	queue      *queue.Queue
+	// escalations is set if the config file has escalation policies
+	escalations *escalation.Manager
+	endpoints   []Endpoint
+	// verified are the paths of integrations verifying the sender with
+	// their own secret and the prefix of signed acknowledgment links, they
+	// bypass the webhook authentication
+	verified []string
+	// limiter limits the requests of every client, nil if unlimited
+	limiter *ratelimit.Limiter
 }
 
-// New creates a new server instance
-func New(host, port string) *Server {
+// New creates a new server instance, named targets are resolved from cfg
+func New(host, port string, cfg *config.Config, opts Options) *Server {
 	return &Server{
-		host: host,
-		port: port,
+		host:       host,
+		port:       port,
+		config:     cfg,
+		options:    opts,
+		dispatcher: dispatcher.New(cfg),
 	}
 }
 
 // Start initializes and starts the HTTP server with graceful shutdown
 func (s *Server) Start() error {
+	if os.Getenv("PINGME_AUTH_METHOD") == "jwt" && s.config.JWT == nil {
+		return fmt.Errorf("PINGME_AUTH_METHOD=jwt requires the jwt section in the config file")
+	}
+	if (s.options.TLSCert == "") != (s.options.TLSKey == "") {
+		return fmt.Errorf("--tls-cert and --tls-key must be set together")
+	}
+	if s.options.ClientCA != "" && s.options.TLSCert == "" {
+		return fmt.Errorf("--client-ca requires --tls-cert and --tls-key")
+	}
+	if os.Getenv("PINGME_AUTH_METHOD") == "mtls" && s.options.ClientCA == "" {
+		return fmt.Errorf("PINGME_AUTH_METHOD=mtls requires --client-ca")
+	}
+	if s.options.Metrics && s.options.MetricsToken == "" && !s.authEnabled() {
+		return fmt.Errorf("--metrics requires --metrics-token or the webhook authentication")
+	}
+
+	// Delivery queue for async mode
+	if s.options.Async || s.options.DataDir != "" {
+		var store queue.Store
+		if s.options.DataDir != "" {
+			fileStore, err := queue.OpenFileStore(s.options.DataDir)
+			if err != nil {
+				return err
+			}
+			store = fileStore
+		}
+		s.queue = queue.New(s.dispatcher, s.options.Workers, s.options.QueueSize, store)
+	}
+
+	endpoints, err := Endpoints(s.config, true)
+	if err != nil {
+		return err
+	}
+	s.endpoints = endpoints
+
+	limit, err := ratelimit.Parse(s.options.RateLimit)
+	if err != nil {
+		return err
+	}
+	if !limit.Unlimited() {
+		log.Printf("Limiting requests to %s per client", limit)
+		s.limiter = ratelimit.New(limit)
+	}
+
+	if s.config.Escalations != nil && len(s.config.Escalations.Policies) > 0 {
+		if s.escalations, err = escalation.New(s.dispatcher, s.config); err != nil {
+			return err
+		}
+	}
+
+	var certs *certReloader
+	if s.options.TLSCert != "" {
+		if certs, err = newCertReloader(s.options.TLSCert, s.options.TLSKey, s.options.ClientCA); err != nil {
+			return err
+		}
+	}
+
 	// Create router/mux
 	mux := http.NewServeMux()
 
@@ -40,6 +153,24 @@ func (s *Server) Start() error {
 	// Apply middleware
 	handler := s.applyMiddleware(mux)
 
+	// Base context for all requests, cancelled when the shutdown grace
+	// period is over so in-flight deliveries are aborted
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
+	// Buffer digests and deferred messages of target schedules
+	s.dispatcher.Start(baseCtx)
+	if s.escalations != nil {
+		s.escalations.Start(baseCtx)
+	}
+
+	// Start delivering, replaying messages left from the last run
+	if s.queue != nil {
+		if err := s.queue.Start(baseCtx); err != nil {
+			return err
+		}
+	}
+
 	// Configure HTTP server
 	addr := fmt.Sprintf("%s:%s", s.host, s.port)
 	s.httpServer = &http.Server{
@@ -48,6 +179,16 @@ func (s *Server) Start() error {
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
+	}
+
+	// HTTPS with certificates reloaded when the files change
+	scheme := "http"
+	if certs != nil {
+		s.httpServer.TLSConfig = certs.TLSConfig()
+		scheme = "https"
 	}
 
 	// Channel to listen for errors from the server
@@ -56,7 +197,11 @@ func (s *Server) Start() error {
 	// Start HTTP server in a goroutine
 	go func() {
 		log.Printf("Starting webhook server on %s", addr)
-		log.Printf("POST webhooks to: http://%s/webhook", addr)
+		log.Printf("POST webhooks to: %s://%s/webhook", scheme, addr)
+		if scheme == "https" {
+			serverErrors <- s.httpServer.ListenAndServeTLS("", "")
+			return
+		}
 		serverErrors <- s.httpServer.ListenAndServe()
 	}()
 
@@ -78,6 +223,10 @@ func (s *Server) Start() error {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
+		// Cancel requests still running once the timeout expires
+		stop := context.AfterFunc(ctx, cancelBase)
+		defer stop()
+
 		// Attempt graceful shutdown
 		if err := s.httpServer.Shutdown(ctx); err != nil {
 			log.Printf("Graceful shutdown failed, forcing close: %v", err)
@@ -87,12 +236,38 @@ func (s *Server) Start() error {
 			return fmt.Errorf("graceful shutdown error: %w", err)
 		}
 
+		// Deliver messages still waiting in the queue, with a data
+		// directory they are kept for the next start instead, together
+		// with the messages deferred by target schedules
+		if s.queue != nil {
+			if err := s.queue.Close(ctx); err != nil {
+				log.Printf("Delivery queue not drained before shutdown: %v", err)
+			}
+		}
+
+		// Send the digests being collected instead of dropping them
+		if err := s.dispatcher.Flush(ctx); err != nil {
+			log.Printf("Digests not sent before shutdown: %v", err)
+		}
+
+		// Escalations are kept in memory and end with the server
+		if s.escalations != nil {
+			s.escalations.Close()
+		}
+
 		log.Println("Server stopped gracefully")
 	}
 
 	return nil
 }
 
+// authEnabled reports whether requests are authenticated, set by
+// PINGME_AUTH_METHOD or by API keys in the config file
+func (s *Server) authEnabled() bool {
+	method := os.Getenv("PINGME_AUTH_METHOD")
+	return (method != "" && method != "none") || (method == "" && len(s.config.APIKeys) > 0)
+}
+
 // setupRoutes configures all HTTP routes
 func (s *Server) setupRoutes(mux *http.ServeMux) {
 	// Root info endpoint
@@ -102,20 +277,88 @@ func (s *Server) setupRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/health", s.healthHandler)
 
 	// Webhook endpoint
-	webhookHandler := handlers.NewWebhookHandler()
+	var idem *idempotency.Store
+	if s.options.IdempotencyTTL > 0 {
+		idem = idempotency.New(s.options.IdempotencyTTL)
+	}
+	webhookHandler := handlers.NewWebhookHandler(s.dispatcher, s.queue, idem, s.escalations)
 	mux.Handle("/webhook", webhookHandler)
+
+	// Native payloads of other tools and hooks from the config file
+	for _, e := range s.endpoints {
+		if e.Verified {
+			s.verified = append(s.verified, e.Path)
+		}
+		if e.Hook {
+			mux.Handle(e.Path, handlers.NewHookHandler(e.Name, e.Source, webhookHandler))
+		} else {
+			mux.Handle(e.Path, handlers.NewSourceHandler(e.Name, e.Source, e.Targets, webhookHandler))
+		}
+	}
+	mux.HandleFunc("/hooks/{name}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unknown hook", http.StatusNotFound)
+	})
+
+	// Delivery status of queued messages and escalations
+	if s.queue != nil || s.escalations != nil {
+		mux.Handle("/messages/{id}", handlers.NewMessagesHandler(s.queue, s.escalations))
+	}
+
+	// Acknowledgment of escalations, the links are signed and bypass the
+	// webhook authentication
+	if s.escalations != nil {
+		mux.Handle("/messages/{id}/ack", handlers.NewAckHandler(s.escalations))
+		mux.Handle("/ack/{token}", handlers.NewAckLinkHandler(s.escalations))
+		s.verified = append(s.verified, "/ack/")
+	}
+
+	// Prometheus metrics, protected by their own token or else by the
+	// webhook authentication
+	if s.options.Metrics {
+		if s.queue != nil {
+			metrics.SetQueueDepth(func() float64 { return float64(s.queue.Len()) })
+		}
+		mux.Handle("/metrics", metrics.Handler(s.options.MetricsToken))
+		if s.options.MetricsToken != "" {
+			s.verified = append(s.verified, "/metrics")
+		}
+	}
 }
 
 // applyMiddleware wraps the handler with middleware chain
-func (s *Server) applyMiddleware(handler http.Handler) http.Handler {
+func (s *Server) applyMiddleware(mux *http.ServeMux) http.Handler {
 	// Apply middleware in reverse order (last defined = first executed)
 
+	// Route of the request for the metrics middleware
+	var handler http.Handler = mux
+	if s.options.Metrics {
+		handler = middleware.Route(mux)
+	}
+
 	// Logging middleware (outermost - logs everything)
 	handler = middleware.Logging(handler)
 
-	// Authentication middleware (if enabled via env var)
-	if os.Getenv("PINGME_AUTH_METHOD") != "" && os.Getenv("PINGME_AUTH_METHOD") != "none" {
-		handler = middleware.Auth(handler)
+	// Rate limit per client, inside the authentication which identifies
+	// the client
+	if s.limiter != nil {
+		handler = middleware.RateLimit(handler, s.limiter)
+	}
+
+	// Authentication middleware (if enabled via env var or API keys in
+	// the config file)
+	if s.authEnabled() {
+		handler = middleware.Auth(handler, s.config, s.verified...)
+
+		// Failed attempts count against the client IP, outside the
+		// authentication so guessing credentials is throttled too
+		if s.limiter != nil {
+			handler = middleware.LimitFailures(handler, s.limiter)
+		}
+	}
+
+	// Metrics middleware (also counts rejected requests)
+	if s.options.Metrics {
+		handler = middleware.Metrics(handler)
 	}
 
 	// Recovery middleware (catches panics)
@@ -137,7 +380,14 @@ func (s *Server) infoHandler(w http.ResponseWriter, r *http.Request) {
   "service": "PingMe Webhook Server",
   "endpoints": {
     "webhook": "/webhook (POST)",
-    "health": "/health (GET)"
+    "alertmanager": "/webhook/alertmanager?target=<target> (POST)",
+    "grafana": "/webhook/grafana?target=<target> (POST)",
+    "github": "/webhook/github?target=<target> (POST)",
+    "gitlab": "/webhook/gitlab?target=<target> (POST)",
+    "hooks": "/hooks/{name} (POST, configured in the config file)",
+    "health": "/health (GET)",
+    "messages": "/messages/{id} (GET, async mode and escalations)",
+    "ack": "/messages/{id}/ack (POST, escalations)"
   },
   "usage": "Configure services via environment variables, then POST JSON to /webhook"
 }`
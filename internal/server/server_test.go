@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kha7iq/pingme/internal/config"
+	"github.com/kha7iq/pingme/internal/metrics"
+	"github.com/kha7iq/pingme/internal/ratelimit"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// handler returns the routes of a server with cfg and opts behind its
+// middleware
+func handler(t *testing.T, cfg *config.Config, opts Options) http.Handler {
+	t.Helper()
+	s := New("127.0.0.1", "0", cfg, opts)
+	if opts.RateLimit != "" {
+		limit, err := ratelimit.Parse(opts.RateLimit)
+		assert.Nil(t, err)
+		s.limiter = ratelimit.New(limit)
+	}
+	mux := http.NewServeMux()
+	s.setupRoutes(mux)
+	return s.applyMiddleware(mux)
+}
+
+func serve(h http.Handler, method, path, auth string) int {
+	req := httptest.NewRequest(method, path, nil)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestMetrics_Route(t *testing.T) {
+	t.Setenv("PINGME_AUTH_METHOD", "apikey")
+	t.Setenv("PINGME_API_KEYS", "key")
+	h := handler(t, &config.Config{}, Options{Metrics: true})
+
+	requests := func(route, code string) float64 {
+		return testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues(route, http.MethodGet, code))
+	}
+	hooks, unmatched := requests("/hooks/{name}", "404"), requests("unmatched", "401")
+
+	assert.Equal(t, http.StatusNotFound, serve(h, http.MethodGet, "/hooks/deploy", "Bearer key"))
+	assert.Equal(t, http.StatusNotFound, serve(h, http.MethodGet, "/hooks/build", "Bearer key"))
+	assert.Equal(t, http.StatusUnauthorized, serve(h, http.MethodGet, "/hooks/deploy", ""))
+
+	assert.Equal(t, hooks+2, requests("/hooks/{name}", "404"))
+	assert.Equal(t, unmatched+1, requests("unmatched", "401"))
+}
+
+func TestMetrics_Auth(t *testing.T) {
+	t.Setenv("PINGME_AUTH_METHOD", "apikey")
+	t.Setenv("PINGME_API_KEYS", "key")
+
+	// the webhook authentication protects /metrics without a token
+	h := handler(t, &config.Config{}, Options{Metrics: true})
+	assert.Equal(t, http.StatusUnauthorized, serve(h, http.MethodGet, "/metrics", ""))
+	assert.Equal(t, http.StatusOK, serve(h, http.MethodGet, "/metrics", "Bearer key"))
+
+	// the token replaces it
+	h = handler(t, &config.Config{}, Options{Metrics: true, MetricsToken: "scrape"})
+	assert.Equal(t, http.StatusUnauthorized, serve(h, http.MethodGet, "/metrics", "Bearer key"))
+	assert.Equal(t, http.StatusOK, serve(h, http.MethodGet, "/metrics", "Bearer scrape"))
+}
+
+func TestRateLimit_Auth(t *testing.T) {
+	t.Setenv("PINGME_AUTH_METHOD", "apikey")
+	t.Setenv("PINGME_API_KEYS", "key")
+	h := handler(t, &config.Config{}, Options{RateLimit: "2/m"})
+
+	// failed attempts use up the bucket of the client
+	assert.Equal(t, http.StatusUnauthorized, serve(h, http.MethodGet, "/hooks/deploy", "Bearer guess"))
+	assert.Equal(t, http.StatusUnauthorized, serve(h, http.MethodGet, "/hooks/deploy", "Bearer guess"))
+	assert.Equal(t, http.StatusTooManyRequests, serve(h, http.MethodGet, "/hooks/deploy", "Bearer key"))
+	assert.Equal(t, http.StatusOK, serve(h, http.MethodGet, "/health", ""))
+
+	// named keys have their own buckets, whatever their IP
+	h = handler(t, &config.Config{APIKeys: []config.APIKey{{Name: "ci", Key: "ci-key"}, {Name: "cron", Key: "cron-key"}}},
+		Options{RateLimit: "2/m"})
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusNotFound, serve(h, http.MethodGet, "/hooks/deploy", "Bearer ci-key"))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, serve(h, http.MethodGet, "/hooks/deploy", "Bearer ci-key"))
+	assert.Equal(t, http.StatusNotFound, serve(h, http.MethodGet, "/hooks/deploy", "Bearer cron-key"))
+}
+
+func TestStart_MetricsUnprotected(t *testing.T) {
+	t.Setenv("PINGME_AUTH_METHOD", "")
+	err := New("127.0.0.1", "0", &config.Config{}, Options{Metrics: true}).Start()
+	assert.EqualError(t, err, "--metrics requires --metrics-token or the webhook authentication")
+}
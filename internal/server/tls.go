@@ -0,0 +1,151 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloadInterval is how often the certificate files are checked for
+// changes, at most once per interval during handshakes
+var reloadInterval = 10 * time.Second
+
+// certReloader serves the certificate and client CAs from files and
+// reloads them when the files change, e.g. after a renewal by certbot or
+// cert-manager. Failed reloads keep the previous certificates
+type certReloader struct {
+	certFile, keyFile, caFile string
+
+	mu      sync.Mutex
+	config  *tls.Config
+	stamps  []fileStamp
+	checked time.Time
+}
+
+// fileStamp identifies a version of a file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// newCertReloader loads the certificate, key and, if caFile is set, the
+// CAs verifying client certificates
+func newCertReloader(certFile, keyFile, caFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	stamps, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if r.config, err = r.load(); err != nil {
+		return nil, err
+	}
+	r.stamps, r.checked = stamps, time.Now()
+	return r, nil
+}
+
+// TLSConfig returns the server TLS config, every handshake uses the
+// current certificates
+func (r *certReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current(), nil
+		},
+	}
+}
+
+// current returns the config of the latest certificates, reloading them
+// if the files changed
+func (r *certReloader) current() *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) < reloadInterval {
+		return r.config
+	}
+	r.checked = time.Now()
+
+	stamps, err := r.stat()
+	if err != nil {
+		log.Printf("Failed to check TLS certificates, keeping the current ones: %v", err)
+		return r.config
+	}
+	if equalStamps(stamps, r.stamps) {
+		return r.config
+	}
+	config, err := r.load()
+	if err != nil {
+		// cert and key may be replaced one after the other, the next
+		// check retries
+		log.Printf("Failed to reload TLS certificates, keeping the current ones: %v", err)
+		return r.config
+	}
+	log.Printf("Reloaded TLS certificates from %s", r.certFile)
+	r.config, r.stamps = config, stamps
+	return r.config
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	return files
+}
+
+func (r *certReloader) stat() ([]fileStamp, error) {
+	var stamps []fileStamp
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		stamps = append(stamps, fileStamp{modTime: info.ModTime(), size: info.Size()})
+	}
+	return stamps, nil
+}
+
+func equalStamps(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
+
+// load reads the files into a TLS config. Client certificates are
+// verified if given, the mtls auth method decides whether one is required
+func (r *certReloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in client CA file " + r.caFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
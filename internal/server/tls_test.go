@@ -0,0 +1,145 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newCert issues a certificate for cn signed by parent, a self-signed CA
+// if parent is nil
+func newCert(t *testing.T, cn string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{cn},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := tmpl, interface{}(key)
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writeCert writes the certificate and key of cert as PEM files
+func writeCert(t *testing.T, certFile, keyFile string, cert tls.Certificate) {
+	t.Helper()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600))
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, newCert(t, "first", nil))
+
+	certs, err := newCertReloader(certFile, keyFile, "")
+	assert.Nil(t, err)
+	serverName := func() string {
+		return certs.current().Certificates[0].Leaf.Subject.CommonName
+	}
+	assert.Equal(t, "first", serverName())
+
+	// changes are picked up once the interval has passed
+	writeCert(t, certFile, keyFile, newCert(t, "second", nil))
+	assert.Equal(t, "first", serverName())
+	certs.checked = time.Time{}
+	assert.Equal(t, "second", serverName())
+
+	// a broken file keeps the current certificate
+	assert.Nil(t, os.WriteFile(keyFile, []byte("garbage"), 0o600))
+	certs.checked = time.Time{}
+	assert.Equal(t, "second", serverName())
+
+	_, err = newCertReloader(certFile, keyFile, "")
+	assert.NotNil(t, err)
+	_, err = newCertReloader(filepath.Join(dir, "missing.pem"), keyFile, "")
+	assert.NotNil(t, err)
+}
+
+func TestCertReloader_ClientCA(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	caFile := filepath.Join(dir, "ca.pem")
+	writeCert(t, certFile, keyFile, newCert(t, "127.0.0.1", nil))
+	ca := newCert(t, "client CA", nil)
+	assert.Nil(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0o600))
+
+	certs, err := newCertReloader(certFile, keyFile, caFile)
+	assert.Nil(t, err)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.VerifiedChains) > 0 {
+			_, _ = w.Write([]byte(r.TLS.VerifiedChains[0][0].Subject.CommonName))
+		}
+	}))
+	srv.TLS = certs.TLSConfig()
+	srv.StartTLS()
+	defer srv.Close()
+
+	// get sends clientCert even if the server doesn't accept its issuer
+	get := func(clientCert *tls.Certificate) (string, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true, //nolint:gosec // the test server certificate is self-signed
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				if clientCert == nil {
+					return &tls.Certificate{}, nil
+				}
+				return clientCert, nil
+			},
+		}}}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body := make([]byte, 64)
+		n, _ := resp.Body.Read(body)
+		return string(body[:n]), nil
+	}
+
+	// client certificates are optional, but verified if given
+	name, err := get(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "", name)
+	client := newCert(t, "ci", &ca)
+	name, err = get(&client)
+	assert.Nil(t, err)
+	assert.Equal(t, "ci", name)
+	intruder := newCert(t, "intruder", nil)
+	_, err = get(&intruder)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,206 @@
+package sources
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kha7iq/pingme/internal/types"
+)
+
+// alertmanagerPayload is the webhook payload of Alertmanager, version 4
+type alertmanagerPayload struct {
+	Version           string              `json:"version"`
+	GroupKey          string              `json:"groupKey"`
+	TruncatedAlerts   int                 `json:"truncatedAlerts"`
+	Status            string              `json:"status"`
+	Receiver          string              `json:"receiver"`
+	GroupLabels       map[string]string   `json:"groupLabels"`
+	CommonLabels      map[string]string   `json:"commonLabels"`
+	CommonAnnotations map[string]string   `json:"commonAnnotations"`
+	ExternalURL       string              `json:"externalURL"`
+	Alerts            []alertmanagerAlert `json:"alerts"`
+}
+
+type alertmanagerAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+const (
+	statusFiring   = "firing"
+	statusResolved = "resolved"
+)
+
+// Alertmanager converts an Alertmanager notification to a single message
+// for the whole alert group, or with ?split=true to one message per alert.
+// The receiver name is used as target unless the query selects one.
+func Alertmanager(r *http.Request, body []byte) ([]types.WebhookRequest, error) {
+	var p alertmanagerPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if p.Version != "" && p.Version != "4" {
+		return nil, fmt.Errorf("unsupported alertmanager payload version %q", p.Version)
+	}
+	if len(p.Alerts) == 0 {
+		return nil, nil
+	}
+
+	if split, _ := strconv.ParseBool(r.URL.Query().Get("split")); split {
+		reqs := make([]types.WebhookRequest, 0, len(p.Alerts))
+		for _, a := range p.Alerts {
+			reqs = append(reqs, alertRequest(&p, a))
+		}
+		return reqs, nil
+	}
+	return []types.WebhookRequest{groupRequest(&p)}, nil
+}
+
+// groupRequest builds one message listing every alert of the group
+func groupRequest(p *alertmanagerPayload) types.WebhookRequest {
+	var firing, resolved []alertmanagerAlert
+	for _, a := range p.Alerts {
+		if a.Status == statusResolved {
+			resolved = append(resolved, a)
+		} else {
+			firing = append(firing, a)
+		}
+	}
+
+	title := fmt.Sprintf("[%s:%d] %s", strings.ToUpper(p.Status), len(p.Alerts), alertName(p.GroupLabels, p.CommonLabels))
+	if labels := formatLabels(p.GroupLabels, "alertname"); labels != "" {
+		title += " (" + labels + ")"
+	}
+
+	var b strings.Builder
+	if summary := summary(p.CommonAnnotations); summary != "" {
+		b.WriteString(summary + "\n\n")
+	}
+	writeAlerts(&b, "Firing", firing, p.CommonLabels)
+	writeAlerts(&b, "Resolved", resolved, p.CommonLabels)
+	if p.TruncatedAlerts > 0 {
+		fmt.Fprintf(&b, "%d more alerts not shown\n", p.TruncatedAlerts)
+	}
+	if p.ExternalURL != "" {
+		fmt.Fprintf(&b, "Alertmanager: %s\n", p.ExternalURL)
+	}
+
+	return types.WebhookRequest{
+		Target:   p.Receiver,
+		Title:    title,
+		Message:  strings.TrimSpace(b.String()),
+		Priority: alertPriority(p.Status, p.CommonLabels),
+		Extra: map[string]interface{}{
+			"status":            p.Status,
+			"receiver":          p.Receiver,
+			"groupLabels":       toMap(p.GroupLabels),
+			"commonLabels":      toMap(p.CommonLabels),
+			"commonAnnotations": toMap(p.CommonAnnotations),
+			"externalURL":       p.ExternalURL,
+			"firing":            len(firing),
+			"resolved":          len(resolved),
+		},
+	}
+}
+
+// alertRequest builds the message for a single alert
+func alertRequest(p *alertmanagerPayload, a alertmanagerAlert) types.WebhookRequest {
+	title := fmt.Sprintf("[%s] %s", strings.ToUpper(a.Status), alertName(a.Labels))
+	if labels := formatLabels(a.Labels, "alertname"); labels != "" {
+		title += " (" + labels + ")"
+	}
+
+	var b strings.Builder
+	writeAlert(&b, a, nil)
+
+	return types.WebhookRequest{
+		Target:   p.Receiver,
+		Title:    title,
+		Message:  strings.TrimSpace(b.String()),
+		Priority: alertPriority(a.Status, a.Labels),
+		Extra: map[string]interface{}{
+			"status":       a.Status,
+			"receiver":     p.Receiver,
+			"labels":       toMap(a.Labels),
+			"annotations":  toMap(a.Annotations),
+			"generatorURL": a.GeneratorURL,
+			"fingerprint":  a.Fingerprint,
+			"externalURL":  p.ExternalURL,
+		},
+	}
+}
+
+// writeAlerts writes a section listing alerts, labels shared by all alerts
+// of the group are left out
+func writeAlerts(b *strings.Builder, heading string, alerts []alertmanagerAlert, common map[string]string) {
+	if len(alerts) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "%s (%d):\n", heading, len(alerts))
+	for _, a := range alerts {
+		b.WriteString("- ")
+		writeAlert(b, a, common)
+	}
+	b.WriteString("\n")
+}
+
+// writeAlert writes the summary, labels, times and source link of an alert
+func writeAlert(b *strings.Builder, a alertmanagerAlert, common map[string]string) {
+	text := summary(a.Annotations)
+	if text == "" {
+		text = alertName(a.Labels)
+	}
+	b.WriteString(text + "\n")
+
+	skip := []string{"alertname"}
+	for k := range common {
+		skip = append(skip, k)
+	}
+	if labels := formatLabels(a.Labels, skip...); labels != "" {
+		fmt.Fprintf(b, "  Labels: %s\n", labels)
+	}
+	if !a.StartsAt.IsZero() {
+		fmt.Fprintf(b, "  Started: %s\n", a.StartsAt.Format(time.RFC3339))
+	}
+	if a.Status == statusResolved && !a.EndsAt.IsZero() {
+		fmt.Fprintf(b, "  Ended: %s\n", a.EndsAt.Format(time.RFC3339))
+	}
+	if a.GeneratorURL != "" {
+		fmt.Fprintf(b, "  Source: %s\n", a.GeneratorURL)
+	}
+}
+
+// alertName returns the alertname label of the first label set having one
+func alertName(labelSets ...map[string]string) string {
+	for _, labels := range labelSets {
+		if name := labels["alertname"]; name != "" {
+			return name
+		}
+	}
+	return "Alert"
+}
+
+// summary returns the summary or, lacking one, the description annotation
+func summary(annotations map[string]string) string {
+	if s := annotations["summary"]; s != "" {
+		return s
+	}
+	return annotations["description"]
+}
+
+// alertPriority raises the priority of firing critical alerts
+func alertPriority(status string, labels map[string]string) int {
+	if status == statusFiring && labels["severity"] == "critical" {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,64 @@
+package sources
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	assert.Nil(t, err)
+	return data
+}
+
+func TestAlertmanager(t *testing.T) {
+	r := httptest.NewRequest("POST", "/webhook/alertmanager", nil)
+	reqs, err := Alertmanager(r, readTestdata(t, "alertmanager.json"))
+	assert.Nil(t, err)
+	assert.Len(t, reqs, 1)
+
+	req := reqs[0]
+	assert.Equal(t, "ops-slack", req.Target)
+	assert.Equal(t, "[FIRING:2] HighCPU", req.Title)
+	assert.Equal(t, 1, req.Priority)
+	assert.Equal(t, `Firing (1):
+- CPU usage on web-1 above 90%
+  Labels: instance=web-1
+  Started: 2024-05-04T10:00:00Z
+  Source: http://prometheus:9090/graph?g0.expr=cpu
+
+Resolved (1):
+- CPU usage on web-2 above 90%
+  Labels: instance=web-2
+  Started: 2024-05-04T09:00:00Z
+  Ended: 2024-05-04T09:30:00Z
+  Source: http://prometheus:9090/graph?g0.expr=cpu
+
+Alertmanager: http://alertmanager:9093`, req.Message)
+	assert.Equal(t, 1, req.Extra["firing"])
+}
+
+func TestAlertmanager_Split(t *testing.T) {
+	r := httptest.NewRequest("POST", "/webhook/alertmanager?split=true", nil)
+	reqs, err := Alertmanager(r, readTestdata(t, "alertmanager.json"))
+	assert.Nil(t, err)
+	assert.Len(t, reqs, 2)
+
+	assert.Equal(t, "[FIRING] HighCPU (instance=web-1 job=node severity=critical)", reqs[0].Title)
+	assert.Equal(t, 1, reqs[0].Priority)
+	assert.Equal(t, "[RESOLVED] HighCPU (instance=web-2 job=node severity=critical)", reqs[1].Title)
+	assert.Equal(t, 0, reqs[1].Priority)
+	assert.Contains(t, reqs[1].Message, "Ended: 2024-05-04T09:30:00Z")
+}
+
+func TestAlertmanager_Invalid(t *testing.T) {
+	r := httptest.NewRequest("POST", "/webhook/alertmanager", nil)
+	_, err := Alertmanager(r, []byte(`{"version": "3", "alerts": []}`))
+	assert.NotNil(t, err)
+	_, err = Alertmanager(r, []byte(`not json`))
+	assert.NotNil(t, err)
+}
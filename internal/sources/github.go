@@ -0,0 +1,225 @@
+package sources
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kha7iq/pingme/internal/types"
+)
+
+// GitHubEvents are the events sent when no events are configured
+var GitHubEvents = []string{
+	"push",
+	"pull_request.opened", "pull_request.closed", "pull_request.reopened",
+	"workflow_run",
+	"release.published",
+	"issues.opened", "issues.closed", "issues.reopened",
+}
+
+type githubUser struct {
+	Login string `json:"login"`
+}
+
+type githubPayload struct {
+	Action     string     `json:"action"`
+	Sender     githubUser `json:"sender"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		HTMLURL  string `json:"html_url"`
+	} `json:"repository"`
+
+	// push
+	Ref     string `json:"ref"`
+	Deleted bool   `json:"deleted"`
+	Compare string `json:"compare"`
+	Pusher  struct {
+		Name string `json:"name"`
+	} `json:"pusher"`
+	Commits []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commits"`
+
+	PullRequest *struct {
+		Number  int        `json:"number"`
+		Title   string     `json:"title"`
+		HTMLURL string     `json:"html_url"`
+		Merged  bool       `json:"merged"`
+		User    githubUser `json:"user"`
+		Base    struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+		Head struct {
+			Ref string `json:"ref"`
+		} `json:"head"`
+	} `json:"pull_request"`
+
+	WorkflowRun *struct {
+		Name       string     `json:"name"`
+		RunNumber  int        `json:"run_number"`
+		Status     string     `json:"status"`
+		Conclusion string     `json:"conclusion"`
+		HeadBranch string     `json:"head_branch"`
+		HeadSHA    string     `json:"head_sha"`
+		Event      string     `json:"event"`
+		HTMLURL    string     `json:"html_url"`
+		Actor      githubUser `json:"actor"`
+	} `json:"workflow_run"`
+
+	Release *struct {
+		TagName    string     `json:"tag_name"`
+		Name       string     `json:"name"`
+		HTMLURL    string     `json:"html_url"`
+		Prerelease bool       `json:"prerelease"`
+		Author     githubUser `json:"author"`
+	} `json:"release"`
+
+	Issue *struct {
+		Number  int        `json:"number"`
+		Title   string     `json:"title"`
+		HTMLURL string     `json:"html_url"`
+		User    githubUser `json:"user"`
+	} `json:"issue"`
+}
+
+// GitHub returns a Source for GitHub repository and organization webhooks.
+// If secret is set the X-Hub-Signature-256 header must hold the HMAC-SHA256
+// of the body. Only the given events are sent, see GitHubEvents for the
+// default and eventFilter for the syntax. The destination is not set, it
+// comes from the query or the config file.
+func GitHub(secret string, events []string) Source {
+	filter := filterOrDefault(events, GitHubEvents)
+	return func(r *http.Request, body []byte) ([]types.WebhookRequest, error) {
+		if secret != "" && !validGitHubSignature(secret, r.Header.Get("X-Hub-Signature-256"), body) {
+			return nil, ErrUnauthorized
+		}
+
+		event := r.Header.Get("X-GitHub-Event")
+		if event == "" {
+			return nil, fmt.Errorf("missing X-GitHub-Event header")
+		}
+
+		var p githubPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+
+		req, action, ok := githubRequest(event, &p)
+		if !ok || !filter.allows(event, action) {
+			return nil, nil
+		}
+		req.Extra = map[string]interface{}{
+			"event":      event,
+			"action":     action,
+			"repository": p.Repository.FullName,
+			"sender":     p.Sender.Login,
+			"delivery":   r.Header.Get("X-GitHub-Delivery"),
+		}
+		return []types.WebhookRequest{req}, nil
+	}
+}
+
+// validGitHubSignature checks a "sha256=<hex>" signature of body
+func validGitHubSignature(secret, signature string, body []byte) bool {
+	provided, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(provided, mac.Sum(nil))
+}
+
+// githubRequest builds the message of a supported event and returns the
+// action used for filtering. Unsupported events return false
+func githubRequest(event string, p *githubPayload) (types.WebhookRequest, string, bool) {
+	repo := p.Repository.FullName
+	action := p.Action
+	var (
+		req types.WebhookRequest
+		b   strings.Builder
+	)
+
+	switch {
+	case event == "push":
+		kind, name := "branch", strings.TrimPrefix(p.Ref, "refs/heads/")
+		if strings.HasPrefix(p.Ref, "refs/tags/") {
+			kind, name = "tag", strings.TrimPrefix(p.Ref, "refs/tags/")
+		}
+		switch {
+		case p.Deleted:
+			req.Title = fmt.Sprintf("[%s] %s deleted %s %s", repo, p.Pusher.Name, kind, name)
+		case kind == "tag":
+			req.Title = fmt.Sprintf("[%s] %s pushed tag %s", repo, p.Pusher.Name, name)
+		default:
+			req.Title = fmt.Sprintf("[%s] %s pushed %s to %s", repo, p.Pusher.Name, plural(len(p.Commits), "commit"), name)
+		}
+		commits := make([]pushCommit, 0, len(p.Commits))
+		for _, c := range p.Commits {
+			commits = append(commits, pushCommit{ID: c.ID, Message: c.Message, Author: c.Author.Name})
+		}
+		writeCommits(&b, commits, len(commits))
+		writeLink(&b, "Compare", p.Compare)
+
+	case event == "pull_request" && p.PullRequest != nil:
+		pr := p.PullRequest
+		verb := p.Action
+		if verb == "closed" && pr.Merged {
+			verb = "merged"
+		}
+		req.Title = fmt.Sprintf("[%s] Pull request #%d %s: %s", repo, pr.Number, verb, pr.Title)
+		fmt.Fprintf(&b, "%s into %s by %s\n", pr.Head.Ref, pr.Base.Ref, pr.User.Login)
+		writeLink(&b, "URL", pr.HTMLURL)
+
+	case event == "workflow_run" && p.WorkflowRun != nil:
+		run := p.WorkflowRun
+		// only finished runs are reported, filtered by their conclusion
+		if p.Action != "completed" {
+			return req, "", false
+		}
+		req.Title = fmt.Sprintf("[%s] Workflow %s #%d: %s", repo, run.Name, run.RunNumber, run.Conclusion)
+		fmt.Fprintf(&b, "Branch %s (%s), triggered by %s on %s\n", run.HeadBranch, shortSHA(run.HeadSHA), run.Actor.Login, run.Event)
+		writeLink(&b, "URL", run.HTMLURL)
+		if run.Conclusion == "failure" {
+			req.Priority = 1
+		}
+		action = run.Conclusion
+
+	case event == "release" && p.Release != nil:
+		rel := p.Release
+		name := rel.Name
+		if name == "" {
+			name = rel.TagName
+		}
+		kind := "Release"
+		if rel.Prerelease {
+			kind = "Pre-release"
+		}
+		req.Title = fmt.Sprintf("[%s] %s %s %s", repo, kind, name, p.Action)
+		fmt.Fprintf(&b, "Tag %s by %s\n", rel.TagName, rel.Author.Login)
+		writeLink(&b, "URL", rel.HTMLURL)
+
+	case event == "issues" && p.Issue != nil:
+		issue := p.Issue
+		req.Title = fmt.Sprintf("[%s] Issue #%d %s: %s", repo, issue.Number, p.Action, issue.Title)
+		fmt.Fprintf(&b, "Opened by %s\n", issue.User.Login)
+		writeLink(&b, "URL", issue.HTMLURL)
+
+	default:
+		return req, "", false
+	}
+
+	req.Message = strings.TrimSpace(b.String())
+	if req.Message == "" {
+		req.Message = req.Title
+	}
+	return req, action, true
+}
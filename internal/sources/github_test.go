@@ -0,0 +1,111 @@
+package sources
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func githubRequestFor(event, secret string, body []byte) *http.Request {
+	r := httptest.NewRequest("POST", "/webhook/github", nil)
+	r.Header.Set("X-GitHub-Event", event)
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		r.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return r
+}
+
+func TestGitHub_Push(t *testing.T) {
+	body := readTestdata(t, "github_push.json")
+	reqs, err := GitHub("s3cret", nil)(githubRequestFor("push", "s3cret", body), body)
+	assert.Nil(t, err)
+	assert.Len(t, reqs, 1)
+
+	assert.Equal(t, "[acme/api] alice pushed 2 commits to main", reqs[0].Title)
+	assert.Equal(t, `- a1b2c3d Fix login redirect (Alice)
+- 0d1a26e Bump version (Bob)
+Compare: https://github.com/acme/api/compare/6113728f27ae...0d1a26e67d8f`, reqs[0].Message)
+	assert.Equal(t, "push", reqs[0].Extra["event"])
+}
+
+func TestGitHub_Signature(t *testing.T) {
+	body := readTestdata(t, "github_push.json")
+	source := GitHub("s3cret", nil)
+
+	_, err := source(githubRequestFor("push", "wrong", body), body)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+
+	_, err = source(githubRequestFor("push", "", body), body)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+
+	r := githubRequestFor("push", "", body)
+	r.Header.Set("X-Hub-Signature-256", "sha256=zz")
+	_, err = source(r, body)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+}
+
+func TestGitHub_Events(t *testing.T) {
+	pr := []byte(`{
+		"action": "closed",
+		"repository": {"full_name": "acme/api"},
+		"pull_request": {
+			"number": 42, "title": "Add retries", "merged": true,
+			"html_url": "https://github.com/acme/api/pull/42",
+			"user": {"login": "bob"}, "base": {"ref": "main"}, "head": {"ref": "retries"}
+		}
+	}`)
+	reqs, err := GitHub("", nil)(githubRequestFor("pull_request", "", pr), pr)
+	assert.Nil(t, err)
+	assert.Len(t, reqs, 1)
+	assert.Equal(t, "[acme/api] Pull request #42 merged: Add retries", reqs[0].Title)
+	assert.Equal(t, "retries into main by bob\nURL: https://github.com/acme/api/pull/42", reqs[0].Message)
+
+	run := []byte(`{
+		"action": "completed",
+		"repository": {"full_name": "acme/api"},
+		"workflow_run": {
+			"name": "CI", "run_number": 7, "conclusion": "failure", "head_branch": "main",
+			"head_sha": "0d1a26e67d8f", "event": "push", "actor": {"login": "alice"},
+			"html_url": "https://github.com/acme/api/actions/runs/1"
+		}
+	}`)
+	reqs, err = GitHub("", nil)(githubRequestFor("workflow_run", "", run), run)
+	assert.Nil(t, err)
+	assert.Len(t, reqs, 1)
+	assert.Equal(t, "[acme/api] Workflow CI #7: failure", reqs[0].Title)
+	assert.Equal(t, 1, reqs[0].Priority)
+
+	// only failed runs are selected
+	reqs, err = GitHub("", []string{"workflow_run.failure"})(githubRequestFor("workflow_run", "", run), run)
+	assert.Nil(t, err)
+	assert.Len(t, reqs, 1)
+	reqs, err = GitHub("", []string{"workflow_run.success"})(githubRequestFor("workflow_run", "", run), run)
+	assert.Nil(t, err)
+	assert.Empty(t, reqs)
+
+	// synchronize is not in the default events, ping is not supported
+	pr = []byte(`{"action": "synchronize", "pull_request": {"number": 1}}`)
+	reqs, err = GitHub("", nil)(githubRequestFor("pull_request", "", pr), pr)
+	assert.Nil(t, err)
+	assert.Empty(t, reqs)
+	ping := []byte(`{"zen": "Keep it logically awesome."}`)
+	reqs, err = GitHub("", nil)(githubRequestFor("ping", "", ping), ping)
+	assert.Nil(t, err)
+	assert.Empty(t, reqs)
+}
+
+func TestGitHub_Invalid(t *testing.T) {
+	r := httptest.NewRequest("POST", "/webhook/github", nil)
+	_, err := GitHub("", nil)(r, []byte(`{}`))
+	assert.NotNil(t, err)
+	_, err = GitHub("", nil)(githubRequestFor("push", "", nil), []byte(`not json`))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,210 @@
+package sources
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kha7iq/pingme/internal/types"
+)
+
+// GitLabEvents are the events sent when no events are configured
+var GitLabEvents = []string{
+	"push", "tag_push",
+	"merge_request.open", "merge_request.close", "merge_request.reopen", "merge_request.merge",
+	"pipeline.success", "pipeline.failed",
+	"release.create",
+	"issue.open", "issue.close", "issue.reopen",
+}
+
+type gitlabPayload struct {
+	ObjectKind string `json:"object_kind"`
+	User       struct {
+		Name     string `json:"name"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+	} `json:"project"`
+
+	// push and tag_push
+	Ref               string `json:"ref"`
+	Before            string `json:"before"`
+	After             string `json:"after"`
+	UserName          string `json:"user_name"`
+	UserUsername      string `json:"user_username"`
+	TotalCommitsCount int    `json:"total_commits_count"`
+	Commits           []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commits"`
+
+	// merge_request, pipeline and issue
+	ObjectAttributes struct {
+		ID           int64   `json:"id"`
+		IID          int     `json:"iid"`
+		Title        string  `json:"title"`
+		URL          string  `json:"url"`
+		Action       string  `json:"action"`
+		Status       string  `json:"status"`
+		Ref          string  `json:"ref"`
+		SHA          string  `json:"sha"`
+		Duration     float64 `json:"duration"`
+		SourceBranch string  `json:"source_branch"`
+		TargetBranch string  `json:"target_branch"`
+	} `json:"object_attributes"`
+
+	// release
+	Action string `json:"action"`
+	Tag    string `json:"tag"`
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+}
+
+// zeroSHA is the before or after commit of pushes creating or deleting a ref
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+// gitlabVerbs are the past tense of GitLab actions
+var gitlabVerbs = map[string]string{
+	"open":       "opened",
+	"close":      "closed",
+	"reopen":     "reopened",
+	"update":     "updated",
+	"merge":      "merged",
+	"approval":   "approved",
+	"unapproval": "unapproved",
+	"create":     "created",
+	"delete":     "deleted",
+}
+
+// gitlabVerb returns the past tense of action for titles
+func gitlabVerb(action string) string {
+	if verb, ok := gitlabVerbs[action]; ok {
+		return verb
+	}
+	return action
+}
+
+// GitLab returns a Source for GitLab project and group webhooks. If token
+// is set the X-Gitlab-Token header must match it. Only the given events
+// are sent, see GitLabEvents for the default and eventFilter for the
+// syntax. The destination is not set, it comes from the query or the
+// config file.
+func GitLab(token string, events []string) Source {
+	filter := filterOrDefault(events, GitLabEvents)
+	return func(r *http.Request, body []byte) ([]types.WebhookRequest, error) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(token)) != 1 {
+			return nil, ErrUnauthorized
+		}
+
+		var p gitlabPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		if p.ObjectKind == "" {
+			return nil, fmt.Errorf("missing object_kind")
+		}
+
+		req, action, ok := gitlabRequest(&p)
+		if !ok || !filter.allows(p.ObjectKind, action) {
+			return nil, nil
+		}
+		sender := p.User.Username
+		if sender == "" {
+			sender = p.UserUsername
+		}
+		req.Extra = map[string]interface{}{
+			"event":      p.ObjectKind,
+			"action":     action,
+			"repository": p.Project.PathWithNamespace,
+			"sender":     sender,
+		}
+		return []types.WebhookRequest{req}, nil
+	}
+}
+
+// gitlabRequest builds the message of a supported event and returns the
+// action used for filtering. Unsupported events return false
+func gitlabRequest(p *gitlabPayload) (types.WebhookRequest, string, bool) {
+	repo := p.Project.PathWithNamespace
+	attrs := p.ObjectAttributes
+	action := attrs.Action
+	var (
+		req types.WebhookRequest
+		b   strings.Builder
+	)
+
+	switch p.ObjectKind {
+	case "push", "tag_push":
+		kind, name := "branch", strings.TrimPrefix(p.Ref, "refs/heads/")
+		if p.ObjectKind == "tag_push" {
+			kind, name = "tag", strings.TrimPrefix(p.Ref, "refs/tags/")
+		}
+		switch {
+		case p.After == zeroSHA:
+			req.Title = fmt.Sprintf("[%s] %s deleted %s %s", repo, p.UserName, kind, name)
+		case kind == "tag":
+			req.Title = fmt.Sprintf("[%s] %s pushed tag %s", repo, p.UserName, name)
+		default:
+			req.Title = fmt.Sprintf("[%s] %s pushed %s to %s", repo, p.UserName, plural(p.TotalCommitsCount, "commit"), name)
+		}
+		commits := make([]pushCommit, 0, len(p.Commits))
+		for _, c := range p.Commits {
+			commits = append(commits, pushCommit{ID: c.ID, Message: c.Message, Author: c.Author.Name})
+		}
+		writeCommits(&b, commits, p.TotalCommitsCount)
+		if p.Before != zeroSHA && p.After != zeroSHA && p.Project.WebURL != "" {
+			writeLink(&b, "Compare", fmt.Sprintf("%s/-/compare/%s...%s", p.Project.WebURL, shortSHA(p.Before), shortSHA(p.After)))
+		}
+		action = ""
+
+	case "merge_request":
+		req.Title = fmt.Sprintf("[%s] Merge request !%d %s: %s", repo, attrs.IID, gitlabVerb(attrs.Action), attrs.Title)
+		fmt.Fprintf(&b, "%s into %s by %s\n", attrs.SourceBranch, attrs.TargetBranch, p.User.Username)
+		writeLink(&b, "URL", attrs.URL)
+
+	case "pipeline":
+		req.Title = fmt.Sprintf("[%s] Pipeline #%d %s", repo, attrs.ID, attrs.Status)
+		fmt.Fprintf(&b, "Ref %s (%s), triggered by %s\n", attrs.Ref, shortSHA(attrs.SHA), p.User.Username)
+		if attrs.Duration > 0 {
+			fmt.Fprintf(&b, "Duration: %.0fs\n", attrs.Duration)
+		}
+		if p.Project.WebURL != "" {
+			writeLink(&b, "URL", fmt.Sprintf("%s/-/pipelines/%d", p.Project.WebURL, attrs.ID))
+		}
+		if attrs.Status == "failed" {
+			req.Priority = 1
+		}
+		action = attrs.Status
+
+	case "release":
+		name := p.Name
+		if name == "" {
+			name = p.Tag
+		}
+		req.Title = fmt.Sprintf("[%s] Release %s %s", repo, name, gitlabVerb(p.Action))
+		fmt.Fprintf(&b, "Tag %s\n", p.Tag)
+		writeLink(&b, "URL", p.URL)
+		action = p.Action
+
+	case "issue":
+		req.Title = fmt.Sprintf("[%s] Issue #%d %s: %s", repo, attrs.IID, gitlabVerb(attrs.Action), attrs.Title)
+		fmt.Fprintf(&b, "By %s\n", p.User.Username)
+		writeLink(&b, "URL", attrs.URL)
+
+	default:
+		return req, "", false
+	}
+
+	req.Message = strings.TrimSpace(b.String())
+	if req.Message == "" {
+		req.Message = req.Title
+	}
+	return req, action, true
+}
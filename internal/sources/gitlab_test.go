@@ -0,0 +1,84 @@
+package sources
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitLab_MergeRequest(t *testing.T) {
+	body := []byte(`{
+		"object_kind": "merge_request",
+		"user": {"username": "bob"},
+		"project": {"path_with_namespace": "acme/api", "web_url": "https://gitlab.com/acme/api"},
+		"object_attributes": {
+			"iid": 12, "title": "Add retries", "action": "merge",
+			"source_branch": "retries", "target_branch": "main",
+			"url": "https://gitlab.com/acme/api/-/merge_requests/12"
+		}
+	}`)
+	r := httptest.NewRequest("POST", "/webhook/gitlab", nil)
+	r.Header.Set("X-Gitlab-Token", "s3cret")
+	reqs, err := GitLab("s3cret", nil)(r, body)
+	assert.Nil(t, err)
+	assert.Len(t, reqs, 1)
+	assert.Equal(t, "[acme/api] Merge request !12 merged: Add retries", reqs[0].Title)
+	assert.Equal(t, "retries into main by bob\nURL: https://gitlab.com/acme/api/-/merge_requests/12", reqs[0].Message)
+
+	r.Header.Set("X-Gitlab-Token", "wrong")
+	_, err = GitLab("s3cret", nil)(r, body)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+}
+
+func TestGitLab_Push(t *testing.T) {
+	body := []byte(`{
+		"object_kind": "push",
+		"ref": "refs/heads/main",
+		"before": "6113728f27ae82c7b1a177c8d03f9e96e0adf246",
+		"after": "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c",
+		"user_name": "Alice",
+		"user_username": "alice",
+		"total_commits_count": 7,
+		"project": {"path_with_namespace": "acme/api", "web_url": "https://gitlab.com/acme/api"},
+		"commits": [
+			{"id": "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c", "message": "Bump version\n", "author": {"name": "Alice"}}
+		]
+	}`)
+	r := httptest.NewRequest("POST", "/webhook/gitlab", nil)
+	reqs, err := GitLab("", nil)(r, body)
+	assert.Nil(t, err)
+	assert.Len(t, reqs, 1)
+	assert.Equal(t, "[acme/api] Alice pushed 7 commits to main", reqs[0].Title)
+	assert.Equal(t, `- 0d1a26e Bump version (Alice)
+- and 2 more
+Compare: https://gitlab.com/acme/api/-/compare/6113728...0d1a26e`, reqs[0].Message)
+	assert.Equal(t, "alice", reqs[0].Extra["sender"])
+}
+
+func TestGitLab_Pipeline(t *testing.T) {
+	body := []byte(`{
+		"object_kind": "pipeline",
+		"user": {"username": "alice"},
+		"project": {"path_with_namespace": "acme/api", "web_url": "https://gitlab.com/acme/api"},
+		"object_attributes": {"id": 991, "status": "failed", "ref": "main", "sha": "0d1a26e67d8f", "duration": 93}
+	}`)
+	r := httptest.NewRequest("POST", "/webhook/gitlab", nil)
+	reqs, err := GitLab("", nil)(r, body)
+	assert.Nil(t, err)
+	assert.Len(t, reqs, 1)
+	assert.Equal(t, "[acme/api] Pipeline #991 failed", reqs[0].Title)
+	assert.Equal(t, 1, reqs[0].Priority)
+	assert.Contains(t, reqs[0].Message, "URL: https://gitlab.com/acme/api/-/pipelines/991")
+
+	// running pipelines are not in the default events
+	running := []byte(`{"object_kind": "pipeline", "object_attributes": {"id": 1, "status": "running"}}`)
+	reqs, err = GitLab("", nil)(r, running)
+	assert.Nil(t, err)
+	assert.Empty(t, reqs)
+
+	reqs, err = GitLab("", []string{"push"})(r, body)
+	assert.Nil(t, err)
+	assert.Empty(t, reqs)
+}
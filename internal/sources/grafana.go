@@ -0,0 +1,253 @@
+package sources
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kha7iq/pingme/internal/types"
+)
+
+// grafanaPayload is the webhook payload of Grafana. Unified alerting sends
+// alerts in the Alertmanager format with extra links, legacy dashboard
+// alerts send a single rule with ruleName, ruleUrl and evalMatches.
+type grafanaPayload struct {
+	Receiver          string            `json:"receiver"`
+	Status            string            `json:"status"`
+	State             string            `json:"state"`
+	OrgID             int64             `json:"orgId"`
+	Title             string            `json:"title"`
+	Message           string            `json:"message"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Alerts            []grafanaAlert    `json:"alerts"`
+
+	// legacy alerting
+	RuleName    string             `json:"ruleName"`
+	RuleURL     string             `json:"ruleUrl"`
+	ImageURL    string             `json:"imageUrl"`
+	Tags        map[string]string  `json:"tags"`
+	EvalMatches []grafanaEvalMatch `json:"evalMatches"`
+}
+
+type grafanaAlert struct {
+	alertmanagerAlert
+	DashboardURL string `json:"dashboardURL"`
+	PanelURL     string `json:"panelURL"`
+	SilenceURL   string `json:"silenceURL"`
+	ImageURL     string `json:"imageURL"`
+	ValueString  string `json:"valueString"`
+}
+
+type grafanaEvalMatch struct {
+	Metric string            `json:"metric"`
+	Value  float64           `json:"value"`
+	Tags   map[string]string `json:"tags"`
+}
+
+// Grafana converts a Grafana webhook contact point notification to a
+// message for the alert group, or with ?split=true to one message per
+// alert. The contact point name is used as target unless the query or the
+// config file selects one.
+func Grafana(r *http.Request, body []byte) ([]types.WebhookRequest, error) {
+	var p grafanaPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	if len(p.Alerts) == 0 {
+		if p.RuleName == "" && p.Title == "" {
+			return nil, nil
+		}
+		return []types.WebhookRequest{legacyGrafanaRequest(&p)}, nil
+	}
+
+	if split, _ := strconv.ParseBool(r.URL.Query().Get("split")); split {
+		reqs := make([]types.WebhookRequest, 0, len(p.Alerts))
+		for _, a := range p.Alerts {
+			reqs = append(reqs, grafanaAlertRequest(&p, a))
+		}
+		return reqs, nil
+	}
+	return []types.WebhookRequest{grafanaGroupRequest(&p)}, nil
+}
+
+// grafanaGroupRequest builds one message listing every alert of the group
+func grafanaGroupRequest(p *grafanaPayload) types.WebhookRequest {
+	var firing, resolved []grafanaAlert
+	for _, a := range p.Alerts {
+		if a.Status == statusResolved {
+			resolved = append(resolved, a)
+		} else {
+			firing = append(firing, a)
+		}
+	}
+
+	title := p.Title
+	if title == "" {
+		title = fmt.Sprintf("[%s:%d] %s", strings.ToUpper(p.Status), len(p.Alerts), alertName(p.GroupLabels, p.CommonLabels))
+		if labels := formatLabels(p.GroupLabels, "alertname", "grafana_folder"); labels != "" {
+			title += " (" + labels + ")"
+		}
+	}
+
+	var b strings.Builder
+	if summary := summary(p.CommonAnnotations); summary != "" {
+		b.WriteString(summary + "\n\n")
+	}
+	writeGrafanaAlerts(&b, "Firing", firing, p.CommonLabels)
+	writeGrafanaAlerts(&b, "Resolved", resolved, p.CommonLabels)
+	if p.TruncatedAlerts > 0 {
+		fmt.Fprintf(&b, "%d more alerts not shown\n", p.TruncatedAlerts)
+	}
+	if p.ExternalURL != "" {
+		fmt.Fprintf(&b, "Grafana: %s\n", p.ExternalURL)
+	}
+
+	extra := grafanaExtra(p)
+	extra["groupLabels"] = toMap(p.GroupLabels)
+	extra["commonLabels"] = toMap(p.CommonLabels)
+	extra["commonAnnotations"] = toMap(p.CommonAnnotations)
+	extra["firing"] = len(firing)
+	extra["resolved"] = len(resolved)
+	extra["imageURL"] = ""
+	for _, a := range p.Alerts {
+		if a.ImageURL != "" {
+			extra["imageURL"] = a.ImageURL
+			break
+		}
+	}
+
+	return types.WebhookRequest{
+		Target:   p.Receiver,
+		Title:    title,
+		Message:  strings.TrimSpace(b.String()),
+		Priority: alertPriority(p.Status, p.CommonLabels),
+		Extra:    extra,
+	}
+}
+
+// grafanaAlertRequest builds the message for a single alert
+func grafanaAlertRequest(p *grafanaPayload, a grafanaAlert) types.WebhookRequest {
+	title := fmt.Sprintf("[%s] %s", strings.ToUpper(a.Status), alertName(a.Labels))
+	if labels := formatLabels(a.Labels, "alertname", "grafana_folder"); labels != "" {
+		title += " (" + labels + ")"
+	}
+
+	var b strings.Builder
+	writeGrafanaAlert(&b, a, nil)
+
+	extra := grafanaExtra(p)
+	extra["status"] = a.Status
+	extra["labels"] = toMap(a.Labels)
+	extra["annotations"] = toMap(a.Annotations)
+	extra["generatorURL"] = a.GeneratorURL
+	extra["dashboardURL"] = a.DashboardURL
+	extra["panelURL"] = a.PanelURL
+	extra["silenceURL"] = a.SilenceURL
+	extra["imageURL"] = a.ImageURL
+	extra["values"] = a.ValueString
+
+	return types.WebhookRequest{
+		Target:   p.Receiver,
+		Title:    title,
+		Message:  strings.TrimSpace(b.String()),
+		Priority: alertPriority(a.Status, a.Labels),
+		Extra:    extra,
+	}
+}
+
+// legacyGrafanaRequest builds the message of a legacy dashboard alert
+func legacyGrafanaRequest(p *grafanaPayload) types.WebhookRequest {
+	title := p.Title
+	if title == "" {
+		title = fmt.Sprintf("[%s] %s", strings.ToUpper(p.State), p.RuleName)
+	}
+
+	var b strings.Builder
+	if p.Message != "" {
+		b.WriteString(p.Message + "\n")
+	}
+	for _, m := range p.EvalMatches {
+		fmt.Fprintf(&b, "- %s: %s\n", m.Metric, strconv.FormatFloat(m.Value, 'f', -1, 64))
+	}
+	writeLink(&b, "Rule", p.RuleURL)
+	writeLink(&b, "Image", p.ImageURL)
+
+	priority := 0
+	if p.State == "alerting" && p.Tags["severity"] == "critical" {
+		priority = 1
+	}
+
+	extra := grafanaExtra(p)
+	extra["ruleName"] = p.RuleName
+	extra["ruleUrl"] = p.RuleURL
+	extra["imageURL"] = p.ImageURL
+	extra["tags"] = toMap(p.Tags)
+
+	return types.WebhookRequest{
+		Target:   p.Receiver,
+		Title:    title,
+		Message:  strings.TrimSpace(b.String()),
+		Priority: priority,
+		Extra:    extra,
+	}
+}
+
+// grafanaExtra returns the template data shared by all grafana messages
+func grafanaExtra(p *grafanaPayload) map[string]interface{} {
+	return map[string]interface{}{
+		"status":      p.Status,
+		"state":       p.State,
+		"orgId":       p.OrgID,
+		"receiver":    p.Receiver,
+		"externalURL": p.ExternalURL,
+	}
+}
+
+// writeGrafanaAlerts writes a section listing alerts, labels shared by all
+// alerts of the group are left out
+func writeGrafanaAlerts(b *strings.Builder, heading string, alerts []grafanaAlert, common map[string]string) {
+	if len(alerts) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "%s (%d):\n", heading, len(alerts))
+	for _, a := range alerts {
+		b.WriteString("- ")
+		writeGrafanaAlert(b, a, common)
+	}
+	b.WriteString("\n")
+}
+
+// writeGrafanaAlert writes an alert like Alertmanager alerts followed by
+// the values and links added by Grafana
+func writeGrafanaAlert(b *strings.Builder, a grafanaAlert, common map[string]string) {
+	skip := map[string]string{"grafana_folder": ""}
+	for k, v := range common {
+		skip[k] = v
+	}
+	alert := a.alertmanagerAlert
+	alert.GeneratorURL = ""
+	writeAlert(b, alert, skip)
+
+	if a.ValueString != "" {
+		fmt.Fprintf(b, "  Values: %s\n", a.ValueString)
+	}
+	writeLink(b, "  Rule", a.GeneratorURL)
+	writeLink(b, "  Dashboard", a.DashboardURL)
+	writeLink(b, "  Panel", a.PanelURL)
+	writeLink(b, "  Silence", a.SilenceURL)
+	writeLink(b, "  Image", a.ImageURL)
+}
+
+// writeLink writes "name: url" if url is set
+func writeLink(b *strings.Builder, name, url string) {
+	if url != "" {
+		fmt.Fprintf(b, "%s: %s\n", name, url)
+	}
+}
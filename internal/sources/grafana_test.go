@@ -0,0 +1,92 @@
+package sources
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGrafana(t *testing.T) {
+	r := httptest.NewRequest("POST", "/webhook/grafana", nil)
+	reqs, err := Grafana(r, readTestdata(t, "grafana.json"))
+	assert.Nil(t, err)
+	assert.Len(t, reqs, 1)
+
+	req := reqs[0]
+	assert.Equal(t, "ops-slack", req.Target)
+	assert.Equal(t, "[FIRING:1, RESOLVED:1] HighLatency Web", req.Title)
+	assert.Equal(t, 1, req.Priority)
+	assert.Equal(t, `Firing (1):
+- p99 latency on web-1 above 2s
+  Labels: instance=web-1
+  Started: 2024-05-04T10:00:00Z
+  Values: [ var='B' labels={instance=web-1} value=2.4 ]
+  Rule: http://grafana:3000/alerting/grafana/abc123/view?orgId=1
+  Dashboard: http://grafana:3000/d/web?orgId=1
+  Panel: http://grafana:3000/d/web?orgId=1&viewPanel=4
+  Silence: http://grafana:3000/alerting/silence/new?alertmanager=grafana&matcher=alertname%3DHighLatency
+  Image: http://grafana:3000/public/img/attachments/abc.png
+
+Resolved (1):
+- p99 latency on web-2 above 2s
+  Labels: instance=web-2
+  Started: 2024-05-04T09:00:00Z
+  Ended: 2024-05-04T09:30:00Z
+  Rule: http://grafana:3000/alerting/grafana/abc123/view?orgId=1
+
+Grafana: http://grafana:3000/`, req.Message)
+	assert.Equal(t, int64(1), req.Extra["orgId"])
+	assert.Equal(t, "alerting", req.Extra["state"])
+	assert.Equal(t, "http://grafana:3000/public/img/attachments/abc.png", req.Extra["imageURL"])
+}
+
+func TestGrafana_Split(t *testing.T) {
+	r := httptest.NewRequest("POST", "/webhook/grafana?split=true", nil)
+	reqs, err := Grafana(r, readTestdata(t, "grafana.json"))
+	assert.Nil(t, err)
+	assert.Len(t, reqs, 2)
+
+	assert.Equal(t, "[FIRING] HighLatency (instance=web-1 severity=critical)", reqs[0].Title)
+	assert.Equal(t, 1, reqs[0].Priority)
+	assert.Equal(t, "http://grafana:3000/d/web?orgId=1&viewPanel=4", reqs[0].Extra["panelURL"])
+	assert.Contains(t, reqs[0].Message, "Labels: instance=web-1 severity=critical\n")
+	assert.Equal(t, "[RESOLVED] HighLatency (instance=web-2 severity=critical)", reqs[1].Title)
+	assert.Equal(t, 0, reqs[1].Priority)
+}
+
+func TestGrafana_Legacy(t *testing.T) {
+	r := httptest.NewRequest("POST", "/webhook/grafana", nil)
+	reqs, err := Grafana(r, []byte(`{
+		"title": "[Alerting] Disk usage",
+		"ruleName": "Disk usage",
+		"ruleUrl": "http://grafana:3000/d/disk?viewPanel=2",
+		"imageUrl": "http://grafana:3000/render/disk.png",
+		"state": "alerting",
+		"message": "Disk almost full",
+		"orgId": 1,
+		"tags": {"severity": "critical"},
+		"evalMatches": [{"metric": "/var", "value": 95.5}]
+	}`))
+	assert.Nil(t, err)
+	assert.Len(t, reqs, 1)
+
+	req := reqs[0]
+	assert.Equal(t, "[Alerting] Disk usage", req.Title)
+	assert.Equal(t, 1, req.Priority)
+	assert.Equal(t, `Disk almost full
+- /var: 95.5
+Rule: http://grafana:3000/d/disk?viewPanel=2
+Image: http://grafana:3000/render/disk.png`, req.Message)
+	assert.Equal(t, "http://grafana:3000/d/disk?viewPanel=2", req.Extra["ruleUrl"])
+}
+
+func TestGrafana_Invalid(t *testing.T) {
+	r := httptest.NewRequest("POST", "/webhook/grafana", nil)
+	_, err := Grafana(r, []byte(`not json`))
+	assert.NotNil(t, err)
+
+	reqs, err := Grafana(r, []byte(`{"alerts": []}`))
+	assert.Nil(t, err)
+	assert.Empty(t, reqs)
+}
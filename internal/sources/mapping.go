@@ -0,0 +1,221 @@
+package sources
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/kha7iq/pingme/internal/render"
+	"github.com/kha7iq/pingme/internal/types"
+
+	"github.com/tidwall/gjson"
+)
+
+// Mapping extracts a message from an arbitrary JSON payload. Every field
+// is either a gjson path, e.g. "heartbeat.msg" or a literal like
+// `!"Backup failed"`, or a template rendered with the payload as data if
+// it contains "{{".
+type Mapping struct {
+	// Targets are the targets or services used when the payload selects none
+	Targets []string `yaml:"targets"`
+	// Service selects one or more services, a list or comma separated
+	Service string `yaml:"service"`
+	// Target selects one or more targets, a list or comma separated
+	Target string `yaml:"target"`
+	Title  string `yaml:"title"`
+	// Message defaults to the payload itself
+	Message string `yaml:"message"`
+	// Priority must evaluate to a number
+	Priority string `yaml:"priority"`
+}
+
+// expr is a compiled Mapping field
+type expr struct {
+	path string
+	tpl  *template.Template
+}
+
+// compileExpr compiles a Mapping field, an empty field returns nil
+func compileExpr(name, text string) (*expr, error) {
+	if text == "" {
+		return nil, nil
+	}
+	if !strings.Contains(text, "{{") {
+		return &expr{path: text}, nil
+	}
+	tpl, err := template.New(name).
+		Funcs(render.Funcs()).
+		Funcs(template.FuncMap{"get": func(string) string { return "" }}).
+		Option("missingkey=zero").
+		Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+	}
+	return &expr{tpl: tpl}, nil
+}
+
+// eval returns the value of e for the payload body decoded as data.
+// Arrays found by a gjson path are returned as their elements.
+func (e *expr) eval(body []byte, data interface{}) ([]string, error) {
+	if e.tpl == nil {
+		res := gjson.GetBytes(body, e.path)
+		if !res.Exists() {
+			return nil, nil
+		}
+		if !res.IsArray() {
+			return []string{res.String()}, nil
+		}
+		var values []string
+		for _, v := range res.Array() {
+			values = append(values, v.String())
+		}
+		return values, nil
+	}
+
+	// get looks up gjson paths from templates
+	tpl, err := e.tpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	tpl.Funcs(template.FuncMap{"get": func(path string) string {
+		return gjson.GetBytes(body, path).String()
+	}})
+
+	var buf bytes.Buffer
+	if err = tpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render %s: %w", tpl.Name(), err)
+	}
+	if s := strings.TrimSpace(buf.String()); s != "" {
+		return []string{s}, nil
+	}
+	return nil, nil
+}
+
+// string evaluates e joining several values with newlines, a nil expr
+// returns an empty string
+func (e *expr) string(body []byte, data interface{}) (string, error) {
+	if e == nil {
+		return "", nil
+	}
+	values, err := e.eval(body, data)
+	return strings.Join(values, "\n"), err
+}
+
+// list evaluates e to a list of names, splitting values at commas
+func (e *expr) list(body []byte, data interface{}) ([]string, error) {
+	if e == nil {
+		return nil, nil
+	}
+	values, err := e.eval(body, data)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, v := range values {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
+
+// compiledMapping holds the compiled fields of a Mapping
+type compiledMapping struct {
+	service, target, title, message, priority *expr
+	targets                                   []string
+}
+
+// Map returns a Source converting JSON payloads to a message with m. If
+// the payload is an object it is available to target templates as .Extra.
+func Map(m Mapping) (Source, error) {
+	var (
+		c   = compiledMapping{targets: m.Targets}
+		err error
+	)
+	fields := []struct {
+		name string
+		text string
+		expr **expr
+	}{
+		{"service", m.Service, &c.service},
+		{"target", m.Target, &c.target},
+		{"title", m.Title, &c.title},
+		{"message", m.Message, &c.message},
+		{"priority", m.Priority, &c.priority},
+	}
+	for _, f := range fields {
+		if *f.expr, err = compileExpr(f.name, f.text); err != nil {
+			return nil, err
+		}
+	}
+	return c.source, nil
+}
+
+// source implements Source
+func (c *compiledMapping) source(r *http.Request, body []byte) ([]types.WebhookRequest, error) {
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	var (
+		req types.WebhookRequest
+		err error
+	)
+	if req.Title, err = c.title.string(body, data); err != nil {
+		return nil, err
+	}
+	if req.Message, err = c.message.string(body, data); err != nil {
+		return nil, err
+	}
+	if c.message == nil {
+		var buf bytes.Buffer
+		if err = json.Indent(&buf, body, "", "  "); err != nil {
+			return nil, err
+		}
+		req.Message = buf.String()
+	}
+
+	priority, err := c.priority.string(body, data)
+	if err != nil {
+		return nil, err
+	}
+	if priority != "" {
+		p, parseErr := strconv.ParseFloat(priority, 64)
+		if parseErr != nil {
+			return nil, fmt.Errorf("priority %q is not a number", priority)
+		}
+		req.Priority = int(p)
+	}
+
+	services, err := c.service.list(body, data)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := c.target.list(body, data)
+	if err != nil {
+		return nil, err
+	}
+	switch dests := append(append([]string(nil), targets...), services...); {
+	case len(dests) == 0:
+		// static targets may name targets or services
+		req.Services = c.targets
+	case len(dests) > 1:
+		req.Services = dests
+	case len(targets) == 1:
+		req.Target = targets[0]
+	case len(services) == 1:
+		req.Service = services[0]
+	}
+
+	if obj, ok := data.(map[string]interface{}); ok {
+		req.Extra = obj
+	}
+	return []types.WebhookRequest{req}, nil
+}
@@ -0,0 +1,80 @@
+package sources
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// uptimeKuma is a trimmed Uptime Kuma webhook payload
+const uptimeKuma = `{
+	"heartbeat": {"status": 0, "msg": "connect ECONNREFUSED", "time": "2024-05-04 10:00:00"},
+	"monitor": {"name": "api", "url": "https://api.example.com", "tags": [{"name": "oncall-telegram"}]},
+	"msg": "[api] [DOWN] connect ECONNREFUSED"
+}`
+
+func TestMap(t *testing.T) {
+	source, err := Map(Mapping{
+		Target:   "monitor.tags.#.name",
+		Title:    `{{.monitor.name}} is {{if eq .heartbeat.status 1.0}}up{{else}}down{{end}}`,
+		Message:  "msg",
+		Priority: `{{if eq (get "heartbeat.status") "0"}}1{{else}}0{{end}}`,
+	})
+	assert.Nil(t, err)
+
+	r := httptest.NewRequest("POST", "/hooks/uptime-kuma", nil)
+	reqs, err := source(r, []byte(uptimeKuma))
+	assert.Nil(t, err)
+	assert.Len(t, reqs, 1)
+
+	req := reqs[0]
+	assert.Equal(t, "oncall-telegram", req.Target)
+	assert.Equal(t, "api is down", req.Title)
+	assert.Equal(t, "[api] [DOWN] connect ECONNREFUSED", req.Message)
+	assert.Equal(t, 1, req.Priority)
+	assert.Equal(t, "connect ECONNREFUSED", req.Extra["heartbeat"].(map[string]interface{})["msg"])
+}
+
+func TestMap_Defaults(t *testing.T) {
+	source, err := Map(Mapping{
+		Targets: []string{"ops-slack", "telegram"},
+		Title:   `!"Cron job"`,
+	})
+	assert.Nil(t, err)
+
+	r := httptest.NewRequest("POST", "/hooks/cron", nil)
+	reqs, err := source(r, []byte(`{"job":"backup","ok":false}`))
+	assert.Nil(t, err)
+	assert.Len(t, reqs, 1)
+	assert.Equal(t, []string{"ops-slack", "telegram"}, reqs[0].Services)
+	assert.Equal(t, "Cron job", reqs[0].Title)
+	assert.Equal(t, "{\n  \"job\": \"backup\",\n  \"ok\": false\n}", reqs[0].Message)
+
+	// the payload takes precedence over the defaults
+	source, err = Map(Mapping{Targets: []string{"ops-slack"}, Service: "service"})
+	assert.Nil(t, err)
+	reqs, err = source(r, []byte(`{"service":"slack"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "slack", reqs[0].Service)
+	assert.Empty(t, reqs[0].Target)
+	assert.Empty(t, reqs[0].Services)
+
+	// a single default may be a service too
+	reqs, err = source(r, []byte(`{}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ops-slack"}, reqs[0].Services)
+}
+
+func TestMap_Invalid(t *testing.T) {
+	_, err := Map(Mapping{Title: "{{.title"})
+	assert.NotNil(t, err)
+
+	source, err := Map(Mapping{Priority: "level"})
+	assert.Nil(t, err)
+	r := httptest.NewRequest("POST", "/hooks/sentry", nil)
+	_, err = source(r, []byte(`{"level":"error"}`))
+	assert.NotNil(t, err)
+	_, err = source(r, []byte(`not json`))
+	assert.NotNil(t, err)
+}
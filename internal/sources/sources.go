@@ -0,0 +1,120 @@
+// Package sources converts the webhook payloads of other tools, e.g.
+// Alertmanager, to pingme webhook requests.
+package sources
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/kha7iq/pingme/internal/types"
+)
+
+// ErrUnauthorized is returned by sources verifying the sender of a payload
+var ErrUnauthorized = errors.New("invalid signature or token")
+
+// Source converts the payload of a webhook to zero or more requests
+type Source func(r *http.Request, body []byte) ([]types.WebhookRequest, error)
+
+// formatLabels formats labels as "k=v k=v" sorted by key, skipping the
+// keys in skip
+func formatLabels(labels map[string]string, skip ...string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		if !contains(skip, k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%s", k, labels[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// toMap converts string maps to the map type used by WebhookRequest.Extra
+func toMap(m map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// eventFilter selects events by name, e.g. "push", or by name and action,
+// e.g. "pull_request.opened"
+type eventFilter []string
+
+// allows reports whether event with action is selected by the filter
+func (f eventFilter) allows(event, action string) bool {
+	return contains(f, event) || (action != "" && contains(f, event+"."+action))
+}
+
+// filterOrDefault returns a filter of events, or def if events is empty
+func filterOrDefault(events, def []string) eventFilter {
+	if len(events) == 0 {
+		return def
+	}
+	return events
+}
+
+// firstLine returns the first line of s, e.g. the subject of a commit message
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+// shortSHA abbreviates a commit hash
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// maxCommits is the number of commits listed in push messages
+const maxCommits = 5
+
+// pushCommit is a commit of a push event
+type pushCommit struct {
+	ID      string
+	Message string
+	Author  string
+}
+
+// writeCommits lists up to maxCommits commits of a push, total is the
+// number of commits pushed
+func writeCommits(b *strings.Builder, commits []pushCommit, total int) {
+	for i, c := range commits {
+		if i == maxCommits {
+			break
+		}
+		fmt.Fprintf(b, "- %s %s (%s)\n", shortSHA(c.ID), firstLine(c.Message), c.Author)
+	}
+	if total > maxCommits {
+		fmt.Fprintf(b, "- and %d more\n", total-maxCommits)
+	}
+}
+
+// plural returns "1 commit" or "n commits"
+func plural(n int, word string) string {
+	if n == 1 {
+		return "1 " + word
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
@@ -2,9 +2,16 @@ package types
 
 // WebhookRequest represents the incoming webhook payload
 type WebhookRequest struct {
-	Service  string                 `json:"service"`  // e.g., "pushover", "telegram", "slack"
-	Message  string                 `json:"message"`  // Message content
-	Title    string                 `json:"title"`    // Optional title
-	Priority int                    `json:"priority"` // Optional priority (for services that support it)
-	Extra    map[string]interface{} `json:"extra"`    // Additional service-specific parameters
+	ID         string                 `json:"id"`         // Optional idempotency key, repeats return the first response
+	Service    string                 `json:"service"`    // e.g., "pushover", "telegram", "slack"
+	Target     string                 `json:"target"`     // Named target from the config file, e.g. "ops-slack"
+	Services   []string               `json:"services"`   // Fan-out to several services or targets at once
+	FailOn     string                 `json:"fail_on"`    // Fan-out failure policy, "any" (default) or "all"
+	Template   string                 `json:"template"`   // Optional text/template rendering title and message from Extra
+	Message    string                 `json:"message"`    // Message content
+	Title      string                 `json:"title"`      // Optional title
+	Priority   int                    `json:"priority"`   // Optional priority (for services that support it)
+	Escalation string                 `json:"escalation"` // Escalation policy from the config file, sent step by step until acknowledged
+	Extra      map[string]interface{} `json:"extra"`      // Additional service-specific parameters
+	Source     string                 `json:"-"`          // Path the request was posted to, used by routes
 }
@@ -4,23 +4,15 @@ import (
 	"log"
 	"os"
 
+	"github.com/kha7iq/pingme/internal/command"
+	"github.com/kha7iq/pingme/internal/config"
+	"github.com/kha7iq/pingme/internal/idempotency"
+	"github.com/kha7iq/pingme/internal/queue"
 	"github.com/kha7iq/pingme/internal/server"
-	"github.com/kha7iq/pingme/service/discord"
-	"github.com/kha7iq/pingme/service/email"
-	"github.com/kha7iq/pingme/service/gotify"
-	"github.com/kha7iq/pingme/service/line"
-	"github.com/kha7iq/pingme/service/mastodon"
-	"github.com/kha7iq/pingme/service/matrix"
-	"github.com/kha7iq/pingme/service/mattermost"
-	"github.com/kha7iq/pingme/service/msteams"
-	"github.com/kha7iq/pingme/service/pushbullet"
-	"github.com/kha7iq/pingme/service/pushover"
-	"github.com/kha7iq/pingme/service/rocketchat"
-	"github.com/kha7iq/pingme/service/slack"
-	"github.com/kha7iq/pingme/service/telegram"
-	"github.com/kha7iq/pingme/service/twillio"
-	"github.com/kha7iq/pingme/service/wechat"
-	"github.com/kha7iq/pingme/service/zulip"
+	_ "github.com/kha7iq/pingme/service/all"
+	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
+
 	"github.com/urfave/cli/v2"
 )
 
@@ -37,6 +29,16 @@ func main() {
 	app.Description = `PingMe is a CLI tool to send messages to multiple platforms.
 It also supports running as a webhook server to receive and dispatch notifications.`
 
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Aliases: []string{"c"},
+			Usage:   "Path to config file defining named targets",
+			EnvVars: []string{"PINGME_CONFIG"},
+		},
+	}
+	app.Flags = append(app.Flags, helpers.RetryFlags()...)
+
 	app.Commands = []*cli.Command{
 		// Webhook server command
 		{
@@ -44,14 +46,33 @@ It also supports running as a webhook server to receive and dispatch notificatio
 			Aliases: []string{"server", "webhook"},
 			Usage:   "Start webhook server",
 			Description: `Start a webhook server that receives POST requests and dispatches notifications.
-Configuration is done via environment variables (same as CLI commands).
+Configuration is done via environment variables (same as CLI commands)
+and named targets from the config file given with --config.
+With --async requests are queued and answered with 202 Accepted,
+the delivery status is available at GET /messages/{id}.
+With --tls-cert and --tls-key the server uses HTTPS, the files are
+reloaded when they change.
+--rate-limit limits the requests of every client, deliveries are
+limited per service with rate_limits in the config file.
+Repeated requests with the same Idempotency-Key header get the first
+response instead of being sent again. Targets with digest settings
+get a single summary of the messages within their window, target
+schedules drop, defer, downgrade or reroute messages out of hours.
+Requests naming an escalation policy are sent step by step until they
+are acknowledged at /ack/{token} or POST /messages/{id}/ack.
 
 Authentication (optional):
-  Set PINGME_AUTH_METHOD to: "apikey", "hmac", "basic", or "none"
+  Set PINGME_AUTH_METHOD to: "apikey", "hmac", "jwt", "mtls", "basic", or "none"
   
-  For apikey: Set PINGME_API_KEYS="key1,key2,key3"
-  For hmac: Set PINGME_HMAC_SECRET="your-secret"
-  For basic: Set PINGME_BASIC_USER="user" and PINGME_BASIC_PASS="pass"`,
+  For apikey: Set PINGME_API_KEYS="key1,key2,key3" or define api_keys in the config file
+  For hmac: Set PINGME_HMAC_SECRET="your-secret", PINGME_HMAC_TIMESTAMP=true
+    and PINGME_HMAC_NONCE=true protect against replayed requests
+  For jwt: Configure the keys and grants in the jwt section of the config file
+  For mtls: Set --client-ca and optionally allow clients in the mtls section
+    of the config file
+  For basic: Set PINGME_BASIC_USER="user" and PINGME_BASIC_PASS="pass"
+
+  API keys and passwords may be hashed, see pingme hash-secret`,
 			Flags: []cli.Flag{
 				&cli.StringFlag{
 					Name:    "port",
@@ -67,34 +88,99 @@ Authentication (optional):
 					Value:   "0.0.0.0",
 					EnvVars: []string{"PINGME_HOST"},
 				},
+				&cli.BoolFlag{
+					Name:    "async",
+					Usage:   "Queue webhooks and answer with 202 Accepted, delivery status is available at /messages/{id}",
+					EnvVars: []string{"PINGME_ASYNC"},
+				},
+				&cli.IntFlag{
+					Name:    "workers",
+					Usage:   "Number of concurrent deliveries in async mode",
+					Value:   queue.DefaultWorkers,
+					EnvVars: []string{"PINGME_WORKERS"},
+				},
+				&cli.IntFlag{
+					Name:    "queue-size",
+					Usage:   "Maximum number of messages waiting for delivery in async mode",
+					Value:   queue.DefaultSize,
+					EnvVars: []string{"PINGME_QUEUE_SIZE"},
+				},
+				&cli.StringFlag{
+					Name:    "data-dir",
+					Usage:   "Directory persisting queued messages across restarts, enables --async",
+					EnvVars: []string{"PINGME_DATA_DIR"},
+				},
+				&cli.BoolFlag{
+					Name:    "metrics",
+					Usage:   "Expose Prometheus metrics at /metrics",
+					EnvVars: []string{"PINGME_METRICS"},
+				},
+				&cli.StringFlag{
+					Name:    "metrics-token",
+					Usage:   "Bearer token required for /metrics instead of the webhook authentication, --metrics requires one of them",
+					EnvVars: []string{"PINGME_METRICS_TOKEN"},
+				},
+				&cli.StringFlag{
+					Name:    "tls-cert",
+					Usage:   "PEM certificate file serving HTTPS, reloaded when it changes",
+					EnvVars: []string{"PINGME_TLS_CERT"},
+				},
+				&cli.StringFlag{
+					Name:    "tls-key",
+					Usage:   "PEM private key file of --tls-cert",
+					EnvVars: []string{"PINGME_TLS_KEY"},
+				},
+				&cli.StringFlag{
+					Name:    "client-ca",
+					Usage:   "PEM CA certificates verifying client certificates for the mtls auth method",
+					EnvVars: []string{"PINGME_CLIENT_CA"},
+				},
+				&cli.StringFlag{
+					Name:    "rate-limit",
+					Usage:   "Requests allowed per client, e.g. 10/s or 600/m, clients are identified by API key, JWT, certificate or IP",
+					EnvVars: []string{"PINGME_RATE_LIMIT"},
+				},
+				&cli.DurationFlag{
+					Name:    "idempotency-ttl",
+					Usage:   "How long repeated requests with the same idempotency key get the first response, 0 disables it",
+					Value:   idempotency.DefaultTTL,
+					EnvVars: []string{"PINGME_IDEMPOTENCY_TTL"},
+				},
 			},
 			Action: func(c *cli.Context) error {
 				port := c.String("port")
 				host := c.String("host")
 
-				srv := server.New(host, port)
+				cfg, err := config.Load(c.String("config"))
+				if err != nil {
+					return err
+				}
+				cfg.Retry = helpers.RetryPolicyFromFlags(c, cfg.Retry)
+
+				srv := server.New(host, port, cfg, server.Options{
+					Async:          c.Bool("async"),
+					Workers:        c.Int("workers"),
+					QueueSize:      c.Int("queue-size"),
+					DataDir:        c.String("data-dir"),
+					Metrics:        c.Bool("metrics"),
+					MetricsToken:   c.String("metrics-token"),
+					TLSCert:        c.String("tls-cert"),
+					TLSKey:         c.String("tls-key"),
+					ClientCA:       c.String("client-ca"),
+					RateLimit:      c.String("rate-limit"),
+					IdempotencyTTL: c.Duration("idempotency-ttl"),
+				})
 				return srv.Start()
 			},
 		},
-		// service commands
-		telegram.Send(),
-		rocketchat.Send(),
-		slack.Send(),
-		discord.Send(),
-		msteams.Send(),
-		pushover.Send(),
-		email.Send(),
-		mattermost.Send(),
-		pushbullet.Send(),
-		twillio.Send(),
-		mastodon.Send(),
-		wechat.Send(),
-		zulip.Send(),
-		gotify.Send(),
-		line.Send(),
-		matrix.Send(),
+		command.Send(),
+		command.Route(),
+		command.HashSecret(),
 	}
 
+	// service commands
+	app.Commands = append(app.Commands, notifier.Commands()...)
+
 	if err := app.Run(os.Args); err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,23 @@
+// Package all registers every notification service shipped with pingme.
+// Import it for its side effects to make the services available through
+// the notifier registry.
+package all
+
+import (
+	_ "github.com/kha7iq/pingme/service/discord"
+	_ "github.com/kha7iq/pingme/service/email"
+	_ "github.com/kha7iq/pingme/service/gotify"
+	_ "github.com/kha7iq/pingme/service/line"
+	_ "github.com/kha7iq/pingme/service/mastodon"
+	_ "github.com/kha7iq/pingme/service/matrix"
+	_ "github.com/kha7iq/pingme/service/mattermost"
+	_ "github.com/kha7iq/pingme/service/msteams"
+	_ "github.com/kha7iq/pingme/service/pushbullet"
+	_ "github.com/kha7iq/pingme/service/pushover"
+	_ "github.com/kha7iq/pingme/service/rocketchat"
+	_ "github.com/kha7iq/pingme/service/slack"
+	_ "github.com/kha7iq/pingme/service/telegram"
+	_ "github.com/kha7iq/pingme/service/twillio"
+	_ "github.com/kha7iq/pingme/service/wechat"
+	_ "github.com/kha7iq/pingme/service/zulip"
+)
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
 
 	"github.com/nikoksr/notify"
 	"github.com/nikoksr/notify/service/discord"
@@ -15,15 +17,58 @@ import (
 
 // discordPingMe struct holds data parsed via flags for discord service.
 type discordPingMe struct {
-	Token   string
-	Message string
-	Channel string
-	Title   string
+	Token       string
+	Message     string
+	MessageFile string
+	Channel     string
+	Title       string
+}
+
+// maxLength is the maximum length of a discord message including the title.
+const maxLength = 2000
+
+// Config holds the settings used by the discord Notifier.
+type Config struct {
+	Token    string `config:"token" env:"DISCORD_TOKEN"`
+	Channels string `config:"channels" env:"DISCORD_CHANNELS"`
+}
+
+// Notifier sends messages to discord channels.
+type Notifier struct {
+	Config
+}
+
+// New returns a Notifier for the given config.
+func New(cfg Config) *Notifier {
+	return &Notifier{Config: cfg}
+}
+
+// Send implements notifier.Notifier.
+func (n *Notifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	title, body := helpers.Truncate(msg.Title, msg.Body, maxLength)
+	if err := SendMessage(ctx, n.Token, n.Channels, title, body); err != nil {
+		return notifier.Result{}, err
+	}
+	return notifier.Result{Service: "discord", SentAt: time.Now()}, nil
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name:    "discord",
+		Command: Send,
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			var cfg Config
+			if err := notifier.Load(src, &cfg); err != nil {
+				return nil, err
+			}
+			return New(cfg), nil
+		},
+	})
 }
 
 // SendMessage sends a message to discord channels.
 // channels can be comma-separated string of channel IDs.
-func SendMessage(token, channels, title, message string) error {
+func SendMessage(ctx context.Context, token, channels, title, message string) error {
 	if token == "" {
 		return fmt.Errorf("discord token is required")
 	}
@@ -52,7 +97,7 @@ func SendMessage(token, channels, title, message string) error {
 
 	notifier.UseServices(discordSvc)
 
-	if err := notifier.Send(context.Background(), title, message); err != nil {
+	if err := notifier.Send(ctx, title, message); err != nil {
 		return fmt.Errorf("failed to send discord message: %w", err)
 	}
 
@@ -91,6 +136,7 @@ All configuration options are also available via environment variables.`,
 				Usage:       "Message content.",
 				EnvVars:     []string{"DISCORD_MESSAGE"},
 			},
+			helpers.MessageFileFlag(&discordOpts.MessageFile, "DISCORD"),
 			&cli.StringFlag{
 				Destination: &discordOpts.Title,
 				Name:        "title",
@@ -100,12 +146,21 @@ All configuration options are also available via environment variables.`,
 			},
 		},
 		Action: func(ctx *cli.Context) error {
-			return SendMessage(
-				discordOpts.Token,
-				discordOpts.Channel,
-				discordOpts.Title,
-				discordOpts.Message,
-			)
+			message, err := helpers.ReadMessage(discordOpts.Message, discordOpts.MessageFile)
+			if err != nil {
+				return err
+			}
+			discordOpts.Title, message = helpers.Truncate(discordOpts.Title, message, maxLength)
+
+			return helpers.Retry(ctx, func() error {
+				return SendMessage(
+					ctx.Context,
+					discordOpts.Token,
+					discordOpts.Channel,
+					discordOpts.Title,
+					message,
+				)
+			})
 		},
 	}
 }
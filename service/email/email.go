@@ -2,14 +2,20 @@ package email
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"net/smtp"
+	"net/textproto"
 	"strings"
+	"time"
 
 	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
 
-	"github.com/nikoksr/notify"
-	"github.com/nikoksr/notify/service/mail"
+	mail "github.com/jordan-wright/email"
 	"github.com/urfave/cli/v2"
 )
 
@@ -21,13 +27,56 @@ type email struct {
 	ReceiverAddress string
 	Subject         string
 	Message         string
+	MessageFile     string
 	Port            string
 	Identity        string
 }
 
+// Config holds the settings used by the email Notifier.
+type Config struct {
+	Sender    string `config:"sender" env:"EMAIL_SENDER"`
+	Password  string `config:"password" env:"EMAIL_PASSWORD"`
+	Host      string `config:"host" env:"EMAIL_HOST" default:"smtp.gmail.com"`
+	Port      string `config:"port" env:"EMAIL_PORT" default:"587"`
+	Identity  string `config:"identity" env:"EMAIL_IDENTITY"`
+	Receivers string `config:"receivers" env:"EMAIL_RECEIVER"`
+}
+
+// Notifier sends emails through an SMTP server.
+type Notifier struct {
+	Config
+}
+
+// New returns a Notifier for the given config.
+func New(cfg Config) *Notifier {
+	return &Notifier{Config: cfg}
+}
+
+// Send implements notifier.Notifier.
+func (n *Notifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	if err := SendMessage(ctx, n.Sender, n.Password, n.Host, n.Port, n.Identity, n.Receivers, msg.Title, msg.Body); err != nil {
+		return notifier.Result{}, err
+	}
+	return notifier.Result{Service: "email", SentAt: time.Now()}, nil
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name:    "email",
+		Command: Send,
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			var cfg Config
+			if err := notifier.Load(src, &cfg); err != nil {
+				return nil, err
+			}
+			return New(cfg), nil
+		},
+	})
+}
+
 // SendMessage sends an email to multiple receivers.
 // receivers can be comma-separated string of email addresses.
-func SendMessage(senderAddress, password, host, port, identity, receivers, subject, message string) error {
+func SendMessage(ctx context.Context, senderAddress, password, host, port, identity, receivers, subject, message string) error {
 	if senderAddress == "" {
 		return fmt.Errorf("sender email address is required")
 	}
@@ -47,22 +96,29 @@ func SendMessage(senderAddress, password, host, port, identity, receivers, subje
 		return fmt.Errorf("message is required")
 	}
 
-	notifier := notify.New()
-	emailSvc := mail.New(senderAddress, host+":"+port)
-	emailSvc.AuthenticateSMTP(identity, senderAddress, password, host)
-
-	chn := strings.Split(receivers, ",")
-	for _, v := range chn {
+	var to []string
+	for _, v := range strings.Split(receivers, ",") {
 		v = strings.TrimSpace(v)
 		if len(v) <= 0 {
 			return helpers.ErrChannel
 		}
-		emailSvc.AddReceivers(v)
+		to = append(to, v)
 	}
 
-	notifier.UseServices(emailSvc)
+	msg := &mail.Email{
+		From:    senderAddress,
+		To:      to,
+		Subject: subject,
+		HTML:    []byte(message),
+		Headers: textproto.MIMEHeader{},
+	}
+	raw, err := msg.Bytes()
+	if err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
 
-	if err := notifier.Send(context.Background(), subject, message); err != nil {
+	auth := smtp.PlainAuth(identity, senderAddress, password, host)
+	if err := sendMail(ctx, net.JoinHostPort(host, port), auth, senderAddress, to, raw); err != nil {
 		return fmt.Errorf("failed to send email: %w", err)
 	}
 
@@ -70,6 +126,71 @@ func SendMessage(senderAddress, password, host, port, identity, receivers, subje
 	return nil
 }
 
+// sendMail works like smtp.SendMail, the connection to the server is bound
+// to ctx and closed when ctx is done.
+func sendMail(ctx context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) (err error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// unblock the conversation with the server when ctx is cancelled or
+	// its deadline passes, ctx.Err() is set by then
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
+	host, _, _ := net.SplitHostPort(addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err = c.Hello("localhost"); err != nil {
+		return err
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err = c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err = c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
 // Send parses values from *cli.context and return *cli.Command.
 func Send() *cli.Command {
 	var emailOpts email
@@ -138,6 +259,7 @@ All configuration options are also available via environment variables.`,
 				Aliases:     []string{"m"},
 				EnvVars:     []string{"EMAIL_MESSAGE"},
 			},
+			helpers.MessageFileFlag(&emailOpts.MessageFile, "EMAIL"),
 			&cli.StringFlag{
 				Destination: &emailOpts.Subject,
 				Name:        "sub",
@@ -147,16 +269,24 @@ All configuration options are also available via environment variables.`,
 			},
 		},
 		Action: func(ctx *cli.Context) error {
-			return SendMessage(
-				emailOpts.SenderAddress,
-				emailOpts.Password,
-				emailOpts.Host,
-				emailOpts.Port,
-				emailOpts.Identity,
-				emailOpts.ReceiverAddress,
-				emailOpts.Subject,
-				emailOpts.Message,
-			)
+			message, err := helpers.ReadMessage(emailOpts.Message, emailOpts.MessageFile)
+			if err != nil {
+				return err
+			}
+
+			return helpers.Retry(ctx, func() error {
+				return SendMessage(
+					ctx.Context,
+					emailOpts.SenderAddress,
+					emailOpts.Password,
+					emailOpts.Host,
+					emailOpts.Port,
+					emailOpts.Identity,
+					emailOpts.ReceiverAddress,
+					emailOpts.Subject,
+					message,
+				)
+			})
 		},
 	}
 }
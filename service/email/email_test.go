@@ -0,0 +1,98 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSMTP serves a minimal SMTP conversation on a local port and sends
+// the received message to data. With silent the server never greets.
+func fakeSMTP(t *testing.T, silent bool, data chan<- string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if silent {
+			_, _ = conn.Read(make([]byte, 1))
+			return
+		}
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { _, _ = conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO":
+				reply("250-localhost")
+				reply("250 AUTH PLAIN")
+			case "AUTH":
+				reply("235 Authentication successful")
+			case "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var msg strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					msg.WriteString(l)
+				}
+				data <- msg.String()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestSendMessage(t *testing.T) {
+	data := make(chan string, 1)
+	host, port, _ := net.SplitHostPort(fakeSMTP(t, false, data))
+
+	err := SendMessage(context.Background(), "pingme@example.com", "secret", host, port, "",
+		"a@example.com, b@example.com", "Disk full", "db-1")
+	assert.Nil(t, err)
+	msg := <-data
+	assert.Contains(t, msg, "Subject: Disk full")
+	assert.Contains(t, msg, "To: <a@example.com>, <b@example.com>")
+	assert.Contains(t, msg, "db-1")
+}
+
+func TestSendMessage_Cancelled(t *testing.T) {
+	host, port, _ := net.SplitHostPort(fakeSMTP(t, true, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	err := SendMessage(ctx, "pingme@example.com", "secret", host, port, "", "a@example.com", "title", "hello")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), time.Second, "the connection is closed when the context is cancelled")
+
+	host, port, _ = net.SplitHostPort(fakeSMTP(t, true, nil))
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = SendMessage(ctx, "pingme@example.com", "secret", host, port, "", "a@example.com", "title", "hello")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
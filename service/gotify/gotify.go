@@ -1,12 +1,15 @@
 package gotify
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
 
 	"github.com/gotify/go-api-client/v2/auth"
 	"github.com/gotify/go-api-client/v2/client/message"
@@ -17,15 +20,59 @@ import (
 
 // Gotify struct holds data parsed via flags for the service
 type Gotify struct {
-	URL      string
-	Token    string
-	Priority int
-	Title    string
-	Message  string
+	URL         string
+	Token       string
+	Priority    int
+	Title       string
+	Message     string
+	MessageFile string
+}
+
+// Config holds the settings used by the gotify Notifier.
+type Config struct {
+	URL      string `config:"url" env:"GOTIFY_URL"`
+	Token    string `config:"token" env:"GOTIFY_TOKEN"`
+	Priority int    `config:"priority" env:"GOTIFY_PRIORITY" default:"5"`
+}
+
+// Notifier sends push notifications to a gotify server.
+type Notifier struct {
+	Config
+}
+
+// New returns a Notifier for the given config.
+func New(cfg Config) *Notifier {
+	return &Notifier{Config: cfg}
+}
+
+// Send implements notifier.Notifier.
+func (n *Notifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	priority := n.Priority
+	if msg.Priority != 0 || msg.PrioritySet {
+		priority = msg.Priority
+	}
+	if err := SendMessage(ctx, n.URL, n.Token, msg.Title, msg.Body, priority); err != nil {
+		return notifier.Result{}, err
+	}
+	return notifier.Result{Service: "gotify", SentAt: time.Now()}, nil
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name:    "gotify",
+		Command: Send,
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			var cfg Config
+			if err := notifier.Load(src, &cfg); err != nil {
+				return nil, err
+			}
+			return New(cfg), nil
+		},
+	})
 }
 
 // SendMessage sends a message to gotify server.
-func SendMessage(serverURL, token, title, msg string, priority int) error {
+func SendMessage(ctx context.Context, serverURL, token, title, msg string, priority int) error {
 	if serverURL == "" {
 		return fmt.Errorf("gotify server URL is required")
 	}
@@ -41,8 +88,8 @@ func SendMessage(serverURL, token, title, msg string, priority int) error {
 		return fmt.Errorf("invalid gotify URL: %w", err)
 	}
 
-	client := gotify.NewClient(parsedURL, &http.Client{})
-	params := message.NewCreateMessageParams()
+	client := gotify.NewClient(parsedURL, &http.Client{Timeout: helpers.DefaultTimeout})
+	params := message.NewCreateMessageParamsWithContext(ctx)
 	params.Body = &models.MessageExternal{
 		Title:    title,
 		Message:  msg,
@@ -91,6 +138,7 @@ func Send() *cli.Command {
 				Usage:       "Message content",
 				EnvVars:     []string{"GOTIFY_MESSAGE"},
 			},
+			helpers.MessageFileFlag(&gotifyOpts.MessageFile, "GOTIFY"),
 			&cli.StringFlag{
 				Destination: &gotifyOpts.Title,
 				Name:        "title",
@@ -108,13 +156,21 @@ func Send() *cli.Command {
 			},
 		},
 		Action: func(ctx *cli.Context) error {
-			return SendMessage(
-				gotifyOpts.URL,
-				gotifyOpts.Token,
-				gotifyOpts.Title,
-				gotifyOpts.Message,
-				gotifyOpts.Priority,
-			)
+			message, err := helpers.ReadMessage(gotifyOpts.Message, gotifyOpts.MessageFile)
+			if err != nil {
+				return err
+			}
+
+			return helpers.Retry(ctx, func() error {
+				return SendMessage(
+					ctx.Context,
+					gotifyOpts.URL,
+					gotifyOpts.Token,
+					gotifyOpts.Title,
+					message,
+					gotifyOpts.Priority,
+				)
+			})
 		},
 	}
 }
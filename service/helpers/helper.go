@@ -1,7 +1,9 @@
 package helpers
 
 import (
+	"context"
 	"errors"
+	"net/http"
 	"time"
 )
 
@@ -11,3 +13,30 @@ var (
 	// TimeValue holds current date and time in unix format.
 	TimeValue = "⏰ " + time.Now().Format(time.UnixDate)
 )
+
+// DefaultTimeout is the timeout used by http clients created by services.
+const DefaultTimeout = 10 * time.Second
+
+// NewHTTPClient returns an http client with DefaultTimeout which binds every
+// request it sends to ctx. It is meant for client libraries that accept an
+// *http.Client but have no context aware methods.
+func NewHTTPClient(ctx context.Context) *http.Client {
+	return &http.Client{
+		Timeout: DefaultTimeout,
+		Transport: &contextTransport{
+			ctx:  ctx,
+			base: http.DefaultTransport,
+		},
+	}
+}
+
+// contextTransport attaches ctx to outgoing requests.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
@@ -0,0 +1,107 @@
+package helpers
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/urfave/cli/v2"
+)
+
+// MaxMessageSize is the maximum size in bytes of a message read from stdin or a file.
+const MaxMessageSize = 1 << 20
+
+// stdin returns the source of piped messages, os.Stdin is looked up on
+// every call so callers can redirect it.
+var stdin = func() *os.File { return os.Stdin }
+
+// MessageFileFlag returns the --msg-file flag shared by all service commands.
+// envPrefix is the prefix of the service environment variables, e.g. "SLACK".
+func MessageFileFlag(dest *string, envPrefix string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Destination: dest,
+		Name:        "msg-file",
+		Usage:       "Read message content from file, use --msg '-' to read from stdin.",
+		EnvVars:     []string{envPrefix + "_MESSAGE_FILE"},
+	}
+}
+
+// ReadMessage resolves the message content of a command. msg is the value of
+// the --msg flag and file the value of --msg-file.
+// The message is read from stdin when msg is "-", or when neither msg nor file
+// is set and stdin is not a terminal, e.g. `make 2>&1 | pingme slack`.
+func ReadMessage(msg, file string) (string, error) {
+	switch {
+	case msg == "-":
+		return readLimited(stdin(), "stdin")
+	case msg != "":
+		return msg, nil
+	case file != "":
+		f, err := os.Open(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to open message file: %w", err)
+		}
+		defer f.Close()
+		return readLimited(f, file)
+	case isPiped(stdin()):
+		return readLimited(stdin(), "stdin")
+	}
+	return "", nil
+}
+
+// readLimited reads r up to MaxMessageSize bytes.
+func readLimited(r io.Reader, name string) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxMessageSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read message from %s: %w", name, err)
+	}
+	if len(data) > MaxMessageSize {
+		return "", fmt.Errorf("message from %s exceeds %d bytes", name, MaxMessageSize)
+	}
+	return strings.TrimRight(string(data), "\n"), nil
+}
+
+// isPiped reports whether f is a pipe or file rather than a terminal.
+func isPiped(f *os.File) bool {
+	if f == nil {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice == 0 && (fi.Mode()&os.ModeNamedPipe != 0 || fi.Mode().IsRegular())
+}
+
+// Truncate shortens title and message so that they fit into limit
+// characters joined by a newline, an ellipsis marks a cut. The message is
+// cut first, a title longer than half the limit is cut too so some of the
+// message is always left. A limit <= 0 disables it.
+func Truncate(title, message string, limit int) (string, string) {
+	if limit <= 0 {
+		return title, message
+	}
+
+	titleLen, messageLen := utf8.RuneCountInString(title), utf8.RuneCountInString(message)
+	if titleLen+1+messageLen <= limit {
+		return title, message
+	}
+	if titleLen > limit/2 {
+		title = cut(title, max(limit/2, limit-1-messageLen))
+	}
+	return title, cut(message, limit-utf8.RuneCountInString(title)-1)
+}
+
+// cut shortens s to n characters, the last one an ellipsis.
+func cut(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	if n <= 1 {
+		return ""
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,85 @@
+package helpers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pipeStdin replaces stdin with a pipe containing content.
+func pipeStdin(t *testing.T, content string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	_, err = w.WriteString(content)
+	assert.Nil(t, err)
+	w.Close()
+
+	orig := stdin
+	stdin = func() *os.File { return r }
+	t.Cleanup(func() {
+		stdin = orig
+		r.Close()
+	})
+}
+
+func TestReadMessage_Flag(t *testing.T) {
+	pipeStdin(t, "from stdin")
+	msg, err := ReadMessage("from flag", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "from flag", msg)
+}
+
+func TestReadMessage_Stdin(t *testing.T) {
+	pipeStdin(t, "build failed\n")
+	msg, err := ReadMessage("-", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "build failed", msg)
+}
+
+func TestReadMessage_PipedWithoutFlag(t *testing.T) {
+	pipeStdin(t, "piped output\n")
+	msg, err := ReadMessage("", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "piped output", msg)
+}
+
+func TestReadMessage_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "msg.txt")
+	assert.Nil(t, os.WriteFile(path, []byte("from file\n"), 0o600))
+
+	msg, err := ReadMessage("", path)
+	assert.Nil(t, err)
+	assert.Equal(t, "from file", msg)
+
+	_, err = ReadMessage("", filepath.Join(t.TempDir(), "missing.txt"))
+	assert.NotNil(t, err)
+}
+
+func TestReadMessage_TooLarge(t *testing.T) {
+	pipeStdin(t, "")
+	path := filepath.Join(t.TempDir(), "big.txt")
+	assert.Nil(t, os.WriteFile(path, []byte(strings.Repeat("a", MaxMessageSize+1)), 0o600))
+
+	_, err := ReadMessage("", path)
+	assert.NotNil(t, err)
+}
+
+func TestTruncate(t *testing.T) {
+	truncate := func(title, message string, limit int) []string {
+		title, message = Truncate(title, message, limit)
+		return []string{title, message}
+	}
+	assert.Equal(t, []string{"title", "hello"}, truncate("title", "hello", 0))
+	assert.Equal(t, []string{"title", "hello"}, truncate("title", "hello", 11))
+	assert.Equal(t, []string{"title", "hel…"}, truncate("title", "hello", 10))
+	assert.Equal(t, []string{"", "ünï…"}, truncate("", "ünïcode", 5))
+
+	// long titles leave room for the message
+	assert.Equal(t, []string{"a l…", "hel…"}, truncate("a long title", "hello", 9))
+	assert.Equal(t, []string{"a long…", "hi"}, truncate("a long title", "hi", 10))
+	assert.Equal(t, []string{"a long …", "hello w…"}, truncate("a long title", "hello world", 17))
+}
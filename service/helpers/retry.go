@@ -0,0 +1,301 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// RetryPolicy controls how often and how fast a failed send is retried.
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts, 1 disables retries.
+	Attempts int `yaml:"attempts"`
+	// Delay is the wait before the first retry.
+	Delay time.Duration `yaml:"delay"`
+	// MaxDelay caps the wait between two attempts.
+	MaxDelay time.Duration `yaml:"max_delay"`
+	// Multiplier grows the delay after every attempt.
+	Multiplier float64 `yaml:"multiplier"`
+	// Jitter randomizes each delay by up to this fraction, e.g. 0.2 is ±20%.
+	Jitter float64 `yaml:"jitter"`
+}
+
+// DefaultRetryPolicy is used when no retry settings are given.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:   3,
+	Delay:      time.Second,
+	MaxDelay:   30 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// RetryOverride changes single fields of a RetryPolicy, nil fields are
+// kept. Unlike a RetryPolicy it can set fields to zero, e.g. jitter: 0.
+type RetryOverride struct {
+	Attempts   *int           `yaml:"attempts"`
+	Delay      *time.Duration `yaml:"delay"`
+	MaxDelay   *time.Duration `yaml:"max_delay"`
+	Multiplier *float64       `yaml:"multiplier"`
+	Jitter     *float64       `yaml:"jitter"`
+}
+
+// Merge returns p with every field set in o applied on top.
+func (p RetryPolicy) Merge(o *RetryOverride) RetryPolicy {
+	if o == nil {
+		return p
+	}
+	if o.Attempts != nil {
+		p.Attempts = *o.Attempts
+	}
+	if o.Delay != nil {
+		p.Delay = *o.Delay
+	}
+	if o.MaxDelay != nil {
+		p.MaxDelay = *o.MaxDelay
+	}
+	if o.Multiplier != nil {
+		p.Multiplier = *o.Multiplier
+	}
+	if o.Jitter != nil {
+		p.Jitter = *o.Jitter
+	}
+	return p
+}
+
+// Backoff returns the delay before the given retry, starting at 1.
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(p.Delay) * math.Pow(multiplier, float64(retry-1))
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	return time.Duration(delay)
+}
+
+// Do calls fn until it succeeds, returns an error that is not retryable,
+// the attempts are used up or ctx is done. It returns the number of
+// attempts made and the last error.
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) (int, error) {
+	attempts := p.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return attempt, nil
+		}
+
+		retry, after := Retryable(err)
+		if !retry || attempt >= attempts || ctx.Err() != nil {
+			return attempt, err
+		}
+
+		delay := p.Backoff(attempt)
+		if after > delay {
+			delay = after
+		}
+		if p.MaxDelay > 0 && delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+		log.Printf("Attempt %d/%d failed: %v, retrying in %s", attempt, attempts, err, delay.Round(time.Millisecond))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		case <-timer.C:
+		}
+	}
+}
+
+// StatusError is returned by services for http responses with a status
+// worth retrying.
+type StatusError struct {
+	StatusCode int
+	// RetryAfter is the wait requested by the server, if any.
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("unexpected status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("unexpected status %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// PartialError is returned by services sending a message to several
+// recipients one by one when some of them already got it. It is not
+// retryable, a retry would send the message to them again.
+type PartialError struct {
+	// Sent is the number of recipients that got the message.
+	Sent int
+	Err  error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%v, sent to %d recipient(s) before", e.Err, e.Sent)
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+// Partial returns err as a *PartialError if sent recipients got the
+// message before it failed, err otherwise.
+func Partial(sent int, err error) error {
+	if err == nil || sent == 0 {
+		return err
+	}
+	return &PartialError{Sent: sent, Err: err}
+}
+
+// CheckResponse returns a *StatusError if resp has a retryable status,
+// 429 or 5xx. Other responses return nil and are left to the caller, whose
+// body is not consumed.
+func CheckResponse(resp *http.Response) error {
+	if !retryableStatus(resp.StatusCode) {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: ParseRetryAfter(resp.Header.Get("Retry-After")),
+		Body:       strings.TrimSpace(string(body)),
+	}
+}
+
+// ParseRetryAfter parses the value of a Retry-After header, given either
+// in seconds or as an http date. Invalid values return 0.
+func ParseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// Retryable classifies err as transient or not. Network errors, timeouts,
+// 429 and 5xx responses and temporary SMTP errors are retryable, unless
+// some recipients already got the message. The second value is the wait
+// requested by the server, if any.
+func Retryable(err error) (bool, time.Duration) {
+	var partial *PartialError
+	if err == nil || errors.Is(err, context.Canceled) || errors.As(err, &partial) {
+		return false, 0
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return true, statusErr.RetryAfter
+	}
+
+	// status errors of client libraries, e.g. slack
+	var httpStatus interface{ HTTPStatusCode() int }
+	if errors.As(err, &httpStatus) {
+		return retryableStatus(httpStatus.HTTPStatusCode()), 0
+	}
+	var retryable interface{ Retryable() bool }
+	if errors.As(err, &retryable) {
+		return retryable.Retryable(), 0
+	}
+
+	// smtp replies with 4xx for transient failures
+	var smtpErr *textproto.Error
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Code >= 400 && smtpErr.Code < 500, 0
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return false, 0
+		}
+		return true, 0
+	}
+
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE), 0
+}
+
+// retryableStatus reports whether an http status is worth retrying.
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500 && code != http.StatusNotImplemented
+}
+
+// RetryFlags returns the global flags configuring retries.
+func RetryFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:    "retry-attempts",
+			Value:   DefaultRetryPolicy.Attempts,
+			Usage:   "Maximum number of attempts for transient failures, 1 disables retries.",
+			EnvVars: []string{"PINGME_RETRY_ATTEMPTS"},
+		},
+		&cli.DurationFlag{
+			Name:    "retry-delay",
+			Value:   DefaultRetryPolicy.Delay,
+			Usage:   "Wait before the first retry, doubled after every attempt.",
+			EnvVars: []string{"PINGME_RETRY_DELAY"},
+		},
+		&cli.DurationFlag{
+			Name:    "retry-max-delay",
+			Value:   DefaultRetryPolicy.MaxDelay,
+			Usage:   "Maximum wait between two attempts.",
+			EnvVars: []string{"PINGME_RETRY_MAX_DELAY"},
+		},
+	}
+}
+
+// RetryPolicyFromFlags returns base with the retry flags applied that were
+// explicitly set on the command line or through environment variables.
+func RetryPolicyFromFlags(c *cli.Context, base RetryPolicy) RetryPolicy {
+	if c.IsSet("retry-attempts") {
+		base.Attempts = c.Int("retry-attempts")
+	}
+	if c.IsSet("retry-delay") {
+		base.Delay = c.Duration("retry-delay")
+	}
+	if c.IsSet("retry-max-delay") {
+		base.MaxDelay = c.Duration("retry-max-delay")
+	}
+	return base
+}
+
+// Retry runs fn with the retry policy of the command line flags. It is
+// used by service commands to retry transient failures.
+func Retry(c *cli.Context, fn func() error) error {
+	_, err := RetryPolicyFromFlags(c, DefaultRetryPolicy).Do(c.Context, fn)
+	return err
+}
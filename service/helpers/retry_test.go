@@ -0,0 +1,127 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testPolicy = RetryPolicy{Attempts: 3, Delay: time.Millisecond, MaxDelay: 10 * time.Millisecond, Multiplier: 2}
+
+func TestRetryPolicyDo(t *testing.T) {
+	calls := 0
+	attempts, err := testPolicy.Do(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return &StatusError{StatusCode: http.StatusBadGateway}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestRetryPolicyDo_GivesUp(t *testing.T) {
+	attempts, err := testPolicy.Do(context.Background(), func() error {
+		return io.ErrUnexpectedEOF
+	})
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestRetryPolicyDo_NotRetryable(t *testing.T) {
+	attempts, err := testPolicy.Do(context.Background(), func() error {
+		return errors.New("invalid token")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRetryPolicyDo_ContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts, err := testPolicy.Do(ctx, func() error {
+		return io.ErrUnexpectedEOF
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{Delay: time.Second, MaxDelay: 5 * time.Second, Multiplier: 2}
+	assert.Equal(t, time.Second, p.Backoff(1))
+	assert.Equal(t, 4*time.Second, p.Backoff(3))
+	assert.Equal(t, 5*time.Second, p.Backoff(4))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.Backoff(1)
+		assert.True(t, d >= 500*time.Millisecond && d <= 1500*time.Millisecond, d)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	attempts := 5
+	p := DefaultRetryPolicy.Merge(&RetryOverride{Attempts: &attempts})
+	assert.Equal(t, 5, p.Attempts)
+	assert.Equal(t, DefaultRetryPolicy.Delay, p.Delay)
+	assert.Equal(t, DefaultRetryPolicy, DefaultRetryPolicy.Merge(nil))
+
+	// zero values override too
+	jitter := 0.0
+	p = DefaultRetryPolicy.Merge(&RetryOverride{Jitter: &jitter})
+	assert.Equal(t, 0.0, p.Jitter)
+	assert.Equal(t, DefaultRetryPolicy.Multiplier, p.Multiplier)
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err   error
+		retry bool
+	}{
+		{&StatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{fmt.Errorf("send: %w", &StatusError{StatusCode: http.StatusServiceUnavailable}), true},
+		{&textproto.Error{Code: 421, Msg: "try again later"}, true},
+		{&textproto.Error{Code: 550, Msg: "mailbox unavailable"}, false},
+		{io.ErrUnexpectedEOF, true},
+		{context.Canceled, false},
+		{errors.New("channel_not_found"), false},
+		{Partial(1, &StatusError{StatusCode: http.StatusServiceUnavailable}), false},
+		{Partial(0, &StatusError{StatusCode: http.StatusServiceUnavailable}), true},
+	}
+	for _, tt := range tests {
+		retry, _ := Retryable(tt.err)
+		assert.Equal(t, tt.retry, retry, tt.err.Error())
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": []string{"7"}},
+		Body:       io.NopCloser(strings.NewReader("slow down")),
+	}
+	err := CheckResponse(resp)
+	retry, after := Retryable(err)
+	assert.True(t, retry)
+	assert.Equal(t, 7*time.Second, after)
+	assert.Contains(t, err.Error(), "slow down")
+
+	resp = &http.Response{StatusCode: http.StatusUnauthorized, Body: io.NopCloser(strings.NewReader(""))}
+	assert.Nil(t, CheckResponse(resp))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, 3*time.Second, ParseRetryAfter("3"))
+	assert.Equal(t, time.Duration(0), ParseRetryAfter("soon"))
+	d := ParseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.True(t, d > 50*time.Second && d <= time.Minute, d)
+}
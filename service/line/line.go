@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
+
 	"github.com/nikoksr/notify"
 	"github.com/nikoksr/notify/service/line"
 	"github.com/urfave/cli/v2"
@@ -14,16 +17,56 @@ import (
 
 // Line struct holds data parsed via flags for the service
 type Line struct {
-	Secret    string
-	Token     string
-	Message   string
-	Receivers string
-	Title     string
+	Secret      string
+	Token       string
+	Message     string
+	MessageFile string
+	Receivers   string
+	Title       string
+}
+
+// Config holds the settings used by the line Notifier.
+type Config struct {
+	Secret    string `config:"secret" env:"LINE_SECRET"`
+	Token     string `config:"token" env:"LINE_TOKEN"`
+	Receivers string `config:"receivers" env:"LINE_RECEIVER_IDS"`
+}
+
+// Notifier sends messages to line messenger receivers.
+type Notifier struct {
+	Config
+}
+
+// New returns a Notifier for the given config.
+func New(cfg Config) *Notifier {
+	return &Notifier{Config: cfg}
+}
+
+// Send implements notifier.Notifier.
+func (n *Notifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	if err := SendMessage(ctx, n.Secret, n.Token, n.Receivers, msg.Title, msg.Body); err != nil {
+		return notifier.Result{}, err
+	}
+	return notifier.Result{Service: "line", SentAt: time.Now()}, nil
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name:    "line",
+		Command: Send,
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			var cfg Config
+			if err := notifier.Load(src, &cfg); err != nil {
+				return nil, err
+			}
+			return New(cfg), nil
+		},
+	})
 }
 
 // SendMessage sends a message to line messenger receivers.
 // receivers can be comma-separated string of user or group IDs.
-func SendMessage(secret, token, receivers, title, message string) error {
+func SendMessage(ctx context.Context, secret, token, receivers, title, message string) error {
 	if secret == "" {
 		return fmt.Errorf("line channel secret is required")
 	}
@@ -55,7 +98,7 @@ func SendMessage(secret, token, receivers, title, message string) error {
 
 	notifier.UseServices(lineSvc)
 
-	if err := notifier.Send(context.Background(), title, message); err != nil {
+	if err := notifier.Send(ctx, title, message); err != nil {
 		return fmt.Errorf("failed to send line message: %w", err)
 	}
 
@@ -91,10 +134,10 @@ through line to various receivers.`,
 			&cli.StringFlag{
 				Destination: &lineOpts.Message,
 				Name:        "msg",
-				Required:    true,
 				Usage:       "Message content.",
 				EnvVars:     []string{"LINE_MESSAGE"},
 			},
+			helpers.MessageFileFlag(&lineOpts.MessageFile, "LINE"),
 			&cli.StringFlag{
 				Destination: &lineOpts.Title,
 				Name:        "title",
@@ -111,13 +154,21 @@ through line to various receivers.`,
 			},
 		},
 		Action: func(ctx *cli.Context) error {
-			return SendMessage(
-				lineOpts.Secret,
-				lineOpts.Token,
-				lineOpts.Receivers,
-				lineOpts.Title,
-				lineOpts.Message,
-			)
+			message, err := helpers.ReadMessage(lineOpts.Message, lineOpts.MessageFile)
+			if err != nil {
+				return err
+			}
+
+			return helpers.Retry(ctx, func() error {
+				return SendMessage(
+					ctx.Context,
+					lineOpts.Secret,
+					lineOpts.Token,
+					lineOpts.Receivers,
+					lineOpts.Title,
+					message,
+				)
+			})
 		},
 	}
 }
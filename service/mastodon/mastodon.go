@@ -2,21 +2,26 @@ package mastodon
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"time"
 
+	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
+
 	"github.com/urfave/cli/v2"
 )
 
 // Mastodon struct holds data parsed via flags for the service
 type Mastodon struct {
-	Title     string
-	Token     string
-	ServerURL string
-	Message   string
+	Title       string
+	Token       string
+	ServerURL   string
+	Message     string
+	MessageFile string
 }
 
 // HTTPClient interface
@@ -32,8 +37,50 @@ func initialize() {
 	}
 }
 
+// maxLength is the maximum length of a mastodon message including the title.
+const maxLength = 500
+
+// Config holds the settings used by the mastodon Notifier.
+type Config struct {
+	Token     string `config:"token" env:"MASTODON_TOKEN"`
+	ServerURL string `config:"server_url" env:"MASTODON_SERVER" default:"mastodon.social"`
+}
+
+// Notifier sets mastodon status messages.
+type Notifier struct {
+	Config
+}
+
+// New returns a Notifier for the given config.
+func New(cfg Config) *Notifier {
+	return &Notifier{Config: cfg}
+}
+
+// Send implements notifier.Notifier.
+func (n *Notifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	title, body := helpers.Truncate(msg.Title, msg.Body, maxLength)
+	if err := SendMessage(ctx, n.Token, n.ServerURL, title, body); err != nil {
+		return notifier.Result{}, err
+	}
+	return notifier.Result{Service: "mastodon", SentAt: time.Now()}, nil
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name:    "mastodon",
+		Command: Send,
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			var cfg Config
+			if err := notifier.Load(src, &cfg); err != nil {
+				return nil, err
+			}
+			return New(cfg), nil
+		},
+	})
+}
+
 // SendMessage sends a status message to mastodon.
-func SendMessage(token, serverURL, title, message string) error {
+func SendMessage(ctx context.Context, token, serverURL, title, message string) error {
 	if token == "" {
 		return fmt.Errorf("mastodon token is required")
 	}
@@ -50,7 +97,7 @@ func SendMessage(token, serverURL, title, message string) error {
 	bearer := "Bearer " + token
 	fullMessage := title + "\n" + message
 
-	if err := sendMastodon(endPointURL, bearer, fullMessage); err != nil {
+	if err := sendMastodon(ctx, endPointURL, bearer, fullMessage); err != nil {
 		return fmt.Errorf("failed to send message: %w", err)
 	}
 
@@ -83,6 +130,7 @@ func Send() *cli.Command {
 				Usage:       "Message content.",
 				EnvVars:     []string{"MASTODON_MESSAGE"},
 			},
+			helpers.MessageFileFlag(&mastodonOpts.MessageFile, "MASTODON"),
 			&cli.StringFlag{
 				Destination: &mastodonOpts.Title,
 				Name:        "title",
@@ -100,19 +148,28 @@ func Send() *cli.Command {
 			},
 		},
 		Action: func(ctx *cli.Context) error {
-			return SendMessage(
-				mastodonOpts.Token,
-				mastodonOpts.ServerURL,
-				mastodonOpts.Title,
-				mastodonOpts.Message,
-			)
+			message, err := helpers.ReadMessage(mastodonOpts.Message, mastodonOpts.MessageFile)
+			if err != nil {
+				return err
+			}
+			mastodonOpts.Title, message = helpers.Truncate(mastodonOpts.Title, message, maxLength)
+
+			return helpers.Retry(ctx, func() error {
+				return SendMessage(
+					ctx.Context,
+					mastodonOpts.Token,
+					mastodonOpts.ServerURL,
+					mastodonOpts.Title,
+					message,
+				)
+			})
 		},
 	}
 }
 
 // sendMastodon function take the server url, authorization token
 // and message string to set the status.
-func sendMastodon(url string, token string, msg string) error {
+func sendMastodon(ctx context.Context, url string, token string, msg string) error {
 	reqBody, err := json.Marshal(map[string]string{
 		"status": msg,
 	})
@@ -120,7 +177,7 @@ func sendMastodon(url string, token string, msg string) error {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBody))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(reqBody))
 	if err != nil {
 		return err
 	}
@@ -134,6 +191,10 @@ func sendMastodon(url string, token string, msg string) error {
 	}
 	defer resp.Body.Close()
 
+	if err = helpers.CheckResponse(resp); err != nil {
+		return err
+	}
+
 	var data map[string]interface{}
 	err = json.NewDecoder(resp.Body).Decode(&data)
 	if err != nil {
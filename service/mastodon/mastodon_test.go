@@ -2,6 +2,7 @@ package mastodon
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
@@ -47,7 +48,7 @@ func TestSendMessage_Success(t *testing.T) {
 		Message:   "message",
 	}
 
-	err := sendMastodon(m.ServerURL, m.Token, m.Message)
+	err := sendMastodon(context.Background(), m.ServerURL, m.Token, m.Message)
 	assert.Nil(t, err)
 }
 
@@ -76,6 +77,6 @@ func TestSendMessage_Failure(t *testing.T) {
 		Message:   "message",
 	}
 
-	err := sendMastodon(m.ServerURL, m.Token, m.Message)
+	err := sendMastodon(context.Background(), m.ServerURL, m.Token, m.Message)
 	assert.NotNil(t, err)
 }
@@ -1,29 +1,93 @@
 package matrix
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
+	"time"
+
+	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
 
 	"github.com/matrix-org/gomatrix"
 	"github.com/urfave/cli/v2"
 )
 
 type matrixPingMe struct {
-	Username   string
-	Password   string
-	Token      string
-	Url        string
-	ServerName string
-	Room       string
-	RoomID     string
-	Domain     string
-	Message    string
-	AutoJoin   bool
+	Username    string
+	Password    string
+	Token       string
+	Url         string
+	ServerName  string
+	Room        string
+	RoomID      string
+	Domain      string
+	Message     string
+	MessageFile string
+	AutoJoin    bool
+}
+
+// Config holds the settings used by the matrix Notifier.
+type Config struct {
+	ServerURL  string `config:"server_url" env:"MATRIX_SERVER_URL"`
+	Username   string `config:"username" env:"MATRIX_USER"`
+	Password   string `config:"password" env:"MATRIX_PASSWORD"`
+	Token      string `config:"token" env:"MATRIX_ACCESS_TOKEN"`
+	Room       string `config:"room" env:"MATRIX_ROOM"`
+	RoomID     string `config:"room_id" env:"MATRIX_ROOM_ID"`
+	Domain     string `config:"domain" env:"MATRIX_DOMAIN"`
+	ServerName string `config:"server_name" env:"MATRIX_SERVER_NAME"`
+	AutoJoin   bool   `config:"auto_join" env:"MATRIX_AUTO_JOIN"`
+}
+
+// Notifier sends messages to a matrix room.
+type Notifier struct {
+	Config
+}
+
+// New returns a Notifier for the given config.
+func New(cfg Config) *Notifier {
+	return &Notifier{Config: cfg}
+}
+
+// Send implements notifier.Notifier.
+func (n *Notifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	err := SendMessage(
+		ctx,
+		n.ServerURL,
+		n.Username,
+		n.Password,
+		n.Token,
+		n.Room,
+		n.RoomID,
+		n.Domain,
+		n.ServerName,
+		msg.Body,
+		n.AutoJoin,
+	)
+	if err != nil {
+		return notifier.Result{}, err
+	}
+	return notifier.Result{Service: "matrix", SentAt: time.Now()}, nil
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name:    "matrix",
+		Command: Send,
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			var cfg Config
+			if err := notifier.Load(src, &cfg); err != nil {
+				return nil, err
+			}
+			return New(cfg), nil
+		},
+	})
 }
 
 // SendMessage sends a message to a matrix room.
-func SendMessage(serverURL, username, password, token, room, roomID, domain, serverName, message string, autoJoin bool) error {
+func SendMessage(ctx context.Context, serverURL, username, password, token, room, roomID, domain, serverName, message string, autoJoin bool) error {
 	if serverURL == "" {
 		return fmt.Errorf("matrix server URL is required")
 	}
@@ -45,7 +109,7 @@ func SendMessage(serverURL, username, password, token, room, roomID, domain, ser
 	}
 
 	// Login
-	client, err := m.login()
+	client, err := m.login(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to login to matrix: %w", err)
 	}
@@ -135,10 +199,10 @@ func Send() *cli.Command {
 				Destination: &matrix.Message,
 				Name:        "msg",
 				Aliases:     []string{"m"},
-				Required:    true,
 				Usage:       "Message to send to matrix",
 				EnvVars:     []string{"MATRIX_MESSAGE"},
 			},
+			helpers.MessageFileFlag(&matrix.MessageFile, "MATRIX"),
 			&cli.BoolFlag{
 				Destination: &matrix.AutoJoin,
 				Name:        "autoJoin",
@@ -147,18 +211,26 @@ func Send() *cli.Command {
 			},
 		},
 		Action: func(ctx *cli.Context) error {
-			return SendMessage(
-				matrix.Url,
-				matrix.Username,
-				matrix.Password,
-				matrix.Token,
-				matrix.Room,
-				matrix.RoomID,
-				matrix.Domain,
-				matrix.ServerName,
-				matrix.Message,
-				matrix.AutoJoin,
-			)
+			message, err := helpers.ReadMessage(matrix.Message, matrix.MessageFile)
+			if err != nil {
+				return err
+			}
+
+			return helpers.Retry(ctx, func() error {
+				return SendMessage(
+					ctx.Context,
+					matrix.Url,
+					matrix.Username,
+					matrix.Password,
+					matrix.Token,
+					matrix.Room,
+					matrix.RoomID,
+					matrix.Domain,
+					matrix.ServerName,
+					message,
+					matrix.AutoJoin,
+				)
+			})
 		},
 	}
 }
@@ -204,11 +276,13 @@ func (m *matrixPingMe) joinRoomIfNecessary(client *gomatrix.Client) error {
 	return nil
 }
 
-func (m *matrixPingMe) login() (*gomatrix.Client, error) {
+func (m *matrixPingMe) login(ctx context.Context) (*gomatrix.Client, error) {
 	client, err := gomatrix.NewClient(m.Url, "", "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create matrix client: %w", err)
 	}
+	// gomatrix has no context aware methods, bind its requests to ctx instead.
+	client.Client = helpers.NewHTTPClient(ctx)
 
 	var resp *gomatrix.RespLogin
 	if m.Token != "" {
@@ -2,6 +2,7 @@ package mattermost
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -10,19 +11,21 @@ import (
 	"time"
 
 	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
 
 	"github.com/urfave/cli/v2"
 )
 
 // matterMost struct holds data parsed via flags for the service
 type matterMost struct {
-	Title     string
-	Token     string
-	ServerURL string
-	Scheme    string
-	APIURL    string
-	Message   string
-	ChanIDs   string
+	Title       string
+	Token       string
+	ServerURL   string
+	Scheme      string
+	APIURL      string
+	Message     string
+	MessageFile string
+	ChanIDs     string
 }
 
 // matterMostResponse struct holds the server responses
@@ -62,9 +65,50 @@ func initialize() {
 	}
 }
 
+// Config holds the settings used by the mattermost Notifier.
+type Config struct {
+	Token     string `config:"token" env:"MATTERMOST_TOKEN"`
+	ServerURL string `config:"server_url" env:"MATTERMOST_SERVER_URL"`
+	Scheme    string `config:"scheme" env:"MATTERMOST_SCHEME" default:"https"`
+	APIURL    string `config:"api_url" env:"MATTERMOST_API_URL" default:"/api/v4/posts"`
+	Channels  string `config:"channels" env:"MATTERMOST_CHANNELS"`
+}
+
+// Notifier sends messages to mattermost channels.
+type Notifier struct {
+	Config
+}
+
+// New returns a Notifier for the given config.
+func New(cfg Config) *Notifier {
+	return &Notifier{Config: cfg}
+}
+
+// Send implements notifier.Notifier.
+func (n *Notifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	if err := SendMessage(ctx, n.Token, n.ServerURL, n.Scheme, n.APIURL, n.Channels, msg.Title, msg.Body); err != nil {
+		return notifier.Result{}, err
+	}
+	return notifier.Result{Service: "mattermost", SentAt: time.Now()}, nil
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name:    "mattermost",
+		Command: Send,
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			var cfg Config
+			if err := notifier.Load(src, &cfg); err != nil {
+				return nil, err
+			}
+			return New(cfg), nil
+		},
+	})
+}
+
 // SendMessage sends a message to mattermost channels.
 // channels can be comma-separated string of channel IDs.
-func SendMessage(token, serverURL, scheme, apiURL, channels, title, message string) error {
+func SendMessage(ctx context.Context, token, serverURL, scheme, apiURL, channels, title, message string) error {
 	if token == "" {
 		return fmt.Errorf("mattermost token is required")
 	}
@@ -85,7 +129,7 @@ func SendMessage(token, serverURL, scheme, apiURL, channels, title, message stri
 	fullMessage := title + "\n" + message
 
 	ids := strings.Split(channels, ",")
-	for _, channelID := range ids {
+	for i, channelID := range ids {
 		channelID = strings.TrimSpace(channelID)
 		if len(channelID) == 0 {
 			return helpers.ErrChannel
@@ -96,8 +140,8 @@ func SendMessage(token, serverURL, scheme, apiURL, channels, title, message stri
 			return fmt.Errorf("error parsing json: %w", err)
 		}
 
-		if err := sendMattermost(endPointURL, bearer, jsonData); err != nil {
-			return fmt.Errorf("failed to send message to channel %s: %w", channelID, err)
+		if err := sendMattermost(ctx, endPointURL, bearer, jsonData); err != nil {
+			return helpers.Partial(i, fmt.Errorf("failed to send message to channel %s: %w", channelID, err))
 		}
 	}
 	return nil
@@ -138,6 +182,7 @@ You can specify multiple channels by separating the value with ','.`,
 				Usage:       "Message content.",
 				EnvVars:     []string{"MATTERMOST_MESSAGE"},
 			},
+			helpers.MessageFileFlag(&mattermostOpts.MessageFile, "MATTERMOST"),
 			&cli.StringFlag{
 				Destination: &mattermostOpts.Title,
 				Name:        "title",
@@ -170,15 +215,23 @@ You can specify multiple channels by separating the value with ','.`,
 			},
 		},
 		Action: func(ctx *cli.Context) error {
-			return SendMessage(
-				mattermostOpts.Token,
-				mattermostOpts.ServerURL,
-				mattermostOpts.Scheme,
-				mattermostOpts.APIURL,
-				mattermostOpts.ChanIDs,
-				mattermostOpts.Title,
-				mattermostOpts.Message,
-			)
+			message, err := helpers.ReadMessage(mattermostOpts.Message, mattermostOpts.MessageFile)
+			if err != nil {
+				return err
+			}
+
+			return helpers.Retry(ctx, func() error {
+				return SendMessage(
+					ctx.Context,
+					mattermostOpts.Token,
+					mattermostOpts.ServerURL,
+					mattermostOpts.Scheme,
+					mattermostOpts.APIURL,
+					mattermostOpts.ChanIDs,
+					mattermostOpts.Title,
+					message,
+				)
+			})
 		},
 	}
 }
@@ -198,10 +251,10 @@ func toJSON(channel string, msg string) ([]byte, error) {
 // sendMattermost function take the server url, authentication token
 // message and channel id in the form of json byte array and sends
 // message to mattermost.
-func sendMattermost(url string, token string, jsonPayload []byte) error {
+func sendMattermost(ctx context.Context, url string, token string, jsonPayload []byte) error {
 	var response matterMostResponse
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonPayload))
 	if err != nil {
 		return err
 	}
@@ -215,6 +268,10 @@ func sendMattermost(url string, token string, jsonPayload []byte) error {
 	}
 	defer resp.Body.Close()
 
+	if err = helpers.CheckResponse(resp); err != nil {
+		return err
+	}
+
 	err = json.NewDecoder(resp.Body).Decode(&response)
 	if err != nil {
 		return err
@@ -2,6 +2,7 @@ package mattermost
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
@@ -67,7 +68,7 @@ func TestSendMessage_Success(t *testing.T) {
 		jsonData, err := toJSON(v, fullMessage)
 		assert.Nil(t, err)
 
-		err = sendMattermost(endPointURL, bearer, jsonData)
+		err = sendMattermost(context.Background(), endPointURL, bearer, jsonData)
 		assert.Nil(t, err)
 	}
 }
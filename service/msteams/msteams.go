@@ -2,10 +2,13 @@ package msteams
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
 
 	"github.com/nikoksr/notify"
 	msteams2 "github.com/nikoksr/notify/service/msteams"
@@ -14,9 +17,80 @@ import (
 
 // msTeams struct holds data parsed via flags for microsoft teams service.
 type msTeams struct {
-	Webhook string
-	Message string
-	Title   string
+	Webhook     string
+	Message     string
+	MessageFile string
+	Title       string
+}
+
+// Config holds the settings used by the msteams Notifier.
+type Config struct {
+	Webhooks string `config:"webhooks" env:"TEAMS_WEBHOOK"`
+}
+
+// Notifier sends messages to microsoft teams webhooks.
+type Notifier struct {
+	Config
+}
+
+// New returns a Notifier for the given config.
+func New(cfg Config) *Notifier {
+	return &Notifier{Config: cfg}
+}
+
+// Send implements notifier.Notifier.
+func (n *Notifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	if err := SendMessage(ctx, n.Webhooks, msg.Title, msg.Body); err != nil {
+		return notifier.Result{}, err
+	}
+	return notifier.Result{Service: "msteams", SentAt: time.Now()}, nil
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name:    "msteams",
+		Aliases: []string{"teams"},
+		Command: Send,
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			var cfg Config
+			if err := notifier.Load(src, &cfg); err != nil {
+				return nil, err
+			}
+			return New(cfg), nil
+		},
+	})
+}
+
+// SendMessage sends a message to microsoft teams channels.
+// webhooks can be comma-separated string of webhook urls.
+func SendMessage(ctx context.Context, webhooks, title, message string) error {
+	if webhooks == "" {
+		return fmt.Errorf("teams webhook is required")
+	}
+	if message == "" {
+		return fmt.Errorf("message is required")
+	}
+
+	notifier := notify.New()
+	teamsSvc := msteams2.New()
+
+	chn := strings.Split(webhooks, ",")
+	for _, v := range chn {
+		v = strings.TrimSpace(v)
+		if len(v) <= 0 {
+			return helpers.ErrChannel
+		}
+		teamsSvc.AddReceivers(v)
+	}
+
+	notifier.UseServices(teamsSvc)
+
+	if err := notifier.Send(ctx, title, message); err != nil {
+		return fmt.Errorf("failed to send teams message: %w", err)
+	}
+
+	log.Println("Successfully sent!")
+	return nil
 }
 
 // Send parse values from *cli.context and return *cli.Command.
@@ -46,6 +120,7 @@ you can add permissions for multiple channels to single webhook.`,
 				Usage:       "Message content.",
 				EnvVars:     []string{"TEAMS_MESSAGE"},
 			},
+			helpers.MessageFileFlag(&msTeamOpt.MessageFile, "TEAMS"),
 			&cli.StringFlag{
 				Destination: &msTeamOpt.Title,
 				Name:        "title",
@@ -55,28 +130,19 @@ you can add permissions for multiple channels to single webhook.`,
 			},
 		},
 		Action: func(ctx *cli.Context) error {
-			notifier := notify.New()
-			teamsSvc := msteams2.New()
-
-			chn := strings.Split(msTeamOpt.Webhook, ",")
-			for _, v := range chn {
-				if len(v) <= 0 {
-					return helpers.ErrChannel
-				}
-				teamsSvc.AddReceivers(v)
-			}
-
-			notifier.UseServices(teamsSvc)
-
-			if err := notifier.Send(
-				context.Background(),
-				msTeamOpt.Title,
-				msTeamOpt.Message,
-			); err != nil {
+			message, err := helpers.ReadMessage(msTeamOpt.Message, msTeamOpt.MessageFile)
+			if err != nil {
 				return err
 			}
-			log.Println("Successfully sent!")
-			return nil
+
+			return helpers.Retry(ctx, func() error {
+				return SendMessage(
+					ctx.Context,
+					msTeamOpt.Webhook,
+					msTeamOpt.Title,
+					message,
+				)
+			})
 		},
 	}
 }
@@ -0,0 +1,76 @@
+package notifier
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+)
+
+// Source resolves the value of a config field. key is the name of the field
+// in config files and env its environment variable name. It returns an empty
+// string when the value is not set.
+type Source func(key, env string) string
+
+// Env is a Source backed by the process environment.
+var Env Source = func(_, env string) string {
+	return os.Getenv(env)
+}
+
+// Load populates the exported fields of the struct pointed to by cfg.
+// Every field tagged with `config:"key" env:"NAME"` is resolved through src,
+// falling back to the value of the `default` tag when src returns an empty
+// string.
+// Supported field kinds are string, bool and int.
+func Load(src Source, cfg interface{}) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("notifier: config must be a pointer to struct, got %T", cfg)
+	}
+	v = v.Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		env, ok := field.Tag.Lookup("env")
+		if !ok || !field.IsExported() {
+			continue
+		}
+
+		raw := src(field.Tag.Get("config"), env)
+		if raw == "" {
+			raw = field.Tag.Get("default")
+		}
+		if raw == "" {
+			continue
+		}
+
+		if err := setField(v.Field(i), raw); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", env, err)
+		}
+	}
+	return nil
+}
+
+// setField assigns raw to f converting it to the kind of the field.
+func setField(f reflect.Value, raw string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	default:
+		return fmt.Errorf("unsupported field kind %s", f.Kind())
+	}
+	return nil
+}
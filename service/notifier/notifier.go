@@ -0,0 +1,139 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Message is the service independent representation of a notification.
+type Message struct {
+	Title    string
+	Body     string
+	Priority int
+	// PrioritySet marks Priority as set explicitly, services then use a
+	// priority of 0 instead of their configured default.
+	PrioritySet bool
+	Extra       map[string]interface{}
+}
+
+// Result holds the outcome of a successful delivery.
+type Result struct {
+	Service string
+	SentAt  time.Time
+	// Receipts identify deliveries the recipient can acknowledge, see
+	// Acknowledger.
+	Receipts []string
+}
+
+// Notifier is implemented by every service that can deliver a Message.
+type Notifier interface {
+	Send(ctx context.Context, msg Message) (Result, error)
+}
+
+// Acknowledger is implemented by notifiers whose recipients can
+// acknowledge a message, e.g. Pushover emergency notifications.
+type Acknowledger interface {
+	// Acknowledged reports whether the delivery of receipt was
+	// acknowledged and by whom.
+	Acknowledged(ctx context.Context, receipt string) (by string, ok bool, err error)
+}
+
+// Service describes a notification service registered with pingme.
+type Service struct {
+	// Name is used to select the service, e.g. "slack" or "telegram".
+	Name string
+	// Aliases are alternative names accepted by Lookup.
+	Aliases []string
+	// Command returns the cli command for the service.
+	Command func() *cli.Command
+	// New builds a Notifier from settings resolved through src.
+	New func(src Source) (Notifier, error)
+}
+
+var (
+	mu       sync.RWMutex
+	services = make(map[string]Service)
+)
+
+// Register adds a service to the registry, it panics if a service
+// with the same name has already been registered.
+func Register(svc Service) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if svc.Name == "" || svc.New == nil {
+		panic("notifier: Register called with incomplete service")
+	}
+	if _, dup := services[svc.Name]; dup {
+		panic(fmt.Sprintf("notifier: Register called twice for service %q", svc.Name))
+	}
+	services[svc.Name] = svc
+}
+
+// Lookup returns the service registered under name or one of its aliases.
+func Lookup(name string) (Service, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	if svc, ok := services[name]; ok {
+		return svc, true
+	}
+	for _, svc := range services {
+		for _, alias := range svc.Aliases {
+			if alias == name {
+				return svc, true
+			}
+		}
+	}
+	return Service{}, false
+}
+
+// Services returns all registered services sorted by name.
+func Services() []Service {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	list := make([]Service, 0, len(services))
+	for _, svc := range services {
+		list = append(list, svc)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Names returns the names of all registered services sorted alphabetically.
+func Names() []string {
+	list := Services()
+	names := make([]string, len(list))
+	for i, svc := range list {
+		names[i] = svc.Name
+	}
+	return names
+}
+
+// Commands returns the cli commands of all registered services.
+func Commands() []*cli.Command {
+	var cmds []*cli.Command
+	for _, svc := range Services() {
+		if svc.Command != nil {
+			cmds = append(cmds, svc.Command())
+		}
+	}
+	return cmds
+}
+
+// New looks up the named service and builds its Notifier from src.
+func New(name string, src Source) (Notifier, error) {
+	svc, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unsupported service: %s", name)
+	}
+	return svc.New(src)
+}
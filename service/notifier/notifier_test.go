@@ -0,0 +1,82 @@
+package notifier
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testConfig struct {
+	Token    string `config:"token" env:"TEST_TOKEN"`
+	Scheme   string `config:"scheme" env:"TEST_SCHEME" default:"https"`
+	Priority int    `config:"priority" env:"TEST_PRIORITY"`
+	Enabled  bool   `config:"enabled" env:"TEST_ENABLED"`
+	ignored  string
+}
+
+type testNotifier struct{}
+
+func (testNotifier) Send(ctx context.Context, msg Message) (Result, error) {
+	return Result{Service: "test"}, nil
+}
+
+func mapSource(m map[string]string) Source {
+	return func(key, env string) string { return m[env] }
+}
+
+func TestLoad(t *testing.T) {
+	var cfg testConfig
+	err := Load(mapSource(map[string]string{
+		"TEST_TOKEN":    "abc",
+		"TEST_PRIORITY": "2",
+		"TEST_ENABLED":  "true",
+	}), &cfg)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", cfg.Token)
+	assert.Equal(t, "https", cfg.Scheme)
+	assert.Equal(t, 2, cfg.Priority)
+	assert.True(t, cfg.Enabled)
+	assert.Empty(t, cfg.ignored)
+}
+
+func TestLoad_InvalidValue(t *testing.T) {
+	var cfg testConfig
+	err := Load(mapSource(map[string]string{"TEST_PRIORITY": "high"}), &cfg)
+	assert.NotNil(t, err)
+}
+
+func TestLoad_NotPointer(t *testing.T) {
+	err := Load(Env, testConfig{})
+	assert.NotNil(t, err)
+}
+
+func TestRegisterLookup(t *testing.T) {
+	Register(Service{
+		Name:    "test-service",
+		Aliases: []string{"test-alias"},
+		New: func(src Source) (Notifier, error) {
+			return testNotifier{}, nil
+		},
+	})
+
+	svc, ok := Lookup("test-service")
+	assert.True(t, ok)
+	assert.Equal(t, "test-service", svc.Name)
+
+	svc, ok = Lookup("Test-Alias")
+	assert.True(t, ok)
+	assert.Equal(t, "test-service", svc.Name)
+
+	_, ok = Lookup("missing")
+	assert.False(t, ok)
+
+	assert.Contains(t, Names(), "test-service")
+	assert.Panics(t, func() {
+		Register(Service{Name: "test-service", New: svc.New})
+	})
+
+	_, err := New("missing", Env)
+	assert.NotNil(t, err)
+}
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
 
 	"github.com/nikoksr/notify"
 	"github.com/nikoksr/notify/service/pushbullet"
@@ -17,15 +19,62 @@ import (
 type pushBullet struct {
 	Token       string
 	Message     string
+	MessageFile string
 	Title       string
 	Device      string
 	PhoneNumber string
 	SMS         bool
 }
 
+// Config holds the settings used by the pushbullet Notifier.
+type Config struct {
+	Token   string `config:"token" env:"PUSHBULLET_TOKEN"`
+	Devices string `config:"devices" env:"PUSHBULLET_DEVICE"`
+	Number  string `config:"number" env:"PUSHBULLET_NUMBER"`
+	SMS     bool   `config:"sms" env:"PUSHBULLET_SMS"`
+}
+
+// Notifier sends pushbullet notes or SMS messages.
+type Notifier struct {
+	Config
+}
+
+// New returns a Notifier for the given config.
+func New(cfg Config) *Notifier {
+	return &Notifier{Config: cfg}
+}
+
+// Send implements notifier.Notifier.
+func (n *Notifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	var err error
+	if n.SMS {
+		err = SendSMS(ctx, n.Token, n.Devices, n.Number, msg.Title, msg.Body)
+	} else {
+		err = SendMessage(ctx, n.Token, n.Devices, msg.Title, msg.Body)
+	}
+	if err != nil {
+		return notifier.Result{}, err
+	}
+	return notifier.Result{Service: "pushbullet", SentAt: time.Now()}, nil
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name:    "pushbullet",
+		Command: Send,
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			var cfg Config
+			if err := notifier.Load(src, &cfg); err != nil {
+				return nil, err
+			}
+			return New(cfg), nil
+		},
+	})
+}
+
 // SendMessage sends a message via pushbullet to devices.
 // devices can be comma-separated string of device nicknames.
-func SendMessage(token, devices, title, message string) error {
+func SendMessage(ctx context.Context, token, devices, title, message string) error {
 	if token == "" {
 		return fmt.Errorf("pushbullet token is required")
 	}
@@ -50,7 +99,7 @@ func SendMessage(token, devices, title, message string) error {
 
 	notifier.UseServices(pushBulletSvc)
 
-	if err := notifier.Send(context.Background(), title, message); err != nil {
+	if err := notifier.Send(ctx, title, message); err != nil {
 		return fmt.Errorf("failed to send pushbullet message: %w", err)
 	}
 
@@ -59,7 +108,7 @@ func SendMessage(token, devices, title, message string) error {
 }
 
 // SendSMS sends an SMS via pushbullet.
-func SendSMS(token, device, phoneNumber, title, message string) error {
+func SendSMS(ctx context.Context, token, device, phoneNumber, title, message string) error {
 	if token == "" {
 		return fmt.Errorf("pushbullet token is required")
 	}
@@ -81,7 +130,7 @@ func SendSMS(token, device, phoneNumber, title, message string) error {
 	}
 
 	numbers := strings.Split(phoneNumber, ",")
-	for _, v := range numbers {
+	for i, v := range numbers {
 		v = strings.TrimSpace(v)
 		if len(v) <= 0 {
 			return helpers.ErrChannel
@@ -90,8 +139,8 @@ func SendSMS(token, device, phoneNumber, title, message string) error {
 
 		notifier.UseServices(pushBulletSmsSvc)
 
-		if err := notifier.Send(context.Background(), title, message); err != nil {
-			return fmt.Errorf("failed to send SMS to %s: %w", v, err)
+		if err := notifier.Send(ctx, title, message); err != nil {
+			return helpers.Partial(i, fmt.Errorf("failed to send SMS to %s: %w", v, err))
 		}
 	}
 
@@ -140,6 +189,7 @@ Multiple device nicknames or numbers can be used separated by comma.`,
 				Usage:       "Message content.",
 				EnvVars:     []string{"PUSHBULLET_MESSAGE"},
 			},
+			helpers.MessageFileFlag(&pushBulletOpts.MessageFile, "PUSHBULLET"),
 			&cli.StringFlag{
 				Destination: &pushBulletOpts.Title,
 				Name:        "title",
@@ -156,21 +206,32 @@ Multiple device nicknames or numbers can be used separated by comma.`,
 			},
 		},
 		Action: func(ctx *cli.Context) error {
+			message, err := helpers.ReadMessage(pushBulletOpts.Message, pushBulletOpts.MessageFile)
+			if err != nil {
+				return err
+			}
+
 			if pushBulletOpts.SMS {
-				return SendSMS(
+				return helpers.Retry(ctx, func() error {
+					return SendSMS(
+						ctx.Context,
+						pushBulletOpts.Token,
+						pushBulletOpts.Device,
+						pushBulletOpts.PhoneNumber,
+						pushBulletOpts.Title,
+						message,
+					)
+				})
+			}
+			return helpers.Retry(ctx, func() error {
+				return SendMessage(
+					ctx.Context,
 					pushBulletOpts.Token,
 					pushBulletOpts.Device,
-					pushBulletOpts.PhoneNumber,
 					pushBulletOpts.Title,
-					pushBulletOpts.Message,
+					message,
 				)
-			}
-			return SendMessage(
-				pushBulletOpts.Token,
-				pushBulletOpts.Device,
-				pushBulletOpts.Title,
-				pushBulletOpts.Message,
-			)
+			})
 		},
 	}
 }
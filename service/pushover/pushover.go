@@ -1,11 +1,18 @@
 package pushover
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
 
 	"github.com/gregdel/pushover"
 	"github.com/urfave/cli/v2"
@@ -13,52 +20,186 @@ import (
 
 // pushOver struct holds data parsed via flags for pushover service
 type pushOver struct {
-	Token     string
-	Recipient string
-	Message   string
-	Title     string
-	Priority  int
+	Token       string
+	Recipient   string
+	Message     string
+	MessageFile string
+	Title       string
+	Priority    int
+}
+
+const (
+	// emergencyRetry is how often pushover repeats an emergency
+	// notification until it is acknowledged
+	emergencyRetry = 60 * time.Second
+	// emergencyExpire is how long an emergency notification is repeated
+	emergencyExpire = time.Hour
+)
+
+// HTTPClient interface
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Client sends the requests to the pushover API, the pushover client
+// library does not accept a context.
+var Client HTTPClient = &http.Client{Timeout: helpers.DefaultTimeout}
+
+// receiptDetails is the status of an emergency notification, the
+// timestamps of pushover.ReceiptDetails are not needed and missing from
+// error responses.
+type receiptDetails struct {
+	Status         int             `json:"status"`
+	Acknowledged   int             `json:"acknowledged"`
+	AcknowledgedBy string          `json:"acknowledged_by"`
+	Errors         pushover.Errors `json:"errors"`
+}
+
+// Config holds the settings used by the pushover Notifier.
+type Config struct {
+	Token    string `config:"token" env:"PUSHOVER_TOKEN"`
+	Users    string `config:"users" env:"PUSHOVER_USER"`
+	Priority int    `config:"priority" env:"PUSHOVER_PRIORITY"`
+}
+
+// Notifier sends messages to pushover users.
+type Notifier struct {
+	Config
+}
+
+// New returns a Notifier for the given config.
+func New(cfg Config) *Notifier {
+	return &Notifier{Config: cfg}
+}
+
+// Send implements notifier.Notifier.
+func (n *Notifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	priority := n.Priority
+	if msg.Priority != 0 || msg.PrioritySet {
+		priority = msg.Priority
+	}
+	receipts, err := sendMessage(ctx, n.Token, n.Users, msg.Title, msg.Body, priority)
+	if err != nil {
+		return notifier.Result{}, err
+	}
+	return notifier.Result{Service: "pushover", SentAt: time.Now(), Receipts: receipts}, nil
+}
+
+// Acknowledged implements notifier.Acknowledger, receipts are returned for
+// emergency notifications with priority 2.
+func (n *Notifier) Acknowledged(ctx context.Context, receipt string) (string, bool, error) {
+	endpoint := fmt.Sprintf("%s/receipts/%s.json?token=%s", pushover.APIEndpoint,
+		url.PathEscape(receipt), url.QueryEscape(n.Token))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", false, err
+	}
+	var details receiptDetails
+	if err = do(req, &details); err == nil && details.Status != 1 {
+		err = details.Errors
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get receipt %s: %w", receipt, err)
+	}
+	if details.Acknowledged != 1 {
+		return "", false, nil
+	}
+	return details.AcknowledgedBy, true, nil
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name:    "pushover",
+		Command: Send,
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			var cfg Config
+			if err := notifier.Load(src, &cfg); err != nil {
+				return nil, err
+			}
+			return New(cfg), nil
+		},
+	})
 }
 
 // SendMessage sends a message to pushover users.
 // This is the core logic extracted for reuse by both CLI and webhook.
 // recipients can be comma-separated string of user tokens.
-func SendMessage(token, recipients, title, message string, priority int) error {
+func SendMessage(ctx context.Context, token, recipients, title, message string, priority int) error {
+	_, err := sendMessage(ctx, token, recipients, title, message, priority)
+	return err
+}
+
+// sendMessage sends a message to pushover users and returns the receipts
+// of emergency notifications.
+func sendMessage(ctx context.Context, token, recipients, title, message string, priority int) ([]string, error) {
 	if token == "" {
-		return fmt.Errorf("pushover token is required")
+		return nil, fmt.Errorf("pushover token is required")
 	}
 	if recipients == "" {
-		return fmt.Errorf("pushover user token is required")
+		return nil, fmt.Errorf("pushover user token is required")
 	}
 	if message == "" {
-		return fmt.Errorf("message is required")
-	}
-
-	app := pushover.New(token)
-
-	msg := &pushover.Message{
-		Title:    title,
-		Message:  message,
-		Priority: priority,
-		Retry:    60,
-		Expire:   3600,
+		return nil, fmt.Errorf("message is required")
 	}
 
 	users := strings.Split(recipients, ",")
 
-	for _, userToken := range users {
+	var receipts []string
+	for i, userToken := range users {
 		userToken = strings.TrimSpace(userToken)
 		if len(userToken) == 0 {
-			return helpers.ErrChannel
+			return receipts, helpers.ErrChannel
+		}
+		form := url.Values{
+			"token":    {token},
+			"user":     {userToken},
+			"message":  {message},
+			"priority": {strconv.Itoa(priority)},
 		}
-		recipient := pushover.NewRecipient(userToken)
-		responsePushOver, err := app.SendMessage(msg, recipient)
+		if title != "" {
+			form.Set("title", title)
+		}
+		if priority == pushover.PriorityEmergency {
+			form.Set("retry", strconv.Itoa(int(emergencyRetry.Seconds())))
+			form.Set("expire", strconv.Itoa(int(emergencyExpire.Seconds())))
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, pushover.APIEndpoint+"/messages.json",
+			strings.NewReader(form.Encode()))
 		if err != nil {
-			return fmt.Errorf("failed to send to user %s: %w", userToken, err)
+			return receipts, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		var responsePushOver pushover.Response
+		if err = do(req, &responsePushOver); err == nil && responsePushOver.Status != 1 {
+			err = responsePushOver.Errors
+		}
+		if err != nil {
+			// users before got it already, don't retry them
+			return receipts, helpers.Partial(i, fmt.Errorf("failed to send to user %d of %d: %w", i+1, len(users), err))
+		}
+		// user keys are credentials, log their position only
+		log.Printf("Successfully sent to user %d of %d!\n%v\n", i+1, len(users), responsePushOver)
+		if responsePushOver.Receipt != "" {
+			receipts = append(receipts, responsePushOver.Receipt)
 		}
-		log.Printf("Successfully sent to %s!\n%v\n", userToken, responsePushOver)
 	}
-	return nil
+	return receipts, nil
+}
+
+// do sends req to the pushover API and decodes the response into v.
+func do(req *http.Request, v interface{}) error {
+	resp, err := Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err = helpers.CheckResponse(resp); err != nil {
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
 }
 
 // Send parse values from *cli.context and return *cli.Command.
@@ -97,6 +238,7 @@ All configuration options are also available via environment variables.`,
 				Usage:       "Message content.",
 				EnvVars:     []string{"PUSHOVER_MESSAGE"},
 			},
+			helpers.MessageFileFlag(&pushOverOpts.MessageFile, "PUSHOVER"),
 			&cli.StringFlag{
 				Destination: &pushOverOpts.Title,
 				Name:        "title",
@@ -114,14 +256,22 @@ All configuration options are also available via environment variables.`,
 			},
 		},
 		Action: func(ctx *cli.Context) error {
+			message, err := helpers.ReadMessage(pushOverOpts.Message, pushOverOpts.MessageFile)
+			if err != nil {
+				return err
+			}
+
 			// Now just call the extracted function
-			return SendMessage(
-				pushOverOpts.Token,
-				pushOverOpts.Recipient,
-				pushOverOpts.Title,
-				pushOverOpts.Message,
-				pushOverOpts.Priority,
-			)
+			return helpers.Retry(ctx, func() error {
+				return SendMessage(
+					ctx.Context,
+					pushOverOpts.Token,
+					pushOverOpts.Recipient,
+					pushOverOpts.Title,
+					message,
+					pushOverOpts.Priority,
+				)
+			})
 		},
 	}
 }
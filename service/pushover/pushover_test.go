@@ -0,0 +1,141 @@
+package pushover
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gregdel/pushover"
+	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
+	"github.com/stretchr/testify/assert"
+)
+
+// apiServer replaces the pushover API with handler
+func apiServer(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	endpoint := pushover.APIEndpoint
+	pushover.APIEndpoint = srv.URL
+	t.Cleanup(func() {
+		pushover.APIEndpoint = endpoint
+		srv.Close()
+	})
+}
+
+func TestSendMessage_Emergency(t *testing.T) {
+	var form url.Values
+	apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/messages.json", r.URL.Path)
+		assert.Nil(t, r.ParseForm())
+		form = r.PostForm
+		_, _ = w.Write([]byte(`{"status":1,"request":"req","receipt":"rcpt"}`))
+	})
+
+	receipts, err := sendMessage(context.Background(), "token", "user-1", "Disk full", "db-1", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rcpt"}, receipts)
+	assert.Equal(t, "user-1", form.Get("user"))
+	assert.Equal(t, "Disk full", form.Get("title"))
+	assert.Equal(t, "2", form.Get("priority"))
+	assert.Equal(t, "60", form.Get("retry"))
+	assert.Equal(t, "3600", form.Get("expire"))
+}
+
+func TestSend_Priority(t *testing.T) {
+	var priorities []string
+	apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, r.ParseForm())
+		priorities = append(priorities, r.PostForm.Get("priority"))
+		_, _ = w.Write([]byte(`{"status":1,"request":"req"}`))
+	})
+
+	n := New(Config{Token: "token", Users: "user-1", Priority: 1})
+	for _, msg := range []notifier.Message{
+		{Body: "default"},
+		{Body: "explicit", Priority: -1},
+		{Body: "downgraded", Priority: 0, PrioritySet: true},
+	} {
+		_, err := n.Send(context.Background(), msg)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, []string{"1", "-1", "0"}, priorities)
+}
+
+func TestSendMessage_Errors(t *testing.T) {
+	apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"status":0,"errors":["user identifier is invalid"]}`))
+	})
+
+	err := SendMessage(context.Background(), "token", "user-1", "title", "hello", 0)
+	assert.ErrorContains(t, err, "user identifier is invalid")
+}
+
+func TestSendMessage_Partial(t *testing.T) {
+	apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, r.ParseForm())
+		if r.PostForm.Get("user") == "user-2" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":1,"request":"req"}`))
+	})
+
+	// user-1 got the message, retrying would send it again
+	err := SendMessage(context.Background(), "token", "user-2", "title", "hello", 0)
+	retry, _ := helpers.Retryable(err)
+	assert.True(t, retry)
+	err = SendMessage(context.Background(), "token", "user-1,user-2", "title", "hello", 0)
+	retry, _ = helpers.Retryable(err)
+	assert.False(t, retry)
+
+	// user keys are credentials and not included in errors
+	assert.ErrorContains(t, err, "user 2 of 2")
+	assert.NotContains(t, err.Error(), "user-2")
+}
+
+func TestSendMessage_Cancelled(t *testing.T) {
+	apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		// the context of the request is cancelled when the client goes away
+		// once the body is read
+		_ = r.ParseForm()
+		<-r.Context().Done()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	err := SendMessage(ctx, "token", "user-1", "title", "hello", 0)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestAcknowledged(t *testing.T) {
+	apiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "token", r.URL.Query().Get("token"))
+		switch r.URL.Path {
+		case "/receipts/acked.json":
+			_, _ = w.Write([]byte(`{"status":1,"acknowledged":1,"acknowledged_by":"alice"}`))
+		case "/receipts/open.json":
+			_, _ = w.Write([]byte(`{"status":1,"acknowledged":0}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"status":0,"errors":["receipt not found"]}`))
+		}
+	})
+
+	n := New(Config{Token: "token"})
+	by, ok, err := n.Acknowledged(context.Background(), "acked")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", by)
+
+	_, ok, err = n.Acknowledged(context.Background(), "open")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, _, err = n.Acknowledged(context.Background(), "unknown")
+	assert.NotNil(t, err)
+}
@@ -5,26 +5,71 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
+
 	"github.com/nikoksr/notify"
 	"github.com/nikoksr/notify/service/rocketchat"
 	"github.com/urfave/cli/v2"
 )
 
 type rocketChat struct {
-	Token     string
-	UserID    string
-	Message   string
-	Channel   string
-	Title     string
-	ServerURL string
-	Scheme    string
+	Token       string
+	UserID      string
+	Message     string
+	MessageFile string
+	Channel     string
+	Title       string
+	ServerURL   string
+	Scheme      string
+}
+
+// Config holds the settings used by the rocketchat Notifier.
+type Config struct {
+	ServerURL string `config:"server_url" env:"ROCKETCHAT_SERVER_URL"`
+	Scheme    string `config:"scheme" env:"ROCKETCHAT_URL_SCHEME" default:"https"`
+	UserID    string `config:"user_id" env:"ROCKETCHAT_USERID"`
+	Token     string `config:"token" env:"ROCKETCHAT_TOKEN"`
+	Channels  string `config:"channels" env:"ROCKETCHAT_CHANNELS"`
+}
+
+// Notifier sends messages to rocketchat channels.
+type Notifier struct {
+	Config
+}
+
+// New returns a Notifier for the given config.
+func New(cfg Config) *Notifier {
+	return &Notifier{Config: cfg}
+}
+
+// Send implements notifier.Notifier.
+func (n *Notifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	if err := SendMessage(ctx, n.ServerURL, n.Scheme, n.UserID, n.Token, n.Channels, msg.Title, msg.Body); err != nil {
+		return notifier.Result{}, err
+	}
+	return notifier.Result{Service: "rocketchat", SentAt: time.Now()}, nil
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name:    "rocketchat",
+		Command: Send,
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			var cfg Config
+			if err := notifier.Load(src, &cfg); err != nil {
+				return nil, err
+			}
+			return New(cfg), nil
+		},
+	})
 }
 
 // SendMessage sends a message to rocketchat channels.
 // channels can be comma-separated string of channel names.
-func SendMessage(serverURL, scheme, userID, token, channels, title, message string) error {
+func SendMessage(ctx context.Context, serverURL, scheme, userID, token, channels, title, message string) error {
 	if serverURL == "" {
 		return fmt.Errorf("rocketchat server URL is required")
 	}
@@ -59,7 +104,7 @@ func SendMessage(serverURL, scheme, userID, token, channels, title, message stri
 
 	notifier.UseServices(rocketChatSvc)
 
-	if err = notifier.Send(context.Background(), title, message); err != nil {
+	if err = notifier.Send(ctx, title, message); err != nil {
 		return fmt.Errorf("failed to send rocketchat message: %w", err)
 	}
 
@@ -124,6 +169,7 @@ All configuration options are also available via environment variables.`,
 				Usage:       "Message content",
 				EnvVars:     []string{"ROCKETCHAT_MESSAGE"},
 			},
+			helpers.MessageFileFlag(&rocketChatOpts.MessageFile, "ROCKETCHAT"),
 			&cli.StringFlag{
 				Destination: &rocketChatOpts.Title,
 				Name:        "title",
@@ -133,15 +179,23 @@ All configuration options are also available via environment variables.`,
 			},
 		},
 		Action: func(ctx *cli.Context) error {
-			return SendMessage(
-				rocketChatOpts.ServerURL,
-				rocketChatOpts.Scheme,
-				rocketChatOpts.UserID,
-				rocketChatOpts.Token,
-				rocketChatOpts.Channel,
-				rocketChatOpts.Title,
-				rocketChatOpts.Message,
-			)
+			message, err := helpers.ReadMessage(rocketChatOpts.Message, rocketChatOpts.MessageFile)
+			if err != nil {
+				return err
+			}
+
+			return helpers.Retry(ctx, func() error {
+				return SendMessage(
+					ctx.Context,
+					rocketChatOpts.ServerURL,
+					rocketChatOpts.Scheme,
+					rocketChatOpts.UserID,
+					rocketChatOpts.Token,
+					rocketChatOpts.Channel,
+					rocketChatOpts.Title,
+					message,
+				)
+			})
 		},
 	}
 }
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
 
 	"github.com/nikoksr/notify"
 	"github.com/nikoksr/notify/service/slack"
@@ -15,15 +17,54 @@ import (
 
 // slackPingMe struct holds data parsed via flags for slack service.
 type slackPingMe struct {
-	Token   string
-	Message string
-	Channel string
-	Title   string
+	Token       string
+	Message     string
+	MessageFile string
+	Channel     string
+	Title       string
+}
+
+// Config holds the settings used by the slack Notifier.
+type Config struct {
+	Token    string `config:"token" env:"SLACK_TOKEN"`
+	Channels string `config:"channels" env:"SLACK_CHANNELS"`
+}
+
+// Notifier sends messages to slack channels.
+type Notifier struct {
+	Config
+}
+
+// New returns a Notifier for the given config.
+func New(cfg Config) *Notifier {
+	return &Notifier{Config: cfg}
+}
+
+// Send implements notifier.Notifier.
+func (n *Notifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	if err := SendMessage(ctx, n.Token, n.Channels, msg.Title, msg.Body); err != nil {
+		return notifier.Result{}, err
+	}
+	return notifier.Result{Service: "slack", SentAt: time.Now()}, nil
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name:    "slack",
+		Command: Send,
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			var cfg Config
+			if err := notifier.Load(src, &cfg); err != nil {
+				return nil, err
+			}
+			return New(cfg), nil
+		},
+	})
 }
 
 // SendMessage sends a message to slack channels.
 // channels can be comma-separated string of channel IDs.
-func SendMessage(token, channels, title, message string) error {
+func SendMessage(ctx context.Context, token, channels, title, message string) error {
 	if token == "" {
 		return fmt.Errorf("slack token is required")
 	}
@@ -48,7 +89,7 @@ func SendMessage(token, channels, title, message string) error {
 
 	notifier.UseServices(slackSvc)
 
-	if err := notifier.Send(context.Background(), title, message); err != nil {
+	if err := notifier.Send(ctx, title, message); err != nil {
 		return fmt.Errorf("failed to send slack message: %w", err)
 	}
 
@@ -90,6 +131,7 @@ All configuration options are also available via environment variables.`,
 				Usage:       "Message content.",
 				EnvVars:     []string{"SLACK_MESSAGE"},
 			},
+			helpers.MessageFileFlag(&slackOpts.MessageFile, "SLACK"),
 			&cli.StringFlag{
 				Destination: &slackOpts.Title,
 				Name:        "title",
@@ -99,12 +141,20 @@ All configuration options are also available via environment variables.`,
 			},
 		},
 		Action: func(ctx *cli.Context) error {
-			return SendMessage(
-				slackOpts.Token,
-				slackOpts.Channel,
-				slackOpts.Title,
-				slackOpts.Message,
-			)
+			message, err := helpers.ReadMessage(slackOpts.Message, slackOpts.MessageFile)
+			if err != nil {
+				return err
+			}
+
+			return helpers.Retry(ctx, func() error {
+				return SendMessage(
+					ctx.Context,
+					slackOpts.Token,
+					slackOpts.Channel,
+					slackOpts.Title,
+					message,
+				)
+			})
 		},
 	}
 }
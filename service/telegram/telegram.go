@@ -6,8 +6,10 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
 
 	"github.com/nikoksr/notify"
 	"github.com/nikoksr/notify/service/telegram"
@@ -16,16 +18,59 @@ import (
 
 // teleGram struct holds data parsed via flags for telegram service.
 type teleGram struct {
-	Token   string
-	Message string
-	Channel string
-	Title   string
+	Token       string
+	Message     string
+	MessageFile string
+	Channel     string
+	Title       string
+}
+
+// maxLength is the maximum length of a telegram message including the title.
+const maxLength = 4096
+
+// Config holds the settings used by the telegram Notifier.
+type Config struct {
+	Token    string `config:"token" env:"TELEGRAM_TOKEN"`
+	Channels string `config:"channels" env:"TELEGRAM_CHANNELS"`
+}
+
+// Notifier sends messages to telegram channels.
+type Notifier struct {
+	Config
+}
+
+// New returns a Notifier for the given config.
+func New(cfg Config) *Notifier {
+	return &Notifier{Config: cfg}
+}
+
+// Send implements notifier.Notifier.
+func (n *Notifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	title, body := helpers.Truncate(msg.Title, msg.Body, maxLength)
+	if err := SendMessage(ctx, n.Token, n.Channels, title, body); err != nil {
+		return notifier.Result{}, err
+	}
+	return notifier.Result{Service: "telegram", SentAt: time.Now()}, nil
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name:    "telegram",
+		Command: Send,
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			var cfg Config
+			if err := notifier.Load(src, &cfg); err != nil {
+				return nil, err
+			}
+			return New(cfg), nil
+		},
+	})
 }
 
 // SendMessage sends a message to telegram channels.
 // This is the core logic extracted for reuse by both CLI and webhook.
 // channels can be comma-separated string of channel IDs.
-func SendMessage(token, channels, title, message string) error {
+func SendMessage(ctx context.Context, token, channels, title, message string) error {
 	if token == "" {
 		return fmt.Errorf("telegram token is required")
 	}
@@ -58,7 +103,7 @@ func SendMessage(token, channels, title, message string) error {
 
 	notifier.UseServices(telegramSvc)
 
-	if err = notifier.Send(context.Background(), title, message); err != nil {
+	if err = notifier.Send(ctx, title, message); err != nil {
 		return fmt.Errorf("failed to send telegram message: %w", err)
 	}
 
@@ -103,6 +148,7 @@ All configuration options are also available via environment variables.`,
 				Usage:       "Message content.",
 				EnvVars:     []string{"TELEGRAM_MESSAGE"},
 			},
+			helpers.MessageFileFlag(&telegramOpts.MessageFile, "TELEGRAM"),
 			&cli.StringFlag{
 				Destination: &telegramOpts.Title,
 				Name:        "title",
@@ -112,13 +158,22 @@ All configuration options are also available via environment variables.`,
 			},
 		},
 		Action: func(ctx *cli.Context) error {
+			message, err := helpers.ReadMessage(telegramOpts.Message, telegramOpts.MessageFile)
+			if err != nil {
+				return err
+			}
+			telegramOpts.Title, message = helpers.Truncate(telegramOpts.Title, message, maxLength)
+
 			// Now just call the extracted function
-			return SendMessage(
-				telegramOpts.Token,
-				telegramOpts.Channel,
-				telegramOpts.Title,
-				telegramOpts.Message,
-			)
+			return helpers.Retry(ctx, func() error {
+				return SendMessage(
+					ctx.Context,
+					telegramOpts.Token,
+					telegramOpts.Channel,
+					telegramOpts.Title,
+					message,
+				)
+			})
 		},
 	}
 }
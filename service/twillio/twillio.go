@@ -1,28 +1,77 @@
 package twillio
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
+
 	"github.com/sfreiberg/gotwilio"
 	"github.com/urfave/cli/v2"
 )
 
 // Twillio struct holds data parsed via flags for the service
 type Twillio struct {
-	Title      string
-	Token      string
-	AccountSid string
-	Sender     string
-	Receiver   string
-	Message    string
+	Title       string
+	Token       string
+	AccountSid  string
+	Sender      string
+	Receiver    string
+	Message     string
+	MessageFile string
+}
+
+// maxLength is the maximum length of a twillio message including the title.
+const maxLength = 1600
+
+// Config holds the settings used by the twillio Notifier.
+type Config struct {
+	AccountSID string `config:"account_sid" env:"TWILLIO_ACCOUNT_SID"`
+	Token      string `config:"token" env:"TWILLIO_TOKEN"`
+	Sender     string `config:"sender" env:"TWILLIO_SENDER"`
+	Receivers  string `config:"receivers" env:"TWILLIO_RECEIVER"`
+}
+
+// Notifier sends SMS messages via twillio.
+type Notifier struct {
+	Config
+}
+
+// New returns a Notifier for the given config.
+func New(cfg Config) *Notifier {
+	return &Notifier{Config: cfg}
+}
+
+// Send implements notifier.Notifier.
+func (n *Notifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	title, body := helpers.Truncate(msg.Title, msg.Body, maxLength)
+	if err := SendMessage(ctx, n.AccountSID, n.Token, n.Sender, n.Receivers, title, body); err != nil {
+		return notifier.Result{}, err
+	}
+	return notifier.Result{Service: "twillio", SentAt: time.Now()}, nil
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name:    "twillio",
+		Command: Send,
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			var cfg Config
+			if err := notifier.Load(src, &cfg); err != nil {
+				return nil, err
+			}
+			return New(cfg), nil
+		},
+	})
 }
 
 // SendMessage sends SMS via twillio to multiple receivers.
 // receivers can be comma-separated string of phone numbers.
-func SendMessage(accountSID, token, sender, receivers, title, message string) error {
+func SendMessage(ctx context.Context, accountSID, token, sender, receivers, title, message string) error {
 	if accountSID == "" {
 		return fmt.Errorf("twillio account SID is required")
 	}
@@ -43,18 +92,18 @@ func SendMessage(accountSID, token, sender, receivers, title, message string) er
 	fullMessage := title + "\n" + message
 
 	numbers := strings.Split(receivers, ",")
-	for _, phoneNumber := range numbers {
+	for i, phoneNumber := range numbers {
 		phoneNumber = strings.TrimSpace(phoneNumber)
 		if len(phoneNumber) == 0 {
 			return helpers.ErrChannel
 		}
 
-		_, exception, err := client.SendSMS(sender, phoneNumber, fullMessage, "", "")
+		_, exception, err := client.SendSMSWithContext(ctx, sender, phoneNumber, fullMessage, "", "")
 		if err != nil {
-			return fmt.Errorf("failed to send SMS to %s: %w", phoneNumber, err)
+			return helpers.Partial(i, fmt.Errorf("failed to send SMS to %s: %w", phoneNumber, err))
 		}
 		if exception != nil {
-			return fmt.Errorf("twillio exception for %s: %v", phoneNumber, exception)
+			return helpers.Partial(i, fmt.Errorf("twillio exception for %s: %v", phoneNumber, exception))
 		}
 	}
 
@@ -97,6 +146,7 @@ You can specify multiple receivers by separating the value with a comma.`,
 				Usage:       "Message content.",
 				EnvVars:     []string{"TWILLIO_MESSAGE"},
 			},
+			helpers.MessageFileFlag(&twillioOpts.MessageFile, "TWILLIO"),
 			&cli.StringFlag{
 				Destination: &twillioOpts.Title,
 				Name:        "title",
@@ -119,14 +169,23 @@ You can specify multiple receivers by separating the value with a comma.`,
 			},
 		},
 		Action: func(ctx *cli.Context) error {
-			return SendMessage(
-				twillioOpts.AccountSid,
-				twillioOpts.Token,
-				twillioOpts.Sender,
-				twillioOpts.Receiver,
-				twillioOpts.Title,
-				twillioOpts.Message,
-			)
+			message, err := helpers.ReadMessage(twillioOpts.Message, twillioOpts.MessageFile)
+			if err != nil {
+				return err
+			}
+			twillioOpts.Title, message = helpers.Truncate(twillioOpts.Title, message, maxLength)
+
+			return helpers.Retry(ctx, func() error {
+				return SendMessage(
+					ctx.Context,
+					twillioOpts.AccountSid,
+					twillioOpts.Token,
+					twillioOpts.Sender,
+					twillioOpts.Receiver,
+					twillioOpts.Title,
+					message,
+				)
+			})
 		},
 	}
 }
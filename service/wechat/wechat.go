@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
+
 	"github.com/nikoksr/notify"
 	"github.com/nikoksr/notify/service/wechat"
 	"github.com/silenceper/wechat/v2/cache"
@@ -21,12 +24,54 @@ type Wechat struct {
 	EncodingAESKey string
 	Title          string
 	Message        string
+	MessageFile    string
 	Receivers      string
 }
 
+// Config holds the settings used by the wechat Notifier.
+type Config struct {
+	AppID          string `config:"app_id" env:"WECHAT_APPID"`
+	AppSecret      string `config:"app_secret" env:"WECHAT_APPSECRET"`
+	Token          string `config:"token" env:"WECHAT_TOKEN"`
+	EncodingAESKey string `config:"encoding_aes_key" env:"WECHAT_ENCODING_AES_KEY"`
+	Receivers      string `config:"receivers" env:"WECHAT_RECEIVERS"`
+}
+
+// Notifier sends messages to wechat official account receivers.
+type Notifier struct {
+	Config
+}
+
+// New returns a Notifier for the given config.
+func New(cfg Config) *Notifier {
+	return &Notifier{Config: cfg}
+}
+
+// Send implements notifier.Notifier.
+func (n *Notifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	if err := SendMessage(ctx, n.AppID, n.AppSecret, n.Token, n.EncodingAESKey, n.Receivers, msg.Title, msg.Body); err != nil {
+		return notifier.Result{}, err
+	}
+	return notifier.Result{Service: "wechat", SentAt: time.Now()}, nil
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name:    "wechat",
+		Command: Send,
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			var cfg Config
+			if err := notifier.Load(src, &cfg); err != nil {
+				return nil, err
+			}
+			return New(cfg), nil
+		},
+	})
+}
+
 // SendMessage sends a message to wechat official account receivers.
 // receivers can be comma-separated string of receiver IDs.
-func SendMessage(appID, appSecret, token, encodingAESKey, receivers, title, message string) error {
+func SendMessage(ctx context.Context, appID, appSecret, token, encodingAESKey, receivers, title, message string) error {
 	if appID == "" {
 		return fmt.Errorf("wechat app ID is required")
 	}
@@ -65,7 +110,7 @@ func SendMessage(appID, appSecret, token, encodingAESKey, receivers, title, mess
 	notifier := notify.New()
 	notifier.UseServices(wechatSvc)
 
-	err := notifier.Send(context.Background(), title, message)
+	err := notifier.Send(ctx, title, message)
 	if err != nil {
 		return fmt.Errorf("failed to send wechat message: %w", err)
 	}
@@ -124,10 +169,10 @@ Multiple receivers can be used separated by comma.`,
 			&cli.StringFlag{
 				Destination: &wechatOpts.Message,
 				Name:        "msg",
-				Required:    true,
 				Usage:       "Message content.",
 				EnvVars:     []string{"WECHAT_MESSAGE"},
 			},
+			helpers.MessageFileFlag(&wechatOpts.MessageFile, "WECHAT"),
 			&cli.StringFlag{
 				Destination: &wechatOpts.Title,
 				Name:        "title",
@@ -137,15 +182,23 @@ Multiple receivers can be used separated by comma.`,
 			},
 		},
 		Action: func(ctx *cli.Context) error {
-			return SendMessage(
-				wechatOpts.AppID,
-				wechatOpts.AppSecret,
-				wechatOpts.Token,
-				wechatOpts.EncodingAESKey,
-				wechatOpts.Receivers,
-				wechatOpts.Title,
-				wechatOpts.Message,
-			)
+			message, err := helpers.ReadMessage(wechatOpts.Message, wechatOpts.MessageFile)
+			if err != nil {
+				return err
+			}
+
+			return helpers.Retry(ctx, func() error {
+				return SendMessage(
+					ctx.Context,
+					wechatOpts.AppID,
+					wechatOpts.AppSecret,
+					wechatOpts.Token,
+					wechatOpts.EncodingAESKey,
+					wechatOpts.Receivers,
+					wechatOpts.Title,
+					message,
+				)
+			})
 		},
 	}
 }
@@ -1,6 +1,7 @@
 package zulip
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -9,17 +10,21 @@ import (
 	"strings"
 	"time"
 
+	"github.com/kha7iq/pingme/service/helpers"
+	"github.com/kha7iq/pingme/service/notifier"
+
 	"github.com/urfave/cli/v2"
 )
 
 // Zulip holds all the necessary options to use zulip
 type Zulip struct {
 	ZBot
-	Type    string
-	To      string
-	Topic   string
-	Content string
-	Domain  string
+	Type        string
+	To          string
+	Topic       string
+	Content     string
+	MessageFile string
+	Domain      string
 }
 
 type ZBot struct {
@@ -47,8 +52,54 @@ func initialize() {
 	}
 }
 
+// Config holds the settings used by the zulip Notifier.
+type Config struct {
+	Domain   string `config:"domain" env:"ZULIP_DOMAIN"`
+	BotEmail string `config:"bot_email" env:"ZULIP_BOT_EMAIL_ADDRESS"`
+	APIKey   string `config:"api_key" env:"ZULIP_BOT_API_KEY"`
+	Type     string `config:"type" env:"ZULIP_MSG_TYPE" default:"stream"`
+	To       string `config:"to" env:"ZULIP_STREAM_NAME"`
+	Topic    string `config:"topic" env:"ZULIP_TOPIC"`
+}
+
+// Notifier sends messages to zulip streams or users.
+type Notifier struct {
+	Config
+}
+
+// New returns a Notifier for the given config.
+func New(cfg Config) *Notifier {
+	return &Notifier{Config: cfg}
+}
+
+// Send implements notifier.Notifier.
+func (n *Notifier) Send(ctx context.Context, msg notifier.Message) (notifier.Result, error) {
+	topic := msg.Title
+	if topic == "" {
+		topic = n.Topic
+	}
+	if err := SendMessage(ctx, n.Domain, n.BotEmail, n.APIKey, n.Type, n.To, topic, msg.Body); err != nil {
+		return notifier.Result{}, err
+	}
+	return notifier.Result{Service: "zulip", SentAt: time.Now()}, nil
+}
+
+func init() {
+	notifier.Register(notifier.Service{
+		Name:    "zulip",
+		Command: Send,
+		New: func(src notifier.Source) (notifier.Notifier, error) {
+			var cfg Config
+			if err := notifier.Load(src, &cfg); err != nil {
+				return nil, err
+			}
+			return New(cfg), nil
+		},
+	})
+}
+
 // SendMessage sends a message to zulip stream or private user.
-func SendMessage(domain, botEmail, apiKey, msgType, to, topic, content string) error {
+func SendMessage(ctx context.Context, domain, botEmail, apiKey, msgType, to, topic, content string) error {
 	if domain == "" {
 		return fmt.Errorf("zulip domain is required")
 	}
@@ -79,7 +130,7 @@ func SendMessage(domain, botEmail, apiKey, msgType, to, topic, content string) e
 		Domain:  domain,
 	}
 
-	resp, err := SendZulipMessage(domain, zulipOpts)
+	resp, err := SendZulipMessage(ctx, domain, zulipOpts)
 	if err != nil {
 		return err
 	}
@@ -151,21 +202,29 @@ You can specify multiple userIds by separating the value with ','.`,
 				Destination: &zulipOpts.Content,
 				Name:        "msg",
 				Aliases:     []string{},
-				Required:    true,
 				Usage:       "The content of the message.",
 				EnvVars:     []string{"ZULIP_MESSAGE"},
 			},
+			helpers.MessageFileFlag(&zulipOpts.MessageFile, "ZULIP"),
 		},
 		Action: func(ctx *cli.Context) error {
-			return SendMessage(
-				zulipOpts.Domain,
-				zulipOpts.EmailID,
-				zulipOpts.APIKey,
-				zulipOpts.Type,
-				zulipOpts.To,
-				zulipOpts.Topic,
-				zulipOpts.Content,
-			)
+			message, err := helpers.ReadMessage(zulipOpts.Content, zulipOpts.MessageFile)
+			if err != nil {
+				return err
+			}
+
+			return helpers.Retry(ctx, func() error {
+				return SendMessage(
+					ctx.Context,
+					zulipOpts.Domain,
+					zulipOpts.EmailID,
+					zulipOpts.APIKey,
+					zulipOpts.Type,
+					zulipOpts.To,
+					zulipOpts.Topic,
+					message,
+				)
+			})
 		},
 	}
 }
@@ -181,7 +240,7 @@ func getTo(messageType string, to string) string {
 // SendZulipMessage function takes the zulip domain and zulip bot
 // type, to, topic and content in the form of json byte array and sends
 // message to zulip.
-func SendZulipMessage(zulipDomain string, zulipOpts Zulip) (*ZResponse, error) {
+func SendZulipMessage(ctx context.Context, zulipDomain string, zulipOpts Zulip) (*ZResponse, error) {
 	data := url.Values{}
 	data.Set("type", zulipOpts.Type)
 	data.Set("to", getTo(zulipOpts.Type, zulipOpts.To))
@@ -191,7 +250,7 @@ func SendZulipMessage(zulipDomain string, zulipOpts Zulip) (*ZResponse, error) {
 	var response ZResponse
 
 	endPointURL := "https://" + zulipDomain + "/api/v1/messages"
-	req, err := http.NewRequest("POST", endPointURL, strings.NewReader(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endPointURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		return nil, err
 	}
@@ -206,6 +265,10 @@ func SendZulipMessage(zulipDomain string, zulipOpts Zulip) (*ZResponse, error) {
 	}
 	defer resp.Body.Close()
 
+	if err = helpers.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
 	err = json.NewDecoder(resp.Body).Decode(&response)
 	if err != nil {
 		return nil, err
@@ -2,6 +2,7 @@ package zulip_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
@@ -57,7 +58,7 @@ func TestSendMessage_Success(t *testing.T) {
 		Domain:  "user.zulipchat.com",
 	}
 
-	resp, err := zulip.SendZulipMessage(z.Domain, z)
+	resp, err := zulip.SendZulipMessage(context.Background(), z.Domain, z)
 
 	assert.Nil(t, err)
 
@@ -96,7 +97,7 @@ func TestSendMessageStream_Fail(t *testing.T) {
 		Domain:  "user.zulipchat.com",
 	}
 
-	resp, err := zulip.SendZulipMessage(z.Domain, z)
+	resp, err := zulip.SendZulipMessage(context.Background(), z.Domain, z)
 
 	assert.Nil(t, err)
 
@@ -135,7 +136,7 @@ func TestSendMessagePrivate_Fail(t *testing.T) {
 		Domain:  "user.zulipchat.com",
 	}
 
-	resp, err := zulip.SendZulipMessage(z.Domain, z)
+	resp, err := zulip.SendZulipMessage(context.Background(), z.Domain, z)
 
 	assert.Nil(t, err)
 
@@ -173,7 +174,7 @@ func TestSendMessagePrivate_Success(t *testing.T) {
 		Domain:  "user.zulipchat.com",
 	}
 
-	resp, err := zulip.SendZulipMessage(z.Domain, z)
+	resp, err := zulip.SendZulipMessage(context.Background(), z.Domain, z)
 
 	assert.Nil(t, err)
 